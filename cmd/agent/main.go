@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
+	"time"
 
 	"unit/agent/internal/clients"
 	"unit/agent/internal/constants"
@@ -15,7 +18,7 @@ import (
 	"unit/agent/internal/services"
 	"unit/agent/internal/stores"
 
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/joho/godotenv"
 	hyperliquid "github.com/sonirico/go-hyperliquid"
@@ -29,10 +32,9 @@ func main() {
 
 	sepoliaUrl := os.Getenv("SEPOLIA_RPC_URL")
 	hotWalletAddr := os.Getenv("HOT_WALLET_ADDRESS")
-	hotWalletPrivKey := os.Getenv("HOT_WALLET_PRIVATE_KEY")
 	srcChains := []string{"ethereum", "hyperliquid"}
 	dstChains := []string{"ethereum", "hyperliquid"}
-	assets := []string{"eth"}
+	assets := []string{models.AssetEth, models.AssetUsdc}
 
 	ethClient, err := ethclient.Dial(sepoliaUrl)
 	if err != nil {
@@ -40,9 +42,7 @@ func main() {
 	}
 	fmt.Println("connected to eth client")
 
-	publisher := services.NewBlockPublisher(ethClient)
-
-	ks, err := stores.NewLocalKeyStore(constants.KeyStorePassword, constants.KeyStorePath)
+	ks, err := newKeyStore()
 	if err != nil {
 		log.Fatalf("failed to initialize key store %v", err)
 	}
@@ -54,26 +54,71 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to initialize state store %v", err)
 	}
+	cs, err := stores.NewLocalCheckpointStore(constants.CheckpointDbPath)
+	if err != nil {
+		log.Fatalf("failed to initialize checkpoint store %v", err)
+	}
+	ns, err := stores.NewLocalNonceStore(constants.NonceDbPath)
+	if err != nil {
+		log.Fatalf("failed to initialize nonce store %v", err)
+	}
+	ws, err := stores.NewLocalWebhookStore(constants.WebhookDbPath)
+	if err != nil {
+		log.Fatalf("failed to initialize webhook store %v", err)
+	}
+	aks, err := stores.NewLocalAPIKeyStore(constants.APIKeyDbPath)
+	if err != nil {
+		log.Fatalf("failed to initialize api key store %v", err)
+	}
+	if err := stores.RestoreKeys(context.Background(), ks, as); err != nil {
+		log.Fatalf("failed to restore deposit keys: %v", err)
+	}
 	fmt.Println("initialized stores")
 
+	publisher := services.NewBlockPublisher(ethClient, models.Ethereum, cs)
+
 	hlInfo := hyperliquid.NewInfo(context.Background(), hyperliquid.TestnetAPIURL, true, nil, nil)
-	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(hotWalletPrivKey, "0x"))
+	hlClient := clients.NewHttpClient("https://api.hyperliquid-testnet.xyz")
+
+	ledger := services.NewLedgerPublisher(hlClient, as, cs)
+
+	oracle, err := newPriceOracle(hlClient)
 	if err != nil {
-		log.Fatalf("failed to parse private key: %v", err)
+		log.Fatalf("failed to initialize price oracle: %v", err)
 	}
-	hlClient := clients.NewHttpClient("https://api.hyperliquid-testnet.xyz")
 
-	c := services.NewChainProvider(ks, map[models.Chain]*ethclient.Client{
+	c := services.NewChainProvider(ks, ns, map[models.Chain]*ethclient.Client{
 		models.Ethereum: ethClient,
-	}, hlInfo, privateKey, hlClient)
+	}, hlInfo, hlClient)
+	// how many times the latest base fee a tx is willing to pay, unused fees are refunded
+	feeMultiplier, err := envInt("MAX_FEE_MULTIPLIER", 2)
+	if err != nil {
+		log.Fatalf("failed to configure fee multiplier: %v", err)
+	}
+	c.SetMaxFeeMultiplier(uint64(feeMultiplier))
+	// 10 bps on every route, gas of the credit and the sweep is charged to the deposit
+	fees := services.NewFeeModel(c, oracle, models.FeeSchedule{Bps: 10, RecoverGas: true})
 	sm, err := services.NewStateMachine(c, as, st, map[models.Chain]string{
 		models.Ethereum:    hotWalletAddr,
 		models.Hyperliquid: hotWalletAddr,
-	})
+	}, fees)
 	if err != nil {
 		log.Fatalf("failed to initialize state machine: %v", err)
 	}
-	a := services.NewApi(ks, as, srcChains, dstChains, assets)
+	if err := configureConcurrency(sm); err != nil {
+		log.Fatalf("failed to configure state machine concurrency: %v", err)
+	}
+	broker := services.NewBroker()
+	sm.SetBroker(broker)
+	webhooks := services.NewWebhookDispatcher(ws, &http.Client{Timeout: 10 * time.Second})
+	sm.SetWebhooks(webhooks)
+	if err := sm.SyncNonces(context.Background()); err != nil {
+		log.Fatalf("failed to sync hot wallet nonces: %v", err)
+	}
+	a := services.NewApi(ks, as, st, aks, fees, srcChains, dstChains, assets)
+	a.SetAdmin(sm)
+	a.SetBroker(broker)
+	a.SetWebhooks(webhooks)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -102,6 +147,20 @@ func main() {
 		}
 	}()
 
+	go func() {
+		fmt.Println("starting ledger publisher")
+		if err := ledger.Start(ctx); err != nil {
+			log.Fatalf("ledger publisher stopped: %v", err)
+		}
+	}()
+
+	go func() {
+		fmt.Println("starting webhook dispatcher")
+		if err := webhooks.Start(ctx); err != nil {
+			log.Fatalf("webhook dispatcher stopped: %v", err)
+		}
+	}()
+
 	go func() {
 		fmt.Println("starting state machine")
 		if err := sm.Start(ctx); err != nil {
@@ -111,16 +170,37 @@ func main() {
 
 	for {
 		select {
-		case block, ok := <-publisher.Out():
+		case ev, ok := <-publisher.Out():
 			if !ok {
 				fmt.Println("block channel closed")
 				return
 			}
+			// reorgs arrive in order with the blocks, blocks published before it were already handled
+			if reorg := ev.Reorg; reorg != nil {
+				if err := sm.HandleReorg(ctx, reorg); err != nil {
+					log.Fatalf("error handling reorg of blocks %d-%d: %v", reorg.From, reorg.To, err)
+				}
+				if err := publisher.Rewind(ctx, reorg.From-1); err != nil {
+					log.Printf("error rewinding checkpoint to block %d: %v", reorg.From-1, err)
+				}
+				continue
+			}
+			block := ev.Block
 			fmt.Printf("block %d, hash=%s\n", block.NumberU64(), block.Hash().Hex())
 
-			if err := sm.ProcessBlock(ctx, block); err != nil {
+			processed, err := processBlock(ctx, sm, block)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				log.Fatalf("error processing block %d: %v", block.NumberU64(), err)
 			}
+			if !processed {
+				continue
+			}
+			if err := publisher.Commit(ctx, block.NumberU64(), block.Hash()); err != nil {
+				log.Printf("error committing checkpoint for block %d: %v", block.NumberU64(), err)
+			}
 
 		case err, ok := <-publisher.Err():
 			if !ok {
@@ -129,9 +209,129 @@ func main() {
 			}
 			log.Printf("publisher error: %v", err)
 
+		case batch, ok := <-ledger.Out():
+			if !ok {
+				fmt.Println("ledger channel closed")
+				return
+			}
+			if err := sm.ProcessTransfers(ctx, batch.Transfers); err != nil {
+				log.Fatalf("error processing ledger updates until %d: %v", batch.Until, err)
+			}
+			if err := ledger.Commit(ctx, batch.Until); err != nil {
+				log.Printf("error committing ledger checkpoint %d: %v", batch.Until, err)
+			}
+
+		case err, ok := <-ledger.Err():
+			if !ok {
+				fmt.Println("ledger error channel closed")
+				return
+			}
+			log.Printf("ledger publisher error: %v", err)
+
 		case <-ctx.Done():
 			fmt.Println("stopping")
 			return
 		}
 	}
 }
+
+// newKeyStore connects to the signer service if SIGNER_ENDPOINT is set, otherwise keys are held in process
+func newKeyStore() (keyStore, error) {
+	if endpoint := os.Getenv("SIGNER_ENDPOINT"); endpoint != "" {
+		client, err := clients.NewSignerClient(endpoint, []byte(os.Getenv("SIGNER_SECRET")))
+		if err != nil {
+			return nil, err
+		}
+		return stores.NewRemoteKeyStore(client), nil
+	}
+
+	// imported hot wallet key
+	hotKeys, err := stores.NewLocalKeyStore(constants.KeyStorePassword, constants.KeyStorePath)
+	if err != nil {
+		return nil, err
+	}
+	// deposit keys
+	hdKeys, err := stores.NewHDKeyStore(constants.KeyStorePassword, constants.SeedPath)
+	if err != nil {
+		return nil, err
+	}
+	return stores.NewMultiKeyStore(hdKeys, hotKeys), nil
+}
+
+// newPriceOracle prices ETH at the Hyperliquid ETH mid, quotes older than 30s are rejected. If ORACLE_REFERENCE_ETH_USDC
+// is set quotes deviating more than 5% from it are rejected as well.
+func newPriceOracle(hlClient *clients.HttpClient) (services.PriceOracle, error) {
+	oracle := services.NewCheckedOracle(services.NewHyperliquidOracle(hlClient, map[string]string{
+		models.AssetEth: "ETH",
+	}), 30*time.Second)
+
+	if price := os.Getenv("ORACLE_REFERENCE_ETH_USDC"); price != "" {
+		reference, err := services.NewStaticOracle(map[string]string{"eth/usdc": price})
+		if err != nil {
+			return nil, err
+		}
+		oracle.SetReference(reference, 500)
+	}
+	return oracle, nil
+}
+
+// configureConcurrency reads how many deposits transition in parallel from STATE_MACHINE_WORKERS (default 8), and how
+// many transitions spending from the hot wallet run at once per chain from ETHEREUM_TX_CONCURRENCY and
+// HYPERLIQUID_TX_CONCURRENCY (default 1).
+func configureConcurrency(sm *services.StateMachine) error {
+	workers, err := envInt("STATE_MACHINE_WORKERS", 8)
+	if err != nil {
+		return err
+	}
+	ethereum, err := envInt("ETHEREUM_TX_CONCURRENCY", 1)
+	if err != nil {
+		return err
+	}
+	hyperliquid, err := envInt("HYPERLIQUID_TX_CONCURRENCY", 1)
+	if err != nil {
+		return err
+	}
+	sm.SetConcurrency(workers, map[models.Chain]int{
+		models.Ethereum:    ethereum,
+		models.Hyperliquid: hyperliquid,
+	})
+	return nil
+}
+
+// envInt reads a positive integer from the environment, `def` is used when the variable is unset
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive integer", name, v)
+	}
+	return n, nil
+}
+
+type keyStore interface {
+	stores.IKeyStore
+	stores.IKeyRestorer
+}
+
+var blockRetryPolicy = services.RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// processBlock processes the block, retrying with backoff while the chain RPC fails. Blocks are processed in order,
+// later blocks wait until this one went through. Other errors are returned.
+func processBlock(ctx context.Context, sm *services.StateMachine, block *types.Block) (bool, error) {
+	for attempts := 1; ; attempts++ {
+		processed, err := sm.ProcessBlock(ctx, models.Ethereum, block)
+		if !errors.Is(err, services.ErrChainUnavailable) {
+			return processed, err
+		}
+		delay := blockRetryPolicy.Backoff(attempts)
+		log.Printf("error processing block %d, retrying in %s: %v", block.NumberU64(), delay, err)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+
+	"unit/agent/internal/constants"
+	"unit/agent/internal/models"
+	"unit/agent/internal/services"
+	"unit/agent/internal/stores"
+)
+
+// Creates an API key, e.g. the first admin key further keys are created with. Run with the agent stopped.
+func main() {
+	name := flag.String("name", "", "name of the key")
+	scopes := flag.String("scopes", "", "comma separated scopes: generate, read, admin")
+	flag.Parse()
+
+	var keyScopes []models.APIScope
+	for _, s := range strings.Split(*scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			keyScopes = append(keyScopes, models.APIScope(s))
+		}
+	}
+
+	key, token, err := services.NewAPIKey(*name, keyScopes, services.RateLimit{})
+	if err != nil {
+		log.Fatalf("failed to create api key: %v", err)
+	}
+	aks, err := stores.NewLocalAPIKeyStore(constants.APIKeyDbPath)
+	if err != nil {
+		log.Fatalf("failed to open api key store: %v", err)
+	}
+	defer aks.Close()
+	if err := aks.Put(context.Background(), key); err != nil {
+		log.Fatalf("failed to store api key: %v", err)
+	}
+
+	log.Printf("created api key %s (%s) with scopes %v, token: %s", key.Name, key.ID, key.Scopes, token)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"unit/agent/internal/constants"
+	"unit/agent/internal/stores"
+)
+
+// rebuilds deposit state projections from the append only event log. Agent must be stopped, bolt holds an exclusive lock.
+func main() {
+	st, err := stores.NewLocalStateStore(constants.StateDbPath)
+	if err != nil {
+		log.Fatalf("failed to initialize state store %v", err)
+	}
+	defer st.Close()
+
+	count, err := st.Replay(context.Background())
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+
+	log.Printf("rebuilt %d deposit projections", count)
+}
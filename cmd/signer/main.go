@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"unit/agent/internal/constants"
+	"unit/agent/internal/services"
+	"unit/agent/internal/stores"
+
+	"github.com/joho/godotenv"
+)
+
+// signer holds all private keys and signs for agents connecting over SIGNER_ENDPOINT
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	endpoint := os.Getenv("SIGNER_ENDPOINT")
+	secret := os.Getenv("SIGNER_SECRET")
+	if endpoint == "" || secret == "" {
+		log.Fatal("SIGNER_ENDPOINT and SIGNER_SECRET must be set")
+	}
+
+	hotKeys, err := stores.NewLocalKeyStore(constants.KeyStorePassword, constants.KeyStorePath)
+	if err != nil {
+		log.Fatalf("failed to initialize key store %v", err)
+	}
+	hdKeys, err := stores.NewHDKeyStore(constants.KeyStorePassword, constants.SeedPath)
+	if err != nil {
+		log.Fatalf("failed to initialize hd key store %v", err)
+	}
+	s := services.NewSignerServer(stores.NewMultiKeyStore(hdKeys, hotKeys), []byte(secret))
+
+	l, err := listen(endpoint)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", endpoint, err)
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigch
+		log.Println("stopping")
+		_ = s.Shutdown(context.Background())
+	}()
+
+	log.Printf("signer listening on %s", endpoint)
+	if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+func listen(endpoint string) (net.Listener, error) {
+	if socket, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+		// stale socket of a previous run
+		_ = os.Remove(socket)
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		// only the agent's user may connect
+		if err := os.Chmod(socket, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	// requests are authenticated but travel in the clear, there is no TLS to serve
+	if addr, ok := strings.CutPrefix(endpoint, "http://"); ok {
+		return net.Listen("tcp", addr)
+	}
+	return nil, fmt.Errorf("unsupported signer endpoint %q, expected unix:// or http://", endpoint)
+}
@@ -3,6 +3,7 @@ package clients
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -76,3 +77,31 @@ func TestHttpClient_Post_RequestError(t *testing.T) {
 		t.Fatal("expected error, got nil")
 	}
 }
+
+func TestSignerClient_RejectsForgedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"address":"0x1111111111111111111111111111111111111111","ok":true}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewSignerClient(srv.URL, []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewSignerClient: %v", err)
+	}
+	var resp SignerKeyResponse
+	if err := c.Call(context.Background(), "/keys/create", struct{}{}, &resp); !errors.Is(err, ErrSignerAuth) {
+		t.Fatalf("expected ErrSignerAuth, got %v", err)
+	}
+}
+
+func TestNewSignerClient_RejectsBadConfig(t *testing.T) {
+	if _, err := NewSignerClient("https://localhost:1", []byte("secret")); err == nil {
+		t.Fatal("expected error for https endpoint, the signer serves no TLS")
+	}
+	if _, err := NewSignerClient("tcp://localhost:1", []byte("secret")); err == nil {
+		t.Fatal("expected error for unsupported scheme")
+	}
+	if _, err := NewSignerClient("unix:///tmp/signer.sock", nil); err == nil {
+		t.Fatal("expected error for empty secret")
+	}
+}
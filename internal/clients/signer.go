@@ -0,0 +1,102 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"unit/agent/internal/utils/hmacsig"
+)
+
+// ErrSignerAuth is returned when a signer response does not carry a valid signature
+var ErrSignerAuth = errors.New("signer response failed authentication")
+
+// SignerClient calls the signer service over a Unix socket ("unix:///path/to/signer.sock") or HTTP ("http://host:port").
+// Requests and responses are authenticated both ways with an HMAC over a shared secret but not encrypted, the signer
+// serves no TLS and "https://" endpoints are rejected.
+type SignerClient struct {
+	baseURL    string
+	secret     []byte
+	httpClient *http.Client
+}
+
+func NewSignerClient(endpoint string, secret []byte) (*SignerClient, error) {
+	c := &SignerClient{secret: secret, httpClient: &http.Client{Timeout: 10 * time.Second}}
+	switch {
+	case strings.HasPrefix(endpoint, "unix://"):
+		socket := strings.TrimPrefix(endpoint, "unix://")
+		c.baseURL = "http://signer"
+		c.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case strings.HasPrefix(endpoint, "http://"):
+		c.baseURL = strings.TrimSuffix(endpoint, "/")
+	default:
+		return nil, fmt.Errorf("unsupported signer endpoint %q", endpoint)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty signer secret")
+	}
+	return c, nil
+}
+
+// Call POSTs `payload` to `path` and decodes the authenticated response into `out`
+func (c *SignerClient) Call(ctx context.Context, path string, payload any, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sig := hmacsig.Sign(c.secret, hmacsig.RequestParts(http.MethodPost, path, ts, hex.EncodeToString(nonce), body)...)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(hmacsig.HeaderTimestamp, ts)
+	req.Header.Set(hmacsig.HeaderNonce, hex.EncodeToString(nonce))
+	req.Header.Set(hmacsig.HeaderSignature, sig)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// the response is bound to this request, a replayed or forged response fails here
+	if !hmacsig.Verify(c.secret, resp.Header.Get(hmacsig.HeaderSignature), hmacsig.ResponseParts(sig, resp.StatusCode, respBody)...) {
+		return ErrSignerAuth
+	}
+	if resp.StatusCode >= 400 {
+		var e SignerError
+		if err := json.Unmarshal(respBody, &e); err == nil && e.Error != "" {
+			return fmt.Errorf("signer status %d: %s", resp.StatusCode, e.Error)
+		}
+		return fmt.Errorf("signer status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -1,5 +1,81 @@
 package clients
 
+import (
+	"encoding/json"
+	"strings"
+
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
 type ExchangeResponse struct {
-	Status string `json:"status"`
+	Status   string          `json:"status"`
+	Response json.RawMessage `json:"response"` // error message when Status is "err"
+}
+
+// IsNonceError reports whether the exchange refused the action for its nonce, e.g. because an action with the same
+// nonce was already executed
+func (r ExchangeResponse) IsNonceError() bool {
+	if r.Status != "err" {
+		return false
+	}
+	var msg string
+	if err := json.Unmarshal(r.Response, &msg); err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(msg), "nonce")
+}
+
+// LedgerUpdate is an entry of the `userNonFundingLedgerUpdates` info endpoint
+type LedgerUpdate struct {
+	Time  int64       `json:"time"`
+	Hash  string      `json:"hash"`
+	Delta LedgerDelta `json:"delta"`
+}
+
+// LedgerDelta holds the fields of the ledger update types we consume. For `spotTransfer` Amount is a decimal string in units of Token.
+type LedgerDelta struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	Amount      string `json:"amount"`
+	UsdcValue   string `json:"usdcValue"`
+	User        string `json:"user"`
+	Destination string `json:"destination"`
+	Fee         string `json:"fee"`
+	Nonce       uint64 `json:"nonce"` // nonce of the action that made the transfer
+}
+
+// signer service requests and responses
+
+type SignerKeyRequest struct {
+	Address string `json:"address"`
+	Index   uint32 `json:"index"`
+}
+
+type SignerKeyResponse struct {
+	Address string `json:"address"`
+	Index   uint32 `json:"index"`
+	Ok      bool   `json:"ok"`
+}
+
+type SignerTxRequest struct {
+	Address string `json:"address"`
+	Tx      string `json:"tx"` // hex encoded binary tx
+	ChainID string `json:"chain_id"`
+}
+
+type SignerTxResponse struct {
+	Tx string `json:"tx"`
+}
+
+type SignerTypedDataRequest struct {
+	Address   string             `json:"address"`
+	TypedData apitypes.TypedData `json:"typed_data"`
+}
+
+type SignerTypedDataResponse struct {
+	Signature string `json:"signature"` // hex [R || S || V]
+}
+
+type SignerError struct {
+	Error string `json:"error"`
 }
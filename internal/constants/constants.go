@@ -2,8 +2,13 @@ package constants
 
 const (
 	KeyStorePath     = "./tmp/keys"
+	SeedPath         = "./tmp/seed.json"
 	KeyStorePassword = "password"
 
-	AccountDbPath = "./tmp/accounts.db"
-	StateDbPath   = "./tmp/states.db"
+	AccountDbPath    = "./tmp/accounts.db"
+	StateDbPath      = "./tmp/states.db"
+	CheckpointDbPath = "./tmp/checkpoints.db"
+	NonceDbPath      = "./tmp/nonces.db"
+	WebhookDbPath    = "./tmp/webhooks.db"
+	APIKeyDbPath     = "./tmp/apikeys.db"
 )
@@ -2,16 +2,23 @@ package mocks
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"math/big"
+	"sync"
 	"unit/agent/internal/models"
 	"unit/agent/internal/stores"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 type MockKeyStore struct {
 	Addr       string
 	HasKeyResp bool
+	Index      *uint32           // derivation index of Addr
+	Key        *ecdsa.PrivateKey // signs typed data if set
 	Err        error
 	Called     int
 }
@@ -23,10 +30,30 @@ func (f *MockKeyStore) CreateKey(ctx context.Context) (string, error) {
 func (f *MockKeyStore) HasKey(ctx context.Context, addr string) bool {
 	return addr == f.Addr || f.HasKeyResp
 }
+func (f *MockKeyStore) KeyIndex(ctx context.Context, addr string) (uint32, bool) {
+	if f.Index == nil || addr != f.Addr {
+		return 0, false
+	}
+	return *f.Index, true
+}
 func (f *MockKeyStore) SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
 	return tx, nil
 }
 
+func (f *MockKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if f.Key == nil {
+		return make([]byte, 65), nil
+	}
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(hash, f.Key)
+}
+
 type MockAccountStore struct {
 	GetFn     func(ctx context.Context, id string) (*models.Account, error)
 	ByAddr    map[string]*models.Account
@@ -58,3 +85,74 @@ func (f *MockAccountStore) GetByDepositAddress(ctx context.Context, address stri
 	}
 	return nil, stores.ErrAccountNotFound
 }
+
+func (f *MockAccountStore) Scan(ctx context.Context, visit func(*models.Account) error) error {
+	for _, a := range f.ByAddr {
+		if err := visit(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MockCheckpointStore struct {
+	mu     sync.Mutex
+	Blocks map[models.Chain]uint64
+	Hashes map[models.Chain]map[uint64]common.Hash
+	PutErr error
+}
+
+func (f *MockCheckpointStore) Get(ctx context.Context, chain models.Chain) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n, ok := f.Blocks[chain]; ok {
+		return n, nil
+	}
+	return 0, stores.ErrCheckpointNotFound
+}
+
+func (f *MockCheckpointStore) Put(ctx context.Context, chain models.Chain, block uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.PutErr != nil {
+		return f.PutErr
+	}
+	if f.Blocks == nil {
+		f.Blocks = map[models.Chain]uint64{}
+	}
+	f.Blocks[chain] = block
+	return nil
+}
+
+func (f *MockCheckpointStore) PutBlock(ctx context.Context, chain models.Chain, block uint64, hash common.Hash, keep uint64) error {
+	if err := f.Put(ctx, chain, block); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Hashes == nil {
+		f.Hashes = map[models.Chain]map[uint64]common.Hash{}
+	}
+	if f.Hashes[chain] == nil {
+		f.Hashes[chain] = map[uint64]common.Hash{}
+	}
+	f.Hashes[chain][block] = hash
+	for n := range f.Hashes[chain] {
+		if n > block || n+keep <= block {
+			delete(f.Hashes[chain], n)
+		}
+	}
+	return nil
+}
+
+func (f *MockCheckpointStore) BlockHashes(ctx context.Context, chain models.Chain) (map[uint64]common.Hash, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[uint64]common.Hash)
+	for n, h := range f.Hashes[chain] {
+		if n <= f.Blocks[chain] {
+			out[n] = h
+		}
+	}
+	return out, nil
+}
@@ -12,6 +12,7 @@ type Account struct {
 	DstChain    Chain          `json:"dst_chain"`
 	DstAddr     common.Address `json:"dst_addr"`
 	DepositAddr common.Address `json:"deposit_addr"`
+	KeyIndex    *uint32        `json:"key_index,omitempty"` // derivation index of the deposit key, nil if it was not derived from the seed
 }
 
 func NewAccount(srcChain Chain, dstChain Chain, dstAddr string, depositAddr string) (*Account, error) {
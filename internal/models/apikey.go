@@ -0,0 +1,33 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+type APIScope string
+
+const (
+	ScopeGenerate APIScope = "generate" // deposit address generation
+	ScopeRead     APIScope = "read"     // quotes, deposit status and streams
+	ScopeAdmin    APIScope = "admin"    // manual intervention, webhooks and API keys
+)
+
+// APIScopes lists every scope a key can be granted
+var APIScopes = []APIScope{ScopeGenerate, ScopeRead, ScopeAdmin}
+
+// APIKey authenticates API requests. Only the SHA-256 of the key's secret is stored, the secret is shown once when the
+// key is created. Keys without their own rate limit use the API default.
+type APIKey struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	SecretHash    string     `json:"secret_hash"`
+	Scopes        []APIScope `json:"scopes"`
+	RatePerSecond float64    `json:"rate_per_second,omitempty"`
+	Burst         int        `json:"burst,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope APIScope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type AdminAction string
+
+const (
+	AdminRetry  AdminAction = "retry"
+	AdminCancel AdminAction = "cancel"
+	AdminForce  AdminAction = "force"
+)
+
+// AuditEntry records a manual intervention on a deposit, who made it, why, and the state it moved the deposit between
+type AuditEntry struct {
+	Action AdminAction `json:"action"`
+	Actor  string      `json:"actor"`
+	Reason string      `json:"reason"`
+	From   State       `json:"from"`
+	To     State       `json:"to"`
+	At     time.Time   `json:"at"`
+}
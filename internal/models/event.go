@@ -0,0 +1,144 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type EventType string
+
+const (
+	// EventCreated is the first event of a deposit, Data holds the whole deposit
+	EventCreated EventType = "created"
+	// EventTransitioned moves the deposit to another state
+	EventTransitioned EventType = "transitioned"
+	// EventAttemptFailed records a failed transition attempt, the deposit stays in its state
+	EventAttemptFailed EventType = "attempt_failed"
+	// EventUpdated changes fields of the deposit without moving it, e.g. a fee bumped replacement tx
+	EventUpdated EventType = "updated"
+)
+
+// fields that change on every poll of a deposit, a write changing only these is not an event
+var volatileFields = map[string]struct{}{
+	"updated_at":      {},
+	"next_attempt_at": {},
+}
+
+// DepositEvent is an immutable record of a single change of a deposit. Events are appended in order per deposit and
+// never modified, the current DepositState is a projection of them.
+type DepositEvent struct {
+	DepositID string    `json:"deposit_id"`
+	Seq       uint64    `json:"seq"`
+	Type      EventType `json:"type"`
+	From      State     `json:"from,omitempty"`
+	To        State     `json:"to"`
+	Reason    string    `json:"reason,omitempty"` // error of the failed attempt or of the transition
+	// Data is a JSON merge patch (RFC 7386) of the top level deposit fields changed by the event, removed fields are null
+	Data json.RawMessage `json:"data"`
+	At   time.Time       `json:"at"`
+}
+
+// NewDepositEvent returns the event changing `prev` into `next`, prev is nil for a new deposit. ok is false if nothing
+// but the poll timestamps changed, such a write is not recorded.
+func NewDepositEvent(prev, next *DepositState) (event DepositEvent, ok bool, err error) {
+	event = DepositEvent{
+		DepositID: next.ID,
+		To:        next.State,
+		Reason:    next.Error,
+		At:        next.UpdatedAt,
+	}
+	nextFields, err := depositFields(next)
+	if err != nil {
+		return DepositEvent{}, false, err
+	}
+	if prev == nil {
+		event.Type = EventCreated
+		event.Data, err = json.Marshal(nextFields)
+		return event, err == nil, err
+	}
+
+	prevFields, err := depositFields(prev)
+	if err != nil {
+		return DepositEvent{}, false, err
+	}
+	patch := make(map[string]json.RawMessage)
+	changed := false
+	for k, v := range nextFields {
+		if old, ok := prevFields[k]; !ok || !bytes.Equal(old, v) {
+			patch[k] = v
+			_, volatile := volatileFields[k]
+			changed = changed || !volatile
+		}
+	}
+	for k := range prevFields {
+		if _, ok := nextFields[k]; !ok {
+			patch[k] = json.RawMessage("null")
+			changed = true
+		}
+	}
+	if !changed {
+		return DepositEvent{}, false, nil
+	}
+
+	event.From = prev.State
+	switch {
+	case prev.State != next.State:
+		event.Type = EventTransitioned
+	case next.Error != "" && (next.Error != prev.Error || next.Attempts != prev.Attempts):
+		event.Type = EventAttemptFailed
+	default:
+		event.Type = EventUpdated
+		event.Reason = ""
+	}
+	event.Data, err = json.Marshal(patch)
+	return event, err == nil, err
+}
+
+// Project rebuilds the current deposit state by applying its ordered events. Returns nil if there are no events.
+func Project(events []DepositEvent) (*DepositState, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	if events[0].Type != EventCreated {
+		return nil, fmt.Errorf("log of deposit %s starts with a %s event", events[0].DepositID, events[0].Type)
+	}
+
+	fields := make(map[string]json.RawMessage)
+	for _, e := range events {
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(e.Data, &patch); err != nil {
+			return nil, fmt.Errorf("invalid data in event %d of deposit %s: %w", e.Seq, e.DepositID, err)
+		}
+		for k, v := range patch {
+			if bytes.Equal(v, []byte("null")) {
+				delete(fields, k)
+				continue
+			}
+			fields[k] = v
+		}
+	}
+
+	blob, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var st DepositState
+	if err := json.Unmarshal(blob, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func depositFields(st *DepositState) (map[string]json.RawMessage, error) {
+	blob, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(blob, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
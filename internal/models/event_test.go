@@ -0,0 +1,74 @@
+package models
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewDepositEvent_Types(t *testing.T) {
+	at := time.Unix(1_700_000_000, 0).UTC()
+	st := &DepositState{ID: "dep", State: StateSrcTxDiscovered, AmountWei: big.NewInt(5), UpdatedAt: at}
+	var events []DepositEvent
+	record := func(prev, next DepositState, want EventType) {
+		t.Helper()
+		var p *DepositState
+		if want != EventCreated {
+			p = &prev
+		}
+		e, ok, err := NewDepositEvent(p, &next)
+		if err != nil || !ok {
+			t.Fatalf("NewDepositEvent = %v, %v", ok, err)
+		}
+		if e.Type != want {
+			t.Fatalf("type = %s, want %s", e.Type, want)
+		}
+		e.Seq = uint64(len(events) + 1)
+		events = append(events, e)
+	}
+
+	record(DepositState{}, *st, EventCreated)
+
+	prev := *st
+	st.Attempts, st.Error = 1, "rpc down"
+	record(prev, *st, EventAttemptFailed)
+
+	prev = *st
+	st.State, st.Attempts, st.Error = StateSrcTxConfirmed, 0, ""
+	st.CreditedWith = []string{"a"}
+	record(prev, *st, EventTransitioned)
+	if e := events[2]; e.From != StateSrcTxDiscovered || e.To != StateSrcTxConfirmed || e.Reason != "" {
+		t.Fatalf("transition = %+v", e)
+	}
+
+	prev = *st
+	st.CreditedWith = nil
+	record(prev, *st, EventUpdated)
+
+	// polling without a change is not an event
+	prev = *st
+	st.UpdatedAt = at.Add(time.Minute)
+	st.NextAttemptAt = at.Add(2 * time.Minute)
+	if _, ok, err := NewDepositEvent(&prev, st); err != nil || ok {
+		t.Fatalf("NewDepositEvent for a poll = %v, %v, want no event", ok, err)
+	}
+	st.UpdatedAt, st.NextAttemptAt = prev.UpdatedAt, prev.NextAttemptAt
+
+	got, err := Project(events)
+	if err != nil {
+		t.Fatalf("Project: %v", err)
+	}
+	if !reflect.DeepEqual(got, st) {
+		t.Fatalf("projection = %+v, want %+v", got, st)
+	}
+}
+
+func TestProject_RequiresCreatedEvent(t *testing.T) {
+	if st, err := Project(nil); st != nil || err != nil {
+		t.Fatalf("Project(nil) = %v, %v", st, err)
+	}
+	if _, err := Project([]DepositEvent{{DepositID: "dep", Type: EventUpdated, Data: []byte(`{}`)}}); err == nil {
+		t.Fatalf("expected error for a log without a created event")
+	}
+}
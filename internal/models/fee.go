@@ -0,0 +1,49 @@
+package models
+
+import "math/big"
+
+// Route is a path deposits take, fees are configured per route
+type Route struct {
+	SrcChain Chain  `json:"src_chain"`
+	DstChain Chain  `json:"dst_chain"`
+	Asset    string `json:"asset"`
+}
+
+// FeeSchedule is the fee charged on a route in base units of the deposited asset, a flat fee plus Bps basis points of
+// the gross amount clamped to [Min, Max]. Nil amounts are zero or unbounded. With RecoverGas the estimated gas of the
+// credit and of the sweep is charged on top.
+type FeeSchedule struct {
+	Flat       *big.Int `json:"flat,omitempty"`
+	Bps        int64    `json:"bps"`
+	Min        *big.Int `json:"min,omitempty"`
+	Max        *big.Int `json:"max,omitempty"`
+	RecoverGas bool     `json:"recover_gas"`
+}
+
+// Fee is the fee charged on `gross`, rounded down
+func (f FeeSchedule) Fee(gross *big.Int) *big.Int {
+	fee := new(big.Int).Mul(gross, big.NewInt(f.Bps))
+	fee.Quo(fee, big.NewInt(10_000))
+	if f.Flat != nil {
+		fee.Add(fee, f.Flat)
+	}
+	if f.Min != nil && fee.Cmp(f.Min) < 0 {
+		fee.Set(f.Min)
+	}
+	if f.Max != nil && fee.Cmp(f.Max) > 0 {
+		fee.Set(f.Max)
+	}
+	return fee
+}
+
+// FeeBreakdown is how the credit of a deposit was computed. Gross, Fee, Gas and Net are in base units of Asset,
+// Net = Gross - Fee - Gas is converted into Payout base units of PayoutAsset.
+type FeeBreakdown struct {
+	Asset       string   `json:"asset"`
+	Gross       *big.Int `json:"gross"`
+	Fee         *big.Int `json:"fee"`
+	Gas         *big.Int `json:"gas"` // estimated gas of the credit and the sweep, zero unless the route recovers gas
+	Net         *big.Int `json:"net"`
+	PayoutAsset string   `json:"payout_asset"`
+	Payout      *big.Int `json:"payout"`
+}
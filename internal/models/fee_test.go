@@ -0,0 +1,27 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFeeSchedule_Fee(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule FeeSchedule
+		gross    int64
+		want     int64
+	}{
+		{"no fee", FeeSchedule{}, 1_000_000, 0},
+		{"bps", FeeSchedule{Bps: 25}, 1_000_000, 2_500},
+		{"flat plus bps", FeeSchedule{Flat: big.NewInt(100), Bps: 25}, 1_000_000, 2_600},
+		{"rounds down", FeeSchedule{Bps: 1}, 9_999, 0},
+		{"min", FeeSchedule{Bps: 10, Min: big.NewInt(500)}, 100_000, 500},
+		{"max", FeeSchedule{Bps: 100, Max: big.NewInt(5_000)}, 1_000_000, 5_000},
+	}
+	for _, c := range cases {
+		if got := c.schedule.Fee(big.NewInt(c.gross)); got.Cmp(big.NewInt(c.want)) != 0 {
+			t.Fatalf("%s: fee = %s, want %d", c.name, got, c.want)
+		}
+	}
+}
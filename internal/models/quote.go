@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Quote is a price a deposit was converted at, kept on the deposit so the credited amount can be audited
+type Quote struct {
+	Base           string    `json:"base"`                      // asset priced, e.g. eth
+	Quote          string    `json:"quote"`                     // asset the price is in, e.g. usdc
+	Price          string    `json:"price"`                     // decimal units of Quote per unit of Base
+	Source         string    `json:"source"`                    // oracle the price came from
+	Time           time.Time `json:"time"`                      // when the source observed the price
+	ReferencePrice string    `json:"reference_price,omitempty"` // price of the reference source the quote was checked against
+}
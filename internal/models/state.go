@@ -24,24 +24,106 @@ const (
 	StateFailed           State = "FAILED"
 	StateDstTxResend      State = "DST_TX_RESEND"
 	StateSweepTxResend    State = "SWEEP_TX_RESEND"
+	StateSrcTxInvalidated State = "SRC_TX_INVALIDATED" // source block orphaned by a reorg before the deposit was confirmed
+	StateBelowMinimum     State = "BELOW_MINIMUM"      // not credited on its own, credited with a later deposit to the same address
+	StateCanceled         State = "CANCELED"           // canceled by an operator, never transitioned again
+
+	// ERC-20 deposits, the hot wallet funds the deposit address with gas for the token sweep
+	StateSweepTopUpBuilt     State = "SWEEP_TOPUP_BUILT"
+	StateSweepTopUpSent      State = "SWEEP_TOPUP_SENT"
+	StateSweepTopUpConfirmed State = "SWEEP_TOPUP_CONFIRMED"
+	StateSweepTopUpRejected  State = "SWEEP_TOPUP_REJECTED"
+	StateSweepTopUpResend    State = "SWEEP_TOPUP_RESEND"
+
+	// Hyperliquid -> Ethereum withdrawal workflow
+	StateWithdrawalDetected        State = "WITHDRAWAL_DETECTED"
+	StateWithdrawalPayoutBuilt     State = "WITHDRAWAL_PAYOUT_BUILT"
+	StateWithdrawalPayoutSent      State = "WITHDRAWAL_PAYOUT_SENT"
+	StateWithdrawalPayoutConfirmed State = "WITHDRAWAL_PAYOUT_CONFIRMED"
+	StateWithdrawalPayoutRejected  State = "WITHDRAWAL_PAYOUT_REJECTED"
+	StateWithdrawalPayoutResend    State = "WITHDRAWAL_PAYOUT_RESEND"
+	StateWithdrawalSweepBuilt      State = "WITHDRAWAL_SWEEP_BUILT"
+	StateWithdrawalSweepSent       State = "WITHDRAWAL_SWEEP_SENT"
+	StateWithdrawalSweepRejected   State = "WITHDRAWAL_SWEEP_REJECTED"
+	StateWithdrawalSweepResend     State = "WITHDRAWAL_SWEEP_RESEND"
 )
 
+// IsTerminal reports whether no further transitions happen from this state
+func (s State) IsTerminal() bool {
+	switch s {
+	case StateDone, StateFailed, StateSrcTxInvalidated, StateBelowMinimum, StateCanceled:
+		return true
+	}
+	return false
+}
+
+// IsValid reports whether the state is a known workflow state
+func (s State) IsValid() bool {
+	switch s {
+	case StateSrcTxDiscovered, StateSrcTxConfirmed, StateDstTxBuilt, StateDstTxSent, StateDstTxConfirmed, StateDstTxRejected,
+		StateSweepTxBuilt, StateSweepTxSent, StateSweepTxConfirmed, StateSweepTxRejected, StateDone, StateFailed,
+		StateDstTxResend, StateSweepTxResend, StateSrcTxInvalidated, StateBelowMinimum, StateCanceled,
+		StateSweepTopUpBuilt, StateSweepTopUpSent, StateSweepTopUpConfirmed, StateSweepTopUpRejected, StateSweepTopUpResend:
+		return true
+	}
+	return s.IsWithdrawal()
+}
+
+// IsWithdrawal reports whether the state belongs to the withdrawal workflow
+func (s State) IsWithdrawal() bool {
+	switch s {
+	case StateWithdrawalDetected, StateWithdrawalPayoutBuilt, StateWithdrawalPayoutSent, StateWithdrawalPayoutConfirmed,
+		StateWithdrawalPayoutRejected, StateWithdrawalPayoutResend, StateWithdrawalSweepBuilt, StateWithdrawalSweepSent,
+		StateWithdrawalSweepRejected, StateWithdrawalSweepResend:
+		return true
+	}
+	return false
+}
+
+// DepositState tracks a single deposit or withdrawal workflow. AmountWei is in the smallest unit of Asset.
 type DepositState struct {
 	ID              string         `json:"id"` // depositAddr:txHash
 	TxHash          string         `json:"tx_hash"`
+	BlockNumber     uint64         `json:"block_number"`
+	BlockHash       string         `json:"block_hash"`
 	DepositAddr     common.Address `json:"deposit_addr"`
 	DstAddr         common.Address `json:"dst_addr"`
 	DstChain        Chain          `json:"dst_chain"`
 	SrcChain        Chain          `json:"src_chain"`
 	Asset           string         `json:"asset"`
 	AmountWei       *big.Int       `json:"amount_wei"`
+	Quote           *Quote         `json:"quote,omitempty"`             // price the payout was converted at, nil when no conversion was needed
+	Fees            *FeeBreakdown  `json:"fees,omitempty"`              // how the credited amount was computed
+	CreditedWith    []string       `json:"credited_with,omitempty"`     // below minimum deposits to the same address credited together with this one
+	CreditedWithWei *big.Int       `json:"credited_with_wei,omitempty"` // their total amount, credited on top of AmountWei
+	CreditedBy      string         `json:"credited_by,omitempty"`       // for below minimum deposits, the deposit they were credited with
 	State           State          `json:"state"`
 	UnsignedDstTx   string         `json:"unsigned_dst_tx"`
 	SentDstTxHash   string         `json:"sent_dst_tx_hash"`
+	DstTxHashes     []string       `json:"dst_tx_hashes"` // every broadcast of the dst tx, including same nonce replacements
+	DstTxSentAt     time.Time      `json:"dst_tx_sent_at"`
 	UnsignedSweepTx string         `json:"unsigned_sweep_tx"`
 	SentSweepTxHash string         `json:"sent_sweep_tx_hash"`
+	SweepTxHashes   []string       `json:"sweep_tx_hashes"`
+	SweepTxSentAt   time.Time      `json:"sweep_tx_sent_at"`
+	UnsignedTopUpTx string         `json:"unsigned_topup_tx"`
+	SentTopUpTxHash string         `json:"sent_topup_tx_hash"`
+	TopUpTxHashes   []string       `json:"topup_tx_hashes"`
+	TopUpTxSentAt   time.Time      `json:"topup_tx_sent_at"`
+	TopUpWei        *big.Int       `json:"topup_wei"`       // native amount of the current top-up tx
+	TotalTopUpWei   *big.Int       `json:"total_topup_wei"` // native amount of all confirmed top-ups, gas the hot wallet paid for the sweep
 	UpdatedAt       time.Time      `json:"updated_at"`
 	CreatedAt       time.Time      `json:"created_at"`
 	Attempts        int            `json:"attempts"`
+	NextAttemptAt   time.Time      `json:"next_attempt_at"`
 	Error           string         `json:"error"`
+	Audit           []AuditEntry   `json:"audit,omitempty"` // manual interventions, oldest first
+}
+
+// RunAt is when the deposit is next eligible to transition
+func (d *DepositState) RunAt() time.Time {
+	if d.NextAttemptAt.IsZero() {
+		return d.UpdatedAt
+	}
+	return d.NextAttemptAt
 }
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	AssetEth  = "eth"
+	AssetUsdc = "usdc"
+)
+
+// Token is an ERC-20 contract deposits are accepted in
+type Token struct {
+	Asset    string
+	Chain    Chain
+	Address  common.Address
+	Decimals uint8
+}
+
+// Tokens lists the ERC-20 tokens detected on each chain
+var Tokens = []Token{
+	{Asset: AssetUsdc, Chain: Ethereum, Address: common.HexToAddress("0x1c7D4B196Cb0C7B01d743Fbc6116a902379C7238"), Decimals: 6}, // Sepolia USDC
+}
+
+// TokensOn returns the registered tokens on `chain`
+func TokensOn(chain Chain) []Token {
+	var tokens []Token
+	for _, t := range Tokens {
+		if t.Chain == chain {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// TokenByAddress looks up a registered token by its contract address on `chain`
+func TokenByAddress(chain Chain, address common.Address) (Token, bool) {
+	for _, t := range Tokens {
+		if t.Chain == chain && t.Address == address {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// TokenByAsset looks up the contract of `asset` on `chain`, ok is false for native assets
+func TokenByAsset(chain Chain, asset string) (Token, bool) {
+	for _, t := range Tokens {
+		if t.Chain == chain && strings.EqualFold(t.Asset, asset) {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
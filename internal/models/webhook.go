@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookDepositDetected  WebhookEvent = "deposit.detected"
+	WebhookDepositCredited  WebhookEvent = "deposit.credited"
+	WebhookDepositCompleted WebhookEvent = "deposit.completed"
+	WebhookDepositFailed    WebhookEvent = "deposit.failed"
+)
+
+// WebhookEvents lists every event a subscription can ask for
+var WebhookEvents = []WebhookEvent{WebhookDepositDetected, WebhookDepositCredited, WebhookDepositCompleted, WebhookDepositFailed}
+
+// WebhookEventFor returns the event a transition into `state` is delivered as, most transitions are not delivered
+func WebhookEventFor(state State) (WebhookEvent, bool) {
+	switch state {
+	case StateSrcTxDiscovered, StateWithdrawalDetected:
+		return WebhookDepositDetected, true
+	case StateDstTxConfirmed, StateWithdrawalPayoutConfirmed:
+		return WebhookDepositCredited, true
+	case StateDone:
+		return WebhookDepositCompleted, true
+	case StateFailed, StateCanceled, StateSrcTxInvalidated:
+		return WebhookDepositFailed, true
+	}
+	return "", false
+}
+
+// WebhookSubscription receives the events it lists at URL, payloads are signed with Secret
+type WebhookSubscription struct {
+	ID        string         `json:"id"`
+	URL       string         `json:"url"`
+	Events    []WebhookEvent `json:"events"` // empty for every event
+	Secret    string         `json:"secret"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func (s *WebhookSubscription) Wants(event WebhookEvent) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, event)
+}
+
+// WebhookDelivery is a single event queued for a subscription, the payload is fixed when the event is queued
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          WebhookEvent    `json:"event"`
+	DepositID      string          `json:"deposit_id"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+var (
+	ErrInvalidAdminAction = errors.New("invalid admin action")
+	ErrReasonRequired     = errors.New("reason required")
+)
+
+// rebuildState maps states holding a built but unsent hot wallet tx to the state rebuilding it. The nonce of such a tx is
+// released once the deposit fails or is moved away, so the tx itself must never be broadcast afterwards.
+func rebuildState(state models.State) models.State {
+	switch state {
+	case models.StateDstTxBuilt:
+		return models.StateDstTxResend
+	case models.StateWithdrawalPayoutBuilt:
+		return models.StateWithdrawalPayoutResend
+	case models.StateSweepTopUpBuilt:
+		return models.StateSweepTopUpResend
+	}
+	return state
+}
+
+// Retry moves a failed deposit back to `state` with its attempts reset. Without a state the deposit resumes from the
+// state it failed at, deposits that failed holding a built hot wallet tx resume from the state rebuilding it.
+func (sm *StateMachine) Retry(ctx context.Context, id string, state models.State, actor, reason string) (*models.DepositState, error) {
+	return sm.intervene(ctx, id, models.AdminRetry, actor, reason, func(st *models.DepositState) (models.State, error) {
+		if st.State != models.StateFailed {
+			return "", fmt.Errorf("%w, only failed deposits can be retried, deposit is %s", ErrInvalidAdminAction, st.State)
+		}
+		if state != "" {
+			return state, nil
+		}
+		events, err := sm.states.Events(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		for i := len(events) - 1; i >= 0; i-- {
+			if events[i].Type == models.EventTransitioned && events[i].To == models.StateFailed {
+				return rebuildState(events[i].From), nil
+			}
+		}
+		return "", fmt.Errorf("%w, no state before the failure, retry from an explicit state", ErrInvalidAdminAction)
+	})
+}
+
+// Cancel moves a deposit that is in flight or failed to the terminal CANCELED state
+func (sm *StateMachine) Cancel(ctx context.Context, id string, actor, reason string) (*models.DepositState, error) {
+	return sm.intervene(ctx, id, models.AdminCancel, actor, reason, func(st *models.DepositState) (models.State, error) {
+		if st.State.IsTerminal() && st.State != models.StateFailed {
+			return "", fmt.Errorf("%w, deposit is already %s", ErrInvalidAdminAction, st.State)
+		}
+		return models.StateCanceled, nil
+	})
+}
+
+// ForceState moves a deposit to any state, the reason is required
+func (sm *StateMachine) ForceState(ctx context.Context, id string, state models.State, actor, reason string) (*models.DepositState, error) {
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+	return sm.intervene(ctx, id, models.AdminForce, actor, reason, func(st *models.DepositState) (models.State, error) {
+		return state, nil
+	})
+}
+
+// intervene moves the deposit to the state returned by `target` under the deposit lock, so no transition runs
+// concurrently, and records the action in the deposit's audit trail
+func (sm *StateMachine) intervene(ctx context.Context, id string, action models.AdminAction, actor, reason string,
+	target func(*models.DepositState) (models.State, error)) (*models.DepositState, error) {
+	if err := sm.lockDeposit(ctx, id); err != nil {
+		return nil, err
+	}
+	defer sm.unlockDeposit(id)
+
+	st, err := sm.states.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	next, err := target(st)
+	if err != nil {
+		return nil, err
+	}
+	if !next.IsValid() {
+		return nil, fmt.Errorf("%w, unknown state %s", ErrInvalidAdminAction, next)
+	}
+	if rebuildState(next) != next {
+		return nil, fmt.Errorf("%w, the built tx of %s may have released its nonce, use %s", ErrInvalidAdminAction, next, rebuildState(next))
+	}
+	// a below minimum deposit claimed by another deposit was paid with it, moving it on would credit it twice
+	if st.State == models.StateBelowMinimum && st.CreditedBy != "" && !next.IsTerminal() {
+		return nil, fmt.Errorf("%w, deposit was credited with deposit %s", ErrInvalidAdminAction, st.CreditedBy)
+	}
+
+	prev := st.State
+	now := time.Now()
+	// the built tx is never broadcast once the deposit leaves its state
+	if err := sm.abandonUnsent(ctx, st); err != nil {
+		var broadcast *TxBroadcastError
+		if errors.As(err, &broadcast) {
+			return nil, fmt.Errorf("%w, the built tx %s already reached the node, move the deposit once it is sent", ErrInvalidAdminAction, broadcast.Hash)
+		}
+		return nil, err
+	} else if next == models.StateFailed || next == models.StateCanceled {
+		sm.releaseClaims(ctx, st)
+	}
+	st.State = next
+	st.Attempts = 0
+	st.Error = ""
+	if next == models.StateCanceled {
+		st.Error = fmt.Sprintf("canceled by %s: %s", actor, reason)
+	}
+	st.UpdatedAt = now
+	st.NextAttemptAt = now
+	st.Audit = append(slices.Clip(st.Audit), models.AuditEntry{
+		Action: action,
+		Actor:  actor,
+		Reason: reason,
+		From:   prev,
+		To:     next,
+		At:     now,
+	})
+	if err := sm.states.Put(ctx, st); err != nil {
+		return nil, err
+	}
+	sm.publish(ctx, st)
+	fmt.Printf("deposit %s moved from %s to %s by %s (%s): %s\n", st.ID, prev, next, actor, action, reason)
+	return st, nil
+}
+
+// PauseChain stops transitions of deposits from or to `chain` until it is resumed. Deposits are still detected while
+// the chain is paused. Pauses are held in memory and lifted on restart.
+func (sm *StateMachine) PauseChain(chain models.Chain, actor, reason string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.paused == nil {
+		sm.paused = make(map[models.Chain]struct{})
+	}
+	sm.paused[chain] = struct{}{}
+	fmt.Printf("chain %s paused by %s: %s\n", chain, actor, reason)
+}
+
+func (sm *StateMachine) ResumeChain(chain models.Chain, actor, reason string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.paused, chain)
+	fmt.Printf("chain %s resumed by %s: %s\n", chain, actor, reason)
+}
+
+// PausedChains returns the paused chains in name order
+func (sm *StateMachine) PausedChains() []models.Chain {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	chains := make([]models.Chain, 0, len(sm.paused))
+	for chain := range sm.paused {
+		chains = append(chains, chain)
+	}
+	slices.Sort(chains)
+	return chains
+}
+
+func (sm *StateMachine) isPaused(st *models.DepositState) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, src := sm.paused[st.SrcChain]
+	_, dst := sm.paused[st.DstChain]
+	return src || dst
+}
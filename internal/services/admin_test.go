@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+)
+
+// failedDeposit records a deposit that failed at `at`
+func failedDeposit(t *testing.T, ss *mockStateStore, at models.State) *models.DepositState {
+	t.Helper()
+	st := &models.DepositState{
+		ID: "dep", State: at, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+	}
+	ss.Put(context.Background(), st)
+	st.State = models.StateFailed
+	st.Attempts = 5
+	st.Error = "retries exhausted: rpc down"
+	ss.Put(context.Background(), st)
+	return ss.get("dep")
+}
+
+func TestStateMachine_Retry_ResumesFromFailedState(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	failedDeposit(t, ss, models.StateDstTxSent)
+
+	st, err := sm.Retry(context.Background(), "dep", "", "alice", "rpc recovered")
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if st.State != models.StateDstTxSent || st.Attempts != 0 || st.Error != "" {
+		t.Fatalf("state = %s attempts = %d error = %q", st.State, st.Attempts, st.Error)
+	}
+	if len(st.Audit) != 1 {
+		t.Fatalf("audit = %+v", st.Audit)
+	}
+	if e := st.Audit[0]; e.Action != models.AdminRetry || e.Actor != "alice" || e.Reason != "rpc recovered" || e.From != models.StateFailed || e.To != models.StateDstTxSent {
+		t.Fatalf("audit entry = %+v", e)
+	}
+	if got := ss.get("dep"); got.State != models.StateDstTxSent || len(got.Audit) != 1 {
+		t.Fatalf("stored %s with %d audit entries", got.State, len(got.Audit))
+	}
+}
+
+func TestStateMachine_Retry_RebuildsReleasedTx(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	failedDeposit(t, ss, models.StateDstTxBuilt)
+
+	st, err := sm.Retry(context.Background(), "dep", "", "alice", "")
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if st.State != models.StateDstTxResend {
+		t.Fatalf("state = %s, want %s", st.State, models.StateDstTxResend)
+	}
+
+	failedDeposit(t, ss, models.StateDstTxSent)
+	if _, err := sm.Retry(context.Background(), "dep", models.StateDstTxBuilt, "alice", ""); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("explicit built state err = %v, want ErrInvalidAdminAction", err)
+	}
+}
+
+func TestStateMachine_Retry_OnlyFailed(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{ID: "dep", State: models.StateDstTxSent})
+
+	if _, err := sm.Retry(context.Background(), "dep", "", "alice", ""); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("err = %v, want ErrInvalidAdminAction", err)
+	}
+	if _, err := sm.Retry(context.Background(), "missing", "", "alice", ""); !errors.Is(err, stores.ErrExecutionNotFound) {
+		t.Fatalf("err = %v, want ErrExecutionNotFound", err)
+	}
+}
+
+func TestStateMachine_Cancel_AbandonsUnsentTx(t *testing.T) {
+	var abandoned string
+	dstCtx := &mockChainCtx{
+		abandonTxFn: func(ctx context.Context, raw, from string) error {
+			abandoned = raw
+			return nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{
+		ID: "dep", State: models.StateDstTxBuilt, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+	})
+
+	st, err := sm.Cancel(context.Background(), "dep", "alice", "duplicate")
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if st.State != models.StateCanceled || !st.State.IsTerminal() {
+		t.Fatalf("state = %s, want terminal CANCELED", st.State)
+	}
+	if abandoned != "raw_dst" {
+		t.Fatalf("abandoned = %q, want raw_dst", abandoned)
+	}
+	if _, err := sm.Cancel(context.Background(), "dep", "alice", "again"); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("cancel canceled err = %v, want ErrInvalidAdminAction", err)
+	}
+}
+
+func TestStateMachine_Cancel_RefusesBroadcastBuiltTx(t *testing.T) {
+	dstCtx := &mockChainCtx{
+		abandonTxFn: func(ctx context.Context, raw, from string) error {
+			return &TxBroadcastError{Hash: "0xdst"}
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{
+		ID: "dep", State: models.StateDstTxBuilt, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+	})
+
+	if _, err := sm.Cancel(context.Background(), "dep", "alice", "duplicate"); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("err = %v, want ErrInvalidAdminAction", err)
+	}
+	if got := ss.get("dep"); got.State != models.StateDstTxBuilt {
+		t.Fatalf("state = %s, want DST_TX_BUILT", got.State)
+	}
+}
+
+func TestStateMachine_Cancel_FailsWhenTxCannotBeAbandoned(t *testing.T) {
+	dstCtx := &mockChainCtx{
+		abandonTxFn: func(ctx context.Context, raw, from string) error {
+			return errors.New("rpc down")
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{
+		ID: "dep", State: models.StateDstTxBuilt, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+		CreditedWith: []string{"dust"},
+	})
+
+	if _, err := sm.Cancel(context.Background(), "dep", "alice", "duplicate"); err == nil {
+		t.Fatal("Cancel succeeded without releasing the built tx")
+	}
+	if got := ss.get("dep"); got.State != models.StateDstTxBuilt || len(got.CreditedWith) != 1 {
+		t.Fatalf("deposit = %s credited with %v, want it unchanged", got.State, got.CreditedWith)
+	}
+}
+
+func TestStateMachine_ForceState_RefusesClaimedBelowMinimum(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{ID: "dust", State: models.StateBelowMinimum, CreditedBy: "dep"})
+
+	ctx := context.Background()
+	if _, err := sm.ForceState(ctx, "dust", models.StateSrcTxConfirmed, "alice", "credit it"); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("err = %v, want ErrInvalidAdminAction", err)
+	}
+	if got := ss.get("dust"); got.State != models.StateBelowMinimum {
+		t.Fatalf("state = %s, want BELOW_MINIMUM", got.State)
+	}
+	if _, err := sm.ForceState(ctx, "dust", models.StateCanceled, "alice", "refunded"); err != nil {
+		t.Fatalf("ForceState to a terminal state: %v", err)
+	}
+}
+
+func TestStateMachine_ForceState(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{ID: "dep", State: models.StateSweepTxSent})
+
+	ctx := context.Background()
+	if _, err := sm.ForceState(ctx, "dep", models.StateDone, "alice", ""); !errors.Is(err, ErrReasonRequired) {
+		t.Fatalf("no reason err = %v, want ErrReasonRequired", err)
+	}
+	if _, err := sm.ForceState(ctx, "dep", "BOGUS", "alice", "typo"); !errors.Is(err, ErrInvalidAdminAction) {
+		t.Fatalf("unknown state err = %v, want ErrInvalidAdminAction", err)
+	}
+	st, err := sm.ForceState(ctx, "dep", models.StateDone, "alice", "sweep confirmed on explorer")
+	if err != nil {
+		t.Fatalf("ForceState: %v", err)
+	}
+	if st.State != models.StateDone || len(st.Audit) != 1 || st.Audit[0].Action != models.AdminForce {
+		t.Fatalf("state = %s audit = %+v", st.State, st.Audit)
+	}
+}
+
+func TestStateMachine_Start_SkipsPausedChains(t *testing.T) {
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+	}
+	dstCtx := &mockChainCtx{
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			return "", errors.New("build disabled")
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx, models.Hyperliquid: dstCtx}})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.items["dep"] = &models.DepositState{
+		ID: "dep", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, TxHash: "0xsrc",
+		Asset: models.AssetUsdc, AmountWei: big.NewInt(1),
+	}
+
+	sm.PauseChain(models.Hyperliquid, "alice", "incident")
+	if got := sm.PausedChains(); len(got) != 1 || got[0] != models.Hyperliquid {
+		t.Fatalf("paused = %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sm.Start(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	if got := ss.get("dep").State; got != models.StateSrcTxDiscovered {
+		t.Fatalf("state = %s while paused, want %s", got, models.StateSrcTxDiscovered)
+	}
+
+	sm.ResumeChain(models.Hyperliquid, "alice", "resolved")
+	waitForState(t, ss, "dep", models.StateSrcTxConfirmed)
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"unit/agent/internal/models"
 	"unit/agent/internal/stores"
@@ -18,22 +22,42 @@ type Api struct {
 	server    *http.Server
 	keys      stores.IKeyStore
 	accounts  stores.IAccountStore
+	states    stores.IStateStore
+	fees      *FeeModel
+	admin     *StateMachine
+	broker    *Broker
+	webhooks  *WebhookDispatcher
+	apiKeys   stores.IAPIKeyStore
+	limiter   *RateLimiter
+	keyLimit  RateLimit
+	ipLimit   RateLimit
 	srcChains []string
 	dstChains []string
 	assets    []string
 }
 
-func NewApi(ks stores.IKeyStore, as stores.IAccountStore, srcChains []string, dstChains []string, assets []string) *Api {
+func NewApi(ks stores.IKeyStore, as stores.IAccountStore, ss stores.IStateStore, apiKeys stores.IAPIKeyStore, fees *FeeModel, srcChains []string, dstChains []string, assets []string) *Api {
 	a := &Api{
 		keys:      ks,
 		accounts:  as,
+		states:    ss,
+		apiKeys:   apiKeys,
+		limiter:   NewRateLimiter(),
+		keyLimit:  defaultKeyRateLimit,
+		ipLimit:   defaultIPRateLimit,
+		fees:      fees,
 		srcChains: srcChains,
 		dstChains: dstChains,
 		assets:    assets,
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/gen/", a.HandleGenerate)
+	mux.HandleFunc("/gen/", a.authorize(models.ScopeGenerate, a.HandleGenerate))
+	mux.HandleFunc("/quote/", a.authorize(models.ScopeRead, a.HandleQuote))
+	mux.HandleFunc("/deposits", a.authorize(models.ScopeRead, a.HandleListDeposits))
+	mux.HandleFunc("/deposits/", a.authorize(models.ScopeRead, a.HandleGetDeposit))
+	mux.HandleFunc("/stream", a.authorize(models.ScopeRead, a.HandleStream))
+	mux.HandleFunc("/admin/", a.authorize(models.ScopeAdmin, a.HandleAdmin))
 
 	a.server = &http.Server{
 		Addr:    ":8000",
@@ -42,6 +66,21 @@ func NewApi(ks stores.IKeyStore, as stores.IAccountStore, srcChains []string, ds
 	return a
 }
 
+// SetAdmin enables the admin endpoints, they are served to keys with the admin scope
+func (a *Api) SetAdmin(sm *StateMachine) {
+	a.admin = sm
+}
+
+// SetBroker enables the stream endpoint, it serves the state transitions published to the broker
+func (a *Api) SetBroker(b *Broker) {
+	a.broker = b
+}
+
+// SetWebhooks enables the admin webhook endpoints
+func (a *Api) SetWebhooks(d *WebhookDispatcher) {
+	a.webhooks = d
+}
+
 func (a *Api) Start() error {
 	return a.server.ListenAndServe()
 }
@@ -123,9 +162,20 @@ func (a *Api) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if index, ok := a.keys.KeyIndex(ctx, depositAddr); ok {
+		account.KeyIndex = &index
+	}
 
 	err = a.accounts.Insert(ctx, *account)
-	if err != nil {
+	if errors.Is(err, stores.ErrAccountExists) {
+		// a concurrent request for the same route and destination inserted its account first
+		existing, err := a.accounts.Get(ctx, id)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		depositAddr = existing.DepositAddr.Hex()
+	} else if err != nil {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -138,3 +188,682 @@ func (a *Api) HandleGenerate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+type quoteResponse struct {
+	Route  models.Route         `json:"route"`
+	Fees   *models.FeeBreakdown `json:"fees"`
+	Quote  *models.Quote        `json:"quote,omitempty"`
+	Status string               `json:"status"`
+}
+
+// HandleQuote returns what a deposit of `amount` base units of `asset` on the route would be credited at current fees and prices
+func (a *Api) HandleQuote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/quote/"), "/")
+	if len(parts) != 4 {
+		http.Error(w, "invalid request, expected /quote/:chain/:dst_chain/:asset/:amount", http.StatusBadRequest)
+		return
+	}
+
+	chain := parts[0]
+	dstChain := parts[1]
+	asset := parts[2]
+
+	if !slices.Contains(a.srcChains, chain) {
+		http.Error(w, "unsupported chain", http.StatusBadRequest)
+		return
+	}
+
+	if !slices.Contains(a.dstChains, dstChain) {
+		http.Error(w, "unsupported destination chain", http.StatusBadRequest)
+		return
+	}
+
+	if !slices.Contains(a.assets, asset) {
+		http.Error(w, "unsupported asset", http.StatusBadRequest)
+		return
+	}
+
+	amount, ok := new(big.Int).SetString(parts[3], 10)
+	if !ok || amount.Sign() <= 0 {
+		http.Error(w, "invalid amount, expected base units of the asset", http.StatusBadRequest)
+		return
+	}
+
+	route := models.Route{SrcChain: models.Chain(chain), DstChain: models.Chain(dstChain), Asset: asset}
+	fees, quote, err := a.fees.Credit(ctx, route, amount)
+	if err != nil {
+		if errors.Is(err, ErrFeeExceedsAmount) {
+			http.Error(w, "amount does not cover fees", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "quote unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := quoteResponse{
+		Route:  route,
+		Fees:   fees,
+		Quote:  quote,
+		Status: "ok",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+
+	// interval of comments sent on idle streams, so proxies do not time out the connection
+	streamHeartbeat = 15 * time.Second
+)
+
+type depositResponse struct {
+	ID            string               `json:"id"`
+	State         models.State         `json:"state"`
+	SrcChain      models.Chain         `json:"src_chain"`
+	DstChain      models.Chain         `json:"dst_chain"`
+	Asset         string               `json:"asset"`
+	Amount        *big.Int             `json:"amount"`
+	DepositAddr   string               `json:"deposit_addr"`
+	DstAddr       string               `json:"dst_addr"`
+	SrcTxHash     string               `json:"src_tx_hash"`
+	DstTxHash     string               `json:"dst_tx_hash,omitempty"`
+	SweepTxHash   string               `json:"sweep_tx_hash,omitempty"`
+	Fees          *models.FeeBreakdown `json:"fees,omitempty"`
+	Quote         *models.Quote        `json:"quote,omitempty"`
+	CreditedBy    string               `json:"credited_by,omitempty"`
+	Audit         []models.AuditEntry  `json:"audit,omitempty"`
+	Error         string               `json:"error,omitempty"`
+	Attempts      int                  `json:"attempts"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+	DstTxSentAt   *time.Time           `json:"dst_tx_sent_at,omitempty"`
+	SweepTxSentAt *time.Time           `json:"sweep_tx_sent_at,omitempty"`
+}
+
+func newDepositResponse(st *models.DepositState) depositResponse {
+	return depositResponse{
+		ID:            st.ID,
+		State:         st.State,
+		SrcChain:      st.SrcChain,
+		DstChain:      st.DstChain,
+		Asset:         st.Asset,
+		Amount:        st.AmountWei,
+		DepositAddr:   st.DepositAddr.Hex(),
+		DstAddr:       st.DstAddr.Hex(),
+		SrcTxHash:     st.TxHash,
+		DstTxHash:     st.SentDstTxHash,
+		SweepTxHash:   st.SentSweepTxHash,
+		Fees:          st.Fees,
+		Quote:         st.Quote,
+		CreditedBy:    st.CreditedBy,
+		Audit:         st.Audit,
+		Error:         st.Error,
+		Attempts:      st.Attempts,
+		CreatedAt:     st.CreatedAt,
+		UpdatedAt:     st.UpdatedAt,
+		DstTxSentAt:   timeOrNil(st.DstTxSentAt),
+		SweepTxSentAt: timeOrNil(st.SweepTxSentAt),
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+type transitionResponse struct {
+	Type   models.EventType `json:"type"`
+	From   models.State     `json:"from,omitempty"`
+	To     models.State     `json:"to"`
+	Reason string           `json:"reason,omitempty"`
+	At     time.Time        `json:"at"`
+}
+
+type getDepositResponse struct {
+	Deposit depositResponse      `json:"deposit"`
+	History []transitionResponse `json:"history"`
+	Status  string               `json:"status"`
+}
+
+// HandleGetDeposit returns a deposit by ID along with every state transition it went through
+func (a *Api) HandleGetDeposit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/deposits/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "invalid request, expected /deposits/:id", http.StatusBadRequest)
+		return
+	}
+
+	st, err := a.states.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, stores.ErrExecutionNotFound) {
+			http.Error(w, "deposit not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	events, err := a.states.Events(ctx, id)
+	if err != nil && !errors.Is(err, stores.ErrExecutionNotFound) {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := getDepositResponse{
+		Deposit: newDepositResponse(st),
+		History: make([]transitionResponse, 0, len(events)),
+		Status:  "ok",
+	}
+	for _, e := range events {
+		resp.History = append(resp.History, transitionResponse{Type: e.Type, From: e.From, To: e.To, Reason: e.Reason, At: e.At})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type listDepositsResponse struct {
+	Deposits   []depositResponse `json:"deposits"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Status     string            `json:"status"`
+}
+
+// HandleListDeposits returns deposits filtered by dst_addr, deposit_addr and state in ID order. Pages hold up to
+// `limit` deposits, the next page is requested by passing `next_cursor` as `cursor`.
+func (a *Api) HandleListDeposits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var filter stores.DepositFilter
+	if v := q.Get("dst_addr"); v != "" {
+		if !common.IsHexAddress(v) {
+			http.Error(w, "invalid destination address", http.StatusBadRequest)
+			return
+		}
+		filter.DstAddr = common.HexToAddress(v)
+	}
+	if v := q.Get("deposit_addr"); v != "" {
+		if !common.IsHexAddress(v) {
+			http.Error(w, "invalid deposit address", http.StatusBadRequest)
+			return
+		}
+		filter.DepositAddr = common.HexToAddress(v)
+	}
+	if v := q.Get("state"); v != "" {
+		filter.State = models.State(strings.ToUpper(v))
+		if !filter.State.IsValid() {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageSize {
+			http.Error(w, "invalid limit, expected 1 to 200", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	deposits, next, err := a.states.List(ctx, filter, q.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := listDepositsResponse{
+		Deposits:   make([]depositResponse, 0, len(deposits)),
+		NextCursor: next,
+		Status:     "ok",
+	}
+	for _, st := range deposits {
+		resp.Deposits = append(resp.Deposits, newDepositResponse(st))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type adminRequest struct {
+	State  models.State `json:"state"`
+	Reason string       `json:"reason"`
+}
+
+type adminDepositResponse struct {
+	Deposit depositResponse `json:"deposit"`
+	Status  string          `json:"status"`
+}
+
+type adminChainsResponse struct {
+	Paused []models.Chain `json:"paused"`
+	Status string         `json:"status"`
+}
+
+// HandleAdmin serves manual interventions, all of them POST with an optional JSON adminRequest body. Actions are
+// audited as the key they were made with.
+//
+//	/admin/deposits/:id/retry   retry a failed deposit from `state`, or the state it failed at
+//	/admin/deposits/:id/cancel  cancel an in flight or failed deposit
+//	/admin/deposits/:id/force   force `state`, `reason` is required
+//	/admin/chains/:chain/pause  stop transitioning deposits from or to the chain
+//	/admin/chains/:chain/resume
+//
+// and manage webhooks:
+//
+//	GET    /admin/webhooks                    list subscriptions, without their secrets
+//	POST   /admin/webhooks                    subscribe with a JSON webhookRequest, returns the secret
+//	DELETE /admin/webhooks/:id
+//	GET    /admin/webhooks/dead               list dead letters
+//	POST   /admin/webhooks/dead/:id/redrive   queue a dead letter again
+//
+// and API keys:
+//
+//	GET    /admin/keys       list keys, without their secrets
+//	POST   /admin/keys       create a key with a JSON apiKeyRequest, returns the token once
+//	DELETE /admin/keys/:id   revoke a key
+func (a *Api) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/"), "/")
+	switch parts[0] {
+	case "webhooks":
+		a.handleAdminWebhooks(w, r, parts[1:])
+		return
+	case "keys":
+		a.handleAdminKeys(w, r, parts[1:])
+		return
+	}
+	if a.admin == nil {
+		http.Error(w, "admin actions unavailable, the agent runs without a state machine", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(parts) != 3 || parts[1] == "" {
+		http.Error(w, "invalid request, expected /admin/deposits/:id/:action or /admin/chains/:chain/:action", http.StatusBadRequest)
+		return
+	}
+
+	var req adminRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.State = models.State(strings.ToUpper(string(req.State)))
+	actor := "unknown"
+	if key, ok := requestKey(r); ok {
+		actor = key.Name + "/" + key.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch parts[0] {
+	case "chains":
+		chain := models.Chain(parts[1])
+		if !slices.Contains(a.srcChains, parts[1]) && !slices.Contains(a.dstChains, parts[1]) {
+			http.Error(w, "unsupported chain", http.StatusBadRequest)
+			return
+		}
+		switch parts[2] {
+		case "pause":
+			a.admin.PauseChain(chain, actor, req.Reason)
+		case "resume":
+			a.admin.ResumeChain(chain, actor, req.Reason)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(adminChainsResponse{Paused: a.admin.PausedChains(), Status: "ok"})
+		return
+
+	case "deposits":
+		id := parts[1]
+		var st *models.DepositState
+		var err error
+		switch parts[2] {
+		case "retry":
+			st, err = a.admin.Retry(ctx, id, req.State, actor, req.Reason)
+		case "cancel":
+			st, err = a.admin.Cancel(ctx, id, actor, req.Reason)
+		case "force":
+			st, err = a.admin.ForceState(ctx, id, req.State, actor, req.Reason)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, stores.ErrExecutionNotFound):
+				http.Error(w, "deposit not found", http.StatusNotFound)
+			case errors.Is(err, ErrReasonRequired):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, ErrInvalidAdminAction):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(adminDepositResponse{Deposit: newDepositResponse(st), Status: "ok"})
+		return
+	}
+
+	http.Error(w, "invalid request, expected /admin/deposits/:id/:action or /admin/chains/:chain/:action", http.StatusBadRequest)
+}
+
+type webhookRequest struct {
+	URL    string                `json:"url"`
+	Events []models.WebhookEvent `json:"events"`
+	Secret string                `json:"secret"`
+}
+
+type webhookResponse struct {
+	ID        string                `json:"id"`
+	URL       string                `json:"url"`
+	Events    []models.WebhookEvent `json:"events"`
+	Secret    string                `json:"secret,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+type webhooksResponse struct {
+	Webhooks []webhookResponse `json:"webhooks"`
+	Status   string            `json:"status"`
+}
+
+type deadLettersResponse struct {
+	DeadLetters []*models.WebhookDelivery `json:"dead_letters"`
+	Status      string                    `json:"status"`
+}
+
+func newWebhookResponse(sub *models.WebhookSubscription, withSecret bool) webhookResponse {
+	resp := webhookResponse{ID: sub.ID, URL: sub.URL, Events: sub.Events, CreatedAt: sub.CreatedAt}
+	if withSecret {
+		resp.Secret = sub.Secret
+	}
+	return resp
+}
+
+func (a *Api) handleAdminWebhooks(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+
+	if a.webhooks == nil {
+		http.Error(w, "webhooks unavailable, the agent runs without a webhook dispatcher", http.StatusServiceUnavailable)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "" {
+		parts = nil
+	}
+	method := r.Method
+	switch {
+	case len(parts) == 0 && method == http.MethodGet:
+		subs, err := a.webhooks.Subscriptions(ctx)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		resp := webhooksResponse{Webhooks: make([]webhookResponse, 0, len(subs)), Status: "ok"}
+		for _, sub := range subs {
+			resp.Webhooks = append(resp.Webhooks, newWebhookResponse(sub, false))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case len(parts) == 0 && method == http.MethodPost:
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		sub, err := a.webhooks.Subscribe(ctx, req.URL, req.Events, req.Secret)
+		if err != nil {
+			if errors.Is(err, ErrInvalidSubscription) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newWebhookResponse(sub, true))
+
+	case len(parts) == 1 && parts[0] == "dead" && method == http.MethodGet:
+		dead, err := a.webhooks.DeadLetters(ctx)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if dead == nil {
+			dead = []*models.WebhookDelivery{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deadLettersResponse{DeadLetters: dead, Status: "ok"})
+
+	case len(parts) == 3 && parts[0] == "dead" && parts[2] == "redrive" && method == http.MethodPost:
+		if _, err := a.webhooks.Redrive(ctx, parts[1]); err != nil {
+			if errors.Is(err, stores.ErrDeliveryNotFound) {
+				http.Error(w, "dead letter not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+
+	case len(parts) == 1 && parts[0] != "dead" && method == http.MethodDelete:
+		if err := a.webhooks.Unsubscribe(ctx, parts[0]); err != nil {
+			if errors.Is(err, stores.ErrSubscriptionNotFound) {
+				http.Error(w, "webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "invalid request, see /admin/webhooks endpoints", http.StatusBadRequest)
+	}
+}
+
+type apiKeyRequest struct {
+	Name          string            `json:"name"`
+	Scopes        []models.APIScope `json:"scopes"`
+	RatePerSecond float64           `json:"rate_per_second"`
+	Burst         int               `json:"burst"`
+}
+
+type apiKeyResponse struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Scopes        []models.APIScope `json:"scopes"`
+	RatePerSecond float64           `json:"rate_per_second,omitempty"`
+	Burst         int               `json:"burst,omitempty"`
+	Token         string            `json:"token,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+type apiKeysResponse struct {
+	Keys   []apiKeyResponse `json:"keys"`
+	Status string           `json:"status"`
+}
+
+func newAPIKeyResponse(key *models.APIKey, token string) apiKeyResponse {
+	return apiKeyResponse{
+		ID:            key.ID,
+		Name:          key.Name,
+		Scopes:        key.Scopes,
+		RatePerSecond: key.RatePerSecond,
+		Burst:         key.Burst,
+		Token:         token,
+		CreatedAt:     key.CreatedAt,
+	}
+}
+
+func (a *Api) handleAdminKeys(w http.ResponseWriter, r *http.Request, parts []string) {
+	ctx := r.Context()
+
+	if len(parts) == 1 && parts[0] == "" {
+		parts = nil
+	}
+	method := r.Method
+	switch {
+	case len(parts) == 0 && method == http.MethodGet:
+		keys, err := a.apiKeys.List(ctx)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		resp := apiKeysResponse{Keys: make([]apiKeyResponse, 0, len(keys)), Status: "ok"}
+		for _, key := range keys {
+			resp.Keys = append(resp.Keys, newAPIKeyResponse(key, ""))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case len(parts) == 0 && method == http.MethodPost:
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		key, token, err := NewAPIKey(req.Name, req.Scopes, RateLimit{PerSecond: req.RatePerSecond, Burst: req.Burst})
+		if err != nil {
+			if errors.Is(err, ErrInvalidAPIKey) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := a.apiKeys.Put(ctx, key); err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newAPIKeyResponse(key, token))
+
+	case len(parts) == 1 && method == http.MethodDelete:
+		if err := a.apiKeys.Delete(ctx, parts[0]); err != nil {
+			if errors.Is(err, stores.ErrAPIKeyNotFound) {
+				http.Error(w, "api key not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "invalid request, see /admin/keys endpoints", http.StatusBadRequest)
+	}
+}
+
+// HandleStream streams the state transitions of deposits to `deposit_addr` or for `dst_addr` as Server-Sent Events,
+// one `deposit` event per transition. The stream ends when the subscriber falls behind, clients reconnect and catch up
+// with /deposits.
+func (a *Api) HandleStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.broker == nil {
+		http.Error(w, "streaming disabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	var filter stores.DepositFilter
+	if v := q.Get("deposit_addr"); v != "" {
+		if !common.IsHexAddress(v) {
+			http.Error(w, "invalid deposit address", http.StatusBadRequest)
+			return
+		}
+		filter.DepositAddr = common.HexToAddress(v)
+	}
+	if v := q.Get("dst_addr"); v != "" {
+		if !common.IsHexAddress(v) {
+			http.Error(w, "invalid destination address", http.StatusBadRequest)
+			return
+		}
+		filter.DstAddr = common.HexToAddress(v)
+	}
+	if filter == (stores.DepositFilter{}) {
+		http.Error(w, "invalid request, expected deposit_addr or dst_addr", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	states, cancel := a.broker.Subscribe(filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case st, ok := <-states:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(newDepositResponse(st))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: deposit\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
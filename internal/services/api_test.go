@@ -1,11 +1,17 @@
 package services
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"unit/agent/internal/mocks"
@@ -19,7 +25,28 @@ func newAPIForTest(ks stores.IKeyStore, as stores.IAccountStore) *Api {
 	src := []string{"ethereum"}
 	dst := []string{"hyperliquid"}
 	assets := []string{"usdc"}
-	return NewApi(ks, as, src, dst, assets)
+	return NewApi(ks, as, newMockStateStore(), nil, nil, src, dst, assets)
+}
+
+// addAPIKeyForTest stores a key with `scopes` on the api, creating its store if needed, and returns its token
+func addAPIKeyForTest(t *testing.T, api *Api, name string, scopes ...models.APIScope) (*models.APIKey, string) {
+	t.Helper()
+	if api.apiKeys == nil {
+		aks, err := stores.NewLocalAPIKeyStore(filepath.Join(t.TempDir(), "apikeys.db"))
+		if err != nil {
+			t.Fatalf("NewLocalAPIKeyStore: %v", err)
+		}
+		t.Cleanup(func() { _ = aks.Close() })
+		api.apiKeys = aks
+	}
+	key, token, err := NewAPIKey(name, scopes, RateLimit{})
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	if err := api.apiKeys.Put(context.Background(), key); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	return key, token
 }
 
 func TestHandleGenerate_ExistingAccount(t *testing.T) {
@@ -201,3 +228,376 @@ func TestHandleGenerate_InternalErrorOnInsert(t *testing.T) {
 		t.Fatalf("status = %d, want 500", w.Result().StatusCode)
 	}
 }
+
+func TestHandleGenerate_ConcurrentInsertReturnsExistingAccount(t *testing.T) {
+	winner := &models.Account{DepositAddr: common.HexToAddress("0x1111111111111111111111111111111111111111")}
+	var inserted bool
+	ks := &mocks.MockKeyStore{Addr: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	as := &mocks.MockAccountStore{
+		GetFn: func(ctx context.Context, id string) (*models.Account, error) {
+			if !inserted {
+				return nil, stores.ErrAccountNotFound
+			}
+			return winner, nil
+		},
+		InsertFn: func(ctx context.Context, a models.Account) error {
+			inserted = true
+			return stores.ErrAccountExists
+		},
+	}
+	api := newAPIForTest(ks, as)
+
+	w := httptest.NewRecorder()
+	api.HandleGenerate(w, httptest.NewRequest(http.MethodGet, "/gen/ethereum/hyperliquid/usdc/0x960b650301e941c095aef35f57ae1b2d73fc4df1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var body generateResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Address != winner.DepositAddr.Hex() {
+		t.Fatalf("address = %s, want the concurrently inserted %s", body.Address, winner.DepositAddr.Hex())
+	}
+}
+
+func TestHandleGenerate_StoresKeyIndex(t *testing.T) {
+	index := uint32(7)
+	ks := &mocks.MockKeyStore{Addr: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Index: &index}
+	as := &mocks.MockAccountStore{}
+	api := newAPIForTest(ks, as)
+
+	req := httptest.NewRequest(http.MethodGet, "/gen/ethereum/hyperliquid/usdc/0x960b650301e941c095aef35f57ae1b2d73fc4df1", nil)
+	w := httptest.NewRecorder()
+	api.HandleGenerate(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if as.Inserted == nil || as.Inserted.KeyIndex == nil || *as.Inserted.KeyIndex != index {
+		t.Fatalf("inserted account %+v, want key index %d", as.Inserted, index)
+	}
+}
+
+func TestHandleQuote_ReturnsBreakdown(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.fees = newFeeModelForTest(t, nil, models.FeeSchedule{Bps: 100})
+
+	req := httptest.NewRequest(http.MethodGet, "/quote/ethereum/hyperliquid/usdc/10000000", nil)
+	w := httptest.NewRecorder()
+	api.HandleQuote(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var body quoteResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Fees == nil || body.Fees.Gross.Int64() != 10_000_000 || body.Fees.Fee.Int64() != 100_000 || body.Fees.Net.Int64() != 9_900_000 || body.Fees.Payout.Int64() != 9_900_000 {
+		t.Fatalf("fees = %+v", body.Fees)
+	}
+	if body.Route.SrcChain != models.Ethereum || body.Route.Asset != models.AssetUsdc {
+		t.Fatalf("route = %+v", body.Route)
+	}
+}
+
+func TestHandleQuote_BadRequests(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.fees = newFeeModelForTest(t, nil, models.FeeSchedule{Flat: big.NewInt(1_000_000)})
+
+	for path, want := range map[string]int{
+		"/quote/ethereum/hyperliquid/usdc":            http.StatusBadRequest,
+		"/quote/solana/hyperliquid/usdc/1":            http.StatusBadRequest,
+		"/quote/ethereum/hyperliquid/usdc/-5":         http.StatusBadRequest,
+		"/quote/ethereum/hyperliquid/usdc/1.5":        http.StatusBadRequest,
+		"/quote/ethereum/hyperliquid/usdc/1000":       http.StatusBadRequest, // does not cover the flat fee
+		"/quote/ethereum/hyperliquid/usdc/2000000":    http.StatusOK,
+		"/quote/ethereum/hyperliquid/doge/2000000":    http.StatusBadRequest,
+		"/quote/ethereum/ethereum/usdc/2000000":       http.StatusBadRequest,
+		"/quote/ethereum/hyperliquid/usdc/2000000/xx": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		api.HandleQuote(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Fatalf("%s: status = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestHandleGetDeposit_ReturnsStateAndHistory(t *testing.T) {
+	ss := newMockStateStore()
+	api := NewApi(&mocks.MockKeyStore{}, &mocks.MockAccountStore{}, ss, nil, nil, nil, nil, nil)
+
+	depositAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	st := &models.DepositState{
+		ID:          depositAddr.Hex() + "|0xabc",
+		TxHash:      "0xabc",
+		DepositAddr: depositAddr,
+		Asset:       models.AssetEth,
+		AmountWei:   big.NewInt(1e18),
+		State:       models.StateSrcTxDiscovered,
+	}
+	ss.Put(context.Background(), st)
+	st.State = models.StateDstTxSent
+	st.SentDstTxHash = "0xdef"
+	ss.Put(context.Background(), st)
+
+	w := httptest.NewRecorder()
+	api.HandleGetDeposit(w, httptest.NewRequest(http.MethodGet, "/deposits/"+depositAddr.Hex()+"%7C0xabc", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var body getDepositResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	d := body.Deposit
+	if d.State != models.StateDstTxSent || d.SrcTxHash != "0xabc" || d.DstTxHash != "0xdef" || d.Amount.Cmp(big.NewInt(1e18)) != 0 {
+		t.Fatalf("deposit = %+v", d)
+	}
+	if len(body.History) != 2 || body.History[0].To != models.StateSrcTxDiscovered || body.History[1].From != models.StateSrcTxDiscovered {
+		t.Fatalf("history = %+v", body.History)
+	}
+}
+
+func TestHandleGetDeposit_NotFound(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+
+	w := httptest.NewRecorder()
+	api.HandleGetDeposit(w, httptest.NewRequest(http.MethodGet, "/deposits/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestHandleListDeposits_FiltersAndPages(t *testing.T) {
+	ss := newMockStateStore()
+	api := NewApi(&mocks.MockKeyStore{}, &mocks.MockAccountStore{}, ss, nil, nil, nil, nil, nil)
+
+	depositAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	dstAddr := common.HexToAddress("0x960b650301e941c095aef35f57ae1b2d73fc4df1")
+	for i, state := range []models.State{models.StateDone, models.StateDstTxSent, models.StateDone, models.StateDone} {
+		ss.Put(context.Background(), &models.DepositState{
+			ID:          fmt.Sprintf("%s|0x%02d", depositAddr.Hex(), i),
+			DepositAddr: depositAddr,
+			DstAddr:     dstAddr,
+			State:       state,
+		})
+	}
+
+	list := func(query string) listDepositsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		api.HandleListDeposits(w, httptest.NewRequest(http.MethodGet, "/deposits?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", query, w.Code, w.Body.String())
+		}
+		var body listDepositsResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return body
+	}
+
+	first := list("dst_addr=" + dstAddr.Hex() + "&state=done&limit=2")
+	if len(first.Deposits) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	second := list("dst_addr=" + dstAddr.Hex() + "&state=done&limit=2&cursor=" + url.QueryEscape(first.NextCursor))
+	if len(second.Deposits) != 1 || second.NextCursor != "" || second.Deposits[0].ID != depositAddr.Hex()+"|0x03" {
+		t.Fatalf("second page = %+v", second)
+	}
+
+	for _, query := range []string{"dst_addr=nope", "deposit_addr=nope", "state=nope", "limit=0", "limit=1000"} {
+		w := httptest.NewRecorder()
+		api.HandleListDeposits(w, httptest.NewRequest(http.MethodGet, "/deposits?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestHandleAdmin_Disabled(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+
+	w := httptest.NewRecorder()
+	api.HandleAdmin(w, httptest.NewRequest(http.MethodPost, "/admin/chains/ethereum/pause", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("disabled status = %d, want 503", w.Code)
+	}
+
+	// key management does not need the state machine
+	addAPIKeyForTest(t, api, "ops", models.ScopeAdmin)
+	w = httptest.NewRecorder()
+	api.HandleAdmin(w, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("keys status = %d, want 200", w.Code)
+	}
+}
+
+func TestHandleAdmin_DepositActions(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	ss := newMockStateStore()
+	sm.states = ss
+	ss.Put(context.Background(), &models.DepositState{ID: "dep|0x1", State: models.StateDstTxSent})
+
+	api := NewApi(&mocks.MockKeyStore{}, &mocks.MockAccountStore{}, ss, nil, nil, []string{"ethereum"}, []string{"hyperliquid"}, nil)
+	api.SetAdmin(sm)
+	key, token := addAPIKeyForTest(t, api, "alice", models.ScopeAdmin)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/admin/deposits/dep%7C0x1/force", `{"state":"done"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("force without reason status = %d, want 400", w.Code)
+	}
+	if w := post("/admin/deposits/dep%7C0x1/retry", ``); w.Code != http.StatusConflict {
+		t.Fatalf("retry in flight status = %d, want 409", w.Code)
+	}
+	if w := post("/admin/deposits/missing/cancel", ``); w.Code != http.StatusNotFound {
+		t.Fatalf("cancel missing status = %d, want 404", w.Code)
+	}
+
+	w := post("/admin/deposits/dep%7C0x1/cancel", `{"reason":"duplicate"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var body adminDepositResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Deposit.State != models.StateCanceled || len(body.Deposit.Audit) != 1 || body.Deposit.Audit[0].Actor != "alice/"+key.ID {
+		t.Fatalf("deposit = %+v", body.Deposit)
+	}
+
+	w = post("/admin/chains/hyperliquid/pause", `{"reason":"incident"}`)
+	var chains adminChainsResponse
+	if err := json.NewDecoder(w.Body).Decode(&chains); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(chains.Paused) != 1 || chains.Paused[0] != models.Hyperliquid {
+		t.Fatalf("paused = %v", chains.Paused)
+	}
+	if w := post("/admin/chains/solana/pause", ``); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown chain status = %d, want 400", w.Code)
+	}
+}
+
+func TestHandleStream_SendsTransitions(t *testing.T) {
+	broker := NewBroker()
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetBroker(broker)
+	srv := httptest.NewServer(http.HandlerFunc(api.HandleStream))
+	defer srv.Close()
+
+	dstAddr := common.HexToAddress("0x960b650301e941c095aef35f57ae1b2d73fc4df1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?dst_addr="+dstAddr.Hex(), nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	// the response headers are flushed after subscribing
+	broker.Publish(&models.DepositState{ID: "other", State: models.StateDone})
+	broker.Publish(&models.DepositState{ID: "dep", DstAddr: dstAddr, State: models.StateDstTxSent, SentDstTxHash: "0xdst"})
+
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for scanner.Scan() && len(lines) < 2 {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	if len(lines) != 2 || lines[0] != "event: deposit" {
+		t.Fatalf("lines = %q", lines)
+	}
+	var d depositResponse
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &d); err != nil {
+		t.Fatalf("decode %q: %v", lines[1], err)
+	}
+	if d.ID != "dep" || d.State != models.StateDstTxSent || d.DstTxHash != "0xdst" {
+		t.Fatalf("deposit = %+v", d)
+	}
+}
+
+func TestHandleStream_RequiresFilter(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetBroker(NewBroker())
+
+	for path, want := range map[string]int{
+		"/stream":                  http.StatusBadRequest,
+		"/stream?dst_addr=nope":    http.StatusBadRequest,
+		"/stream?deposit_addr=bad": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		api.HandleStream(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Fatalf("%s: status = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestHandleAdmin_Webhooks(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetAdmin(newStateMachineForTest(t, &mockChainProvider{}))
+	d, _ := newWebhookDispatcherForTest(t)
+	api.SetWebhooks(d)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		api.HandleAdmin(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/admin/webhooks", `{"url":"https://example.com/hook","events":["deposit.completed"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	var created webhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.ID == "" || created.Secret == "" {
+		t.Fatalf("created = %+v, want id and secret", created)
+	}
+	if w := do(http.MethodPost, "/admin/webhooks", `{"url":"ftp://example.com"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid url status = %d, want 400", w.Code)
+	}
+
+	w = do(http.MethodGet, "/admin/webhooks", "")
+	var list webhooksResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list.Webhooks) != 1 || list.Webhooks[0].Secret != "" {
+		t.Fatalf("list = %+v, want one webhook without secret", list.Webhooks)
+	}
+
+	if w := do(http.MethodGet, "/admin/webhooks/dead", ""); w.Code != http.StatusOK {
+		t.Fatalf("dead letters status = %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/admin/webhooks/dead/missing/redrive", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("redrive missing status = %d, want 404", w.Code)
+	}
+	if w := do(http.MethodDelete, "/admin/webhooks/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want 204", w.Code)
+	}
+	if w := do(http.MethodDelete, "/admin/webhooks/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("second delete status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+
+	defaultKeyRateLimit = RateLimit{PerSecond: 5, Burst: 20}
+	defaultIPRateLimit  = RateLimit{PerSecond: 10, Burst: 40}
+)
+
+const HeaderAPIKey = "X-API-Key"
+
+type apiKeyCtxKey struct{}
+
+// NewAPIKey creates a key with `scopes` and returns it with its token, "<id>.<secret>". Only the hash of the secret is
+// kept on the key, the token cannot be recovered from it.
+func NewAPIKey(name string, scopes []models.APIScope, limit RateLimit) (*models.APIKey, string, error) {
+	if name == "" || len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w, name and scopes are required", ErrInvalidAPIKey)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(models.APIScopes, s) {
+			return nil, "", fmt.Errorf("%w, unknown scope %q", ErrInvalidAPIKey, s)
+		}
+	}
+	if limit.PerSecond < 0 || limit.Burst < 0 {
+		return nil, "", fmt.Errorf("%w, negative rate limit", ErrInvalidAPIKey)
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := &models.APIKey{
+		ID:            id,
+		Name:          name,
+		SecretHash:    hashSecret(secret),
+		Scopes:        scopes,
+		RatePerSecond: limit.PerSecond,
+		Burst:         limit.Burst,
+		CreatedAt:     time.Now(),
+	}
+	return key, id + "." + secret, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetRateLimits configures the limit of keys without their own, and the limit of each client IP
+func (a *Api) SetRateLimits(perKey, perIP RateLimit) {
+	a.keyLimit = perKey
+	a.ipLimit = perIP
+}
+
+// authenticate resolves the key of the request's token, from the Authorization bearer or the X-API-Key header
+func (a *Api) authenticate(r *http.Request) (*models.APIKey, error) {
+	token := r.Header.Get(HeaderAPIKey)
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := a.apiKeys.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, stores.ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+// authorize wraps `next` so it is only served to keys with `scope`. Requests are rate limited per client IP before the
+// key is checked, so invalid keys cannot be guessed at full speed, and per key after.
+func (a *Api) authorize(scope models.APIScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if ok, retryAfter := a.limiter.Allow("ip:"+clientIP(r), a.ipLimit, now); !ok {
+			tooManyRequests(w, retryAfter)
+			return
+		}
+
+		key, err := a.authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrInvalidAPIKey) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !key.HasScope(scope) {
+			http.Error(w, "forbidden, key lacks the "+string(scope)+" scope", http.StatusForbidden)
+			return
+		}
+
+		limit := a.keyLimit
+		if key.RatePerSecond > 0 {
+			limit = RateLimit{PerSecond: key.RatePerSecond, Burst: max(key.Burst, 1)}
+		}
+		if ok, retryAfter := a.limiter.Allow("key:"+key.ID, limit, now); !ok {
+			tooManyRequests(w, retryAfter)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, key)))
+	}
+}
+
+// requestKey returns the key an authorized request was made with
+func requestKey(r *http.Request) (*models.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyCtxKey{}).(*models.APIKey)
+	return key, ok
+}
+
+// clientIP is the address of the peer, the agent is expected to be reached directly rather than through a proxy
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"unit/agent/internal/mocks"
+	"unit/agent/internal/models"
+)
+
+func serveForTest(api *Api, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	api.server.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestNewAPIKey_Validates(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		scopes []models.APIScope
+		limit  RateLimit
+	}{
+		{scopes: []models.APIScope{models.ScopeRead}},
+		{name: "ops"},
+		{name: "ops", scopes: []models.APIScope{"write"}},
+		{name: "ops", scopes: []models.APIScope{models.ScopeRead}, limit: RateLimit{PerSecond: -1}},
+	} {
+		if _, _, err := NewAPIKey(tc.name, tc.scopes, tc.limit); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Fatalf("%+v: err = %v, want ErrInvalidAPIKey", tc, err)
+		}
+	}
+
+	key, token, err := NewAPIKey("ops", []models.APIScope{models.ScopeAdmin}, RateLimit{})
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	id, secret, _ := strings.Cut(token, ".")
+	if id != key.ID || secret == "" || key.SecretHash != hashSecret(secret) {
+		t.Fatalf("token %q does not match key %+v", token, key)
+	}
+}
+
+func TestAuthorize_ChecksKeyAndScope(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	_, reader := addAPIKeyForTest(t, api, "reader", models.ScopeRead)
+	revoked, revokedToken := addAPIKeyForTest(t, api, "revoked", models.ScopeRead)
+	if err := api.apiKeys.Delete(t.Context(), revoked.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	id, _, _ := strings.Cut(reader, ".")
+
+	for _, tc := range []struct {
+		desc   string
+		header http.Header
+		want   int
+	}{
+		{desc: "no key", want: http.StatusUnauthorized},
+		{desc: "malformed", header: bearer("nope"), want: http.StatusUnauthorized},
+		{desc: "wrong secret", header: bearer(id + ".wrong"), want: http.StatusUnauthorized},
+		{desc: "revoked", header: bearer(revokedToken), want: http.StatusUnauthorized},
+		{desc: "bearer", header: bearer(reader), want: http.StatusOK},
+		{desc: "header", header: http.Header{HeaderAPIKey: {reader}}, want: http.StatusOK},
+	} {
+		if w := serveForTest(api, http.MethodGet, "/deposits", "", tc.header); w.Code != tc.want {
+			t.Fatalf("%s: status = %d, want %d", tc.desc, w.Code, tc.want)
+		}
+	}
+
+	if w := serveForTest(api, http.MethodGet, "/gen/ethereum/hyperliquid/usdc/0x960b650301e941c095aef35f57ae1b2d73fc4df1", "", bearer(reader)); w.Code != http.StatusForbidden {
+		t.Fatalf("generate with read key status = %d, want 403", w.Code)
+	}
+	if w := serveForTest(api, http.MethodGet, "/admin/keys", "", bearer(reader)); w.Code != http.StatusForbidden {
+		t.Fatalf("admin with read key status = %d, want 403", w.Code)
+	}
+}
+
+func TestAuthorize_RateLimitsPerKey(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetRateLimits(RateLimit{PerSecond: 0.5, Burst: 2}, RateLimit{PerSecond: 100, Burst: 100})
+	_, first := addAPIKeyForTest(t, api, "first", models.ScopeRead)
+	_, second := addAPIKeyForTest(t, api, "second", models.ScopeRead)
+
+	for i := 0; i < 2; i++ {
+		if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer(first)); w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, w.Code)
+		}
+	}
+	w := serveForTest(api, http.MethodGet, "/deposits", "", bearer(first))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status past the burst = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+
+	// keys share the client ip but not their budget
+	if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer(second)); w.Code != http.StatusOK {
+		t.Fatalf("other key status = %d, want 200", w.Code)
+	}
+}
+
+func TestAuthorize_RateLimitsPerIP(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetRateLimits(RateLimit{PerSecond: 100, Burst: 100}, RateLimit{PerSecond: 1, Burst: 3})
+	addAPIKeyForTest(t, api, "reader", models.ScopeRead)
+
+	for i := 0; i < 3; i++ {
+		if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer("guess.wrong")); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d status = %d, want 401", i, w.Code)
+		}
+	}
+	if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer("guess.wrong")); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("guess past the burst status = %d, want 429", w.Code)
+	}
+}
+
+func TestHandleAdmin_Keys(t *testing.T) {
+	api := newAPIForTest(&mocks.MockKeyStore{}, &mocks.MockAccountStore{})
+	api.SetAdmin(newStateMachineForTest(t, &mockChainProvider{}))
+	_, admin := addAPIKeyForTest(t, api, "ops", models.ScopeAdmin)
+
+	w := serveForTest(api, http.MethodPost, "/admin/keys", `{"name":"partner","scopes":["generate","read"],"rate_per_second":1,"burst":5}`, bearer(admin))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	var created apiKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Token == "" || created.RatePerSecond != 1 || created.Burst != 5 {
+		t.Fatalf("created = %+v", created)
+	}
+	if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer(created.Token)); w.Code != http.StatusOK {
+		t.Fatalf("created key status = %d, want 200", w.Code)
+	}
+	if w := serveForTest(api, http.MethodPost, "/admin/keys", `{"name":"partner","scopes":["root"]}`, bearer(admin)); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown scope status = %d, want 400", w.Code)
+	}
+
+	w = serveForTest(api, http.MethodGet, "/admin/keys", "", bearer(admin))
+	var list apiKeysResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list.Keys) != 2 {
+		t.Fatalf("listed %d keys, want 2", len(list.Keys))
+	}
+	for _, k := range list.Keys {
+		if k.Token != "" {
+			t.Fatalf("listed key %s has its token", k.ID)
+		}
+	}
+
+	if w := serveForTest(api, http.MethodDelete, "/admin/keys/"+created.ID, "", bearer(admin)); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want 204", w.Code)
+	}
+	if w := serveForTest(api, http.MethodDelete, "/admin/keys/"+created.ID, "", bearer(admin)); w.Code != http.StatusNotFound {
+		t.Fatalf("second delete status = %d, want 404", w.Code)
+	}
+	if w := serveForTest(api, http.MethodGet, "/deposits", "", bearer(created.Token)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("deleted key status = %d, want 401", w.Code)
+	}
+}
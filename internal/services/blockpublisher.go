@@ -2,31 +2,60 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
 
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// ReorgEvent is emitted when the canonical chain no longer contains blocks that were already published.
+// Blocks From..To (inclusive) were orphaned, Orphaned holds their hashes as published.
+type ReorgEvent struct {
+	Chain    models.Chain
+	From     uint64
+	To       uint64
+	Orphaned []common.Hash
+}
+
+// BlockEvent is published in chain order, either a new block or a reorg of blocks published before it. Consumers
+// handle events in order, so a reorg is always handled before the blocks of the new canonical chain replacing it.
+type BlockEvent struct {
+	Block *types.Block
+	Reorg *ReorgEvent
+}
+
 type BlockPublisher struct {
-	client   *ethclient.Client
-	interval time.Duration
+	client      *ethclient.Client
+	chain       models.Chain
+	checkpoints stores.ICheckpointStore
+	interval    time.Duration
+	reorgWindow uint64
 
-	out chan *types.Block
+	out chan *BlockEvent
 	err chan error
 
 	lastBlock uint64
+	hashes    map[uint64]common.Hash // recently published block hashes, bounded by reorgWindow
 }
 
-func NewBlockPublisher(client *ethclient.Client) *BlockPublisher {
+func NewBlockPublisher(client *ethclient.Client, chain models.Chain, cs stores.ICheckpointStore) *BlockPublisher {
 	return &BlockPublisher{
-		client:    client,
-		interval:  2 * time.Second,
-		out:       make(chan *types.Block, 20),
-		err:       make(chan error, 1),
-		lastBlock: 0,
+		client:      client,
+		chain:       chain,
+		checkpoints: cs,
+		interval:    2 * time.Second,
+		reorgWindow: 64,
+		out:         make(chan *BlockEvent, 20),
+		err:         make(chan error, 1),
+		lastBlock:   0,
+		hashes:      make(map[uint64]common.Hash),
 	}
 }
 
@@ -37,7 +66,27 @@ func (bp *BlockPublisher) Start(ctx context.Context) error {
 	ticker := time.NewTicker(bp.interval)
 	defer ticker.Stop()
 
-	// start polling from last finalized block. In production, we should maintain a checkpointing component so that polling can continue from failure
+	// resume from the last fully processed block so deposits mined while the agent was down are not missed
+	if bp.lastBlock == 0 {
+		checkpoint, err := bp.checkpoints.Get(ctx, bp.chain)
+		if err != nil && !errors.Is(err, stores.ErrCheckpointNotFound) {
+			return fmt.Errorf("error getting checkpoint: %w", err)
+		}
+		if err == nil {
+			bp.lastBlock = checkpoint
+			// blocks committed before the restart are compared against the chain like published ones, so a reorg
+			// while the agent was down is detected when publishing resumes
+			hashes, err := bp.checkpoints.BlockHashes(ctx, bp.chain)
+			if err != nil {
+				return fmt.Errorf("error getting checkpoint hashes: %w", err)
+			}
+			for n, hash := range hashes {
+				bp.hashes[n] = hash
+			}
+		}
+	}
+
+	// no checkpoint, start polling from the current head
 	if bp.lastBlock == 0 {
 		if block, err := bp.getLatestBlock(ctx); err == nil && block != nil {
 			blockNumber := block.NumberU64()
@@ -74,7 +123,8 @@ func (bp *BlockPublisher) Start(ctx context.Context) error {
 			}
 
 			for n := bp.lastBlock + 1; n <= current; n++ {
-				if err := bp.publishBlock(ctx, n); err != nil {
+				reorg, err := bp.publishBlock(ctx, n)
+				if err != nil {
 					select {
 					case bp.err <- err:
 					case <-ctx.Done():
@@ -82,28 +132,108 @@ func (bp *BlockPublisher) Start(ctx context.Context) error {
 					}
 					break
 				}
+				if reorg != nil {
+					select {
+					case bp.out <- &BlockEvent{Reorg: reorg}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					// republish the new canonical blocks from the fork point on the next tick
+					bp.lastBlock = reorg.From - 1
+					break
+				}
 				bp.lastBlock = n
 			}
 		}
 	}
 }
 
-func (bp *BlockPublisher) Out() <-chan *types.Block { return bp.out }
+func (bp *BlockPublisher) Out() <-chan *BlockEvent { return bp.out }
+
+// Commit advances the checkpoint. Call only once the block has been fully processed, on restart publishing resumes after it.
+// The hash is recorded with it so a restart detects a reorg of the committed blocks.
+func (bp *BlockPublisher) Commit(ctx context.Context, blockNumber uint64, hash common.Hash) error {
+	return bp.checkpoints.PutBlock(ctx, bp.chain, blockNumber, hash, bp.reorgWindow)
+}
+
+// Rewind moves the checkpoint back to `blockNumber` if it is past it. Call once a reorg has been handled, orphaned
+// blocks published before the reorg may have been committed in the meantime.
+func (bp *BlockPublisher) Rewind(ctx context.Context, blockNumber uint64) error {
+	checkpoint, err := bp.checkpoints.Get(ctx, bp.chain)
+	if errors.Is(err, stores.ErrCheckpointNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting checkpoint: %w", err)
+	}
+	if checkpoint <= blockNumber {
+		return nil
+	}
+	return bp.checkpoints.Put(ctx, bp.chain, blockNumber)
+}
 
 func (bp *BlockPublisher) Err() <-chan error { return bp.err }
 
-func (bp *BlockPublisher) publishBlock(ctx context.Context, blockNumber uint64) error {
+// publishBlock fetches and publishes the block, unless its parent does not match the previously published block.
+// In that case nothing is published and the detected reorg is returned instead.
+func (bp *BlockPublisher) publishBlock(ctx context.Context, blockNumber uint64) (*ReorgEvent, error) {
 	block, err := bp.client.BlockByNumber(ctx, new(big.Int).SetUint64(blockNumber))
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if parent, ok := bp.hashes[blockNumber-1]; ok && parent != block.ParentHash() {
+		return bp.rollback(ctx, blockNumber-1)
 	}
 
 	select {
-	case bp.out <- block:
-		return nil
+	case bp.out <- &BlockEvent{Block: block}:
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	bp.hashes[blockNumber] = block.Hash()
+	if blockNumber > bp.reorgWindow {
+		delete(bp.hashes, blockNumber-bp.reorgWindow)
 	}
+	return nil, nil
+}
+
+// rollback walks back from `head` until a published hash matches the canonical chain again,
+// forgets everything above that fork point and rewinds the checkpoint if it is already past it, so a restart before the
+// reorg is handled rescans from the fork.
+func (bp *BlockPublisher) rollback(ctx context.Context, head uint64) (*ReorgEvent, error) {
+	fork := head
+	for {
+		published, ok := bp.hashes[fork]
+		if !ok {
+			// walked past the window, everything we still remember is orphaned
+			break
+		}
+		header, err := bp.client.HeaderByNumber(ctx, new(big.Int).SetUint64(fork))
+		if err != nil {
+			return nil, fmt.Errorf("error getting header %d: %w", fork, err)
+		}
+		if header.Hash() == published {
+			break
+		}
+		fork--
+	}
+
+	reorg := &ReorgEvent{Chain: bp.chain, From: fork + 1, To: head}
+	for n := fork + 1; n <= head; n++ {
+		if hash, ok := bp.hashes[n]; ok {
+			reorg.Orphaned = append(reorg.Orphaned, hash)
+			delete(bp.hashes, n)
+		}
+	}
+
+	if err := bp.Rewind(ctx, fork); err != nil {
+		return nil, fmt.Errorf("error rewinding checkpoint: %w", err)
+	}
+
+	fmt.Printf("reorg detected on %s, orphaned blocks %d-%d\n", bp.chain, reorg.From, reorg.To)
+	return reorg, nil
 }
 
 func (bp *BlockPublisher) getLatestBlock(ctx context.Context) (*types.Block, error) {
@@ -9,9 +9,14 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"unit/agent/internal/mocks"
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -35,6 +40,7 @@ type rpcError struct {
 }
 
 type fakeChain struct {
+	mu      sync.Mutex
 	latest  uint64
 	blocks  map[uint64]map[string]any
 	failHdr bool
@@ -49,6 +55,8 @@ func newFakeChain() *fakeChain {
 }
 
 func (fc *fakeChain) setLatest(n uint64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
 	fc.latest = n
 }
 
@@ -58,8 +66,18 @@ func (fc *fakeChain) setFail(headerFail, blockFail bool) {
 }
 
 func (fc *fakeChain) putBlock(n uint64) {
-	h := "0xa444b1e4f2e0cc3d93d50c489aca46b04b263f55879688c061cb70daf5b8a0fa"
+	fc.putBlockWithRoot(n, "0xa444b1e4f2e0cc3d93d50c489aca46b04b263f55879688c061cb70daf5b8a0fa")
+}
+
+// putBlockWithRoot builds block n on top of block n-1 if present. A different root yields a different block hash,
+// replacing an existing block with a different root and re-putting its descendants simulates a reorg.
+func (fc *fakeChain) putBlockWithRoot(n uint64, h string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
 	parent := fmt.Sprintf("0x%064x", n-1)
+	if prev, ok := fc.blocks[n-1]; ok {
+		parent = blockHash(prev).Hex()
+	}
 	fc.blocks[n] = map[string]any{
 		"number":           fmt.Sprintf("0x%x", n),
 		"hash":             h,
@@ -101,7 +119,18 @@ func (fc *fakeChain) putBlock(n uint64) {
 	}
 }
 
+// blockHash derives the hash the same way ethclient does, from the decoded header
+func blockHash(blk map[string]any) common.Hash {
+	raw, _ := json.Marshal(blk)
+	var header types.Header
+	_ = json.Unmarshal(raw, &header)
+	return header.Hash()
+}
+
 func (fc *fakeChain) serveRPC(w http.ResponseWriter, r *http.Request) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
 	var req rpcReq
 	buf := new(bytes.Buffer)
 	_, _ = buf.ReadFrom(r.Body)
@@ -195,13 +224,22 @@ func newEthClient(t *testing.T, fc *fakeChain) *ethclient.Client {
 	return cli
 }
 
-func readOut(t *testing.T, ch <-chan *types.Block, timeout time.Duration) *types.Block {
+func readOut(t *testing.T, ch <-chan *BlockEvent, timeout time.Duration) *types.Block {
+	t.Helper()
+	ev := readEvent(t, ch, timeout)
+	if ev.Block == nil {
+		t.Fatalf("got reorg %+v, want a block", ev.Reorg)
+	}
+	return ev.Block
+}
+
+func readEvent(t *testing.T, ch <-chan *BlockEvent, timeout time.Duration) *BlockEvent {
 	t.Helper()
 	select {
-	case b := <-ch:
-		return b
+	case ev := <-ch:
+		return ev
 	case <-time.After(timeout):
-		t.Fatalf("timeout waiting for block")
+		t.Fatalf("timeout waiting for block event")
 		return nil
 	}
 }
@@ -222,7 +260,7 @@ func TestBlockPublisher_Start_EmitsErrorOnHeaderFailure(t *testing.T) {
 	fc.setFail(true, false)
 
 	client := newEthClient(t, fc)
-	bp := NewBlockPublisher(client)
+	bp := NewBlockPublisher(client, models.Ethereum, &mocks.MockCheckpointStore{})
 	bp.interval = 10 * time.Millisecond
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -243,7 +281,7 @@ func TestBlockPublisher_getLatestBlock_ReturnsBlock(t *testing.T) {
 	fc.setLatest(7)
 
 	client := newEthClient(t, fc)
-	bp := NewBlockPublisher(client)
+	bp := NewBlockPublisher(client, models.Ethereum, &mocks.MockCheckpointStore{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -263,9 +301,9 @@ func TestBlockPublisher_publishBlock_PropagatesClientError(t *testing.T) {
 	fc.setFail(false, true)
 
 	client := newEthClient(t, fc)
-	bp := NewBlockPublisher(client)
+	bp := NewBlockPublisher(client, models.Ethereum, &mocks.MockCheckpointStore{})
 
-	err := bp.publishBlock(context.Background(), 9)
+	_, err := bp.publishBlock(context.Background(), 9)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -277,7 +315,7 @@ func TestBlockPublisher_Start_CancelStopsLoop(t *testing.T) {
 	fc.setLatest(1)
 
 	client := newEthClient(t, fc)
-	bp := NewBlockPublisher(client)
+	bp := NewBlockPublisher(client, models.Ethereum, &mocks.MockCheckpointStore{})
 	bp.interval = 5 * time.Millisecond
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -295,3 +333,168 @@ func TestBlockPublisher_Start_CancelStopsLoop(t *testing.T) {
 		t.Fatal("start loop did not stop")
 	}
 }
+
+func TestBlockPublisher_Start_ResumesFromCheckpoint(t *testing.T) {
+	fc := newFakeChain()
+	for n := uint64(1); n <= 4; n++ {
+		fc.putBlock(n)
+	}
+	fc.setLatest(4)
+
+	client := newEthClient(t, fc)
+	cs := &mocks.MockCheckpointStore{Blocks: map[models.Chain]uint64{models.Ethereum: 2}}
+	bp := NewBlockPublisher(client, models.Ethereum, cs)
+	bp.interval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bp.Start(ctx)
+
+	for _, want := range []uint64{3, 4} {
+		blk := readOut(t, bp.Out(), time.Second)
+		if blk.NumberU64() != want {
+			t.Fatalf("got block #%d, want %d", blk.NumberU64(), want)
+		}
+	}
+}
+
+func TestBlockPublisher_Commit_AdvancesCheckpoint(t *testing.T) {
+	cs := &mocks.MockCheckpointStore{}
+	bp := NewBlockPublisher(nil, models.Ethereum, cs)
+
+	if err := bp.Commit(context.Background(), 42, common.Hash{}); err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+	got, err := cs.Get(context.Background(), models.Ethereum)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got != 42 {
+		t.Fatalf("checkpoint = %d, want 42", got)
+	}
+}
+
+func TestBlockPublisher_Rewind_OnlyMovesBack(t *testing.T) {
+	cs := &mocks.MockCheckpointStore{}
+	bp := NewBlockPublisher(nil, models.Ethereum, cs)
+	ctx := context.Background()
+
+	if err := bp.Rewind(ctx, 5); err != nil {
+		t.Fatalf("Rewind without checkpoint: %v", err)
+	}
+	if _, err := cs.Get(ctx, models.Ethereum); err == nil {
+		t.Fatal("Rewind created a checkpoint")
+	}
+
+	// an orphaned block committed after the publisher detected the reorg
+	if err := bp.Commit(ctx, 7, common.Hash{}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := bp.Rewind(ctx, 5); err != nil {
+		t.Fatalf("Rewind: %v", err)
+	}
+	if got, _ := cs.Get(ctx, models.Ethereum); got != 5 {
+		t.Fatalf("checkpoint = %d, want rewound to 5", got)
+	}
+	if err := bp.Rewind(ctx, 6); err != nil {
+		t.Fatalf("Rewind: %v", err)
+	}
+	if got, _ := cs.Get(ctx, models.Ethereum); got != 5 {
+		t.Fatalf("checkpoint = %d, want 5, rewinding never advances it", got)
+	}
+}
+
+func TestBlockPublisher_Start_DetectsReorg(t *testing.T) {
+	fc := newFakeChain()
+	for n := uint64(1); n <= 3; n++ {
+		fc.putBlock(n)
+	}
+	fc.setLatest(3)
+
+	client := newEthClient(t, fc)
+	cs := &mocks.MockCheckpointStore{Blocks: map[models.Chain]uint64{models.Ethereum: 1}}
+	bp := NewBlockPublisher(client, models.Ethereum, cs)
+	bp.interval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bp.Start(ctx)
+
+	readOut(t, bp.Out(), time.Second)
+	orphan := readOut(t, bp.Out(), time.Second)
+	if orphan.NumberU64() != 3 {
+		t.Fatalf("got block #%d, want 3", orphan.NumberU64())
+	}
+	if err := cs.Put(ctx, models.Ethereum, 3); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	// replace block 3 and extend the new chain
+	fc.putBlockWithRoot(3, "0xb444b1e4f2e0cc3d93d50c489aca46b04b263f55879688c061cb70daf5b8a0fa")
+	fc.putBlock(4)
+	fc.setLatest(4)
+
+	// the reorg is published on the block stream, ahead of the blocks replacing the orphaned ones
+	reorg := readEvent(t, bp.Out(), time.Second).Reorg
+	if reorg == nil {
+		t.Fatal("got a block, want the reorg")
+	}
+	if reorg.From != 3 || reorg.To != 3 {
+		t.Fatalf("reorg range = %d-%d, want 3-3", reorg.From, reorg.To)
+	}
+	if len(reorg.Orphaned) != 1 || reorg.Orphaned[0] != orphan.Hash() {
+		t.Fatalf("orphaned = %v, want [%s]", reorg.Orphaned, orphan.Hash().Hex())
+	}
+
+	if cp, _ := cs.Get(ctx, models.Ethereum); cp != 2 {
+		t.Fatalf("checkpoint = %d, want rewound to 2", cp)
+	}
+
+	replaced := readOut(t, bp.Out(), time.Second)
+	if replaced.NumberU64() != 3 || replaced.Hash() == orphan.Hash() {
+		t.Fatalf("got block #%d %s, want new canonical block 3", replaced.NumberU64(), replaced.Hash().Hex())
+	}
+	if next := readOut(t, bp.Out(), time.Second); next.NumberU64() != 4 {
+		t.Fatalf("got block #%d, want 4", next.NumberU64())
+	}
+}
+
+func TestBlockPublisher_Start_DetectsReorgWhileStopped(t *testing.T) {
+	fc := newFakeChain()
+	for n := uint64(1); n <= 3; n++ {
+		fc.putBlock(n)
+	}
+	cs := &mocks.MockCheckpointStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// blocks up to 3 were committed before the agent stopped
+	for n := uint64(1); n <= 3; n++ {
+		if err := cs.PutBlock(ctx, models.Ethereum, n, blockHash(fc.blocks[n]), 64); err != nil {
+			t.Fatalf("PutBlock error: %v", err)
+		}
+	}
+	orphaned := blockHash(fc.blocks[3])
+
+	// block 3 was replaced while the agent was down
+	fc.putBlockWithRoot(3, "0xb444b1e4f2e0cc3d93d50c489aca46b04b263f55879688c061cb70daf5b8a0fa")
+	fc.putBlock(4)
+	fc.setLatest(4)
+
+	bp := NewBlockPublisher(newEthClient(t, fc), models.Ethereum, cs)
+	bp.interval = 5 * time.Millisecond
+	go bp.Start(ctx)
+
+	reorg := readEvent(t, bp.Out(), time.Second).Reorg
+	if reorg == nil {
+		t.Fatal("got a block, want the reorg")
+	}
+	if reorg.From != 3 || reorg.To != 3 || len(reorg.Orphaned) != 1 || reorg.Orphaned[0] != orphaned {
+		t.Fatalf("reorg = %+v, want block 3 %s orphaned", reorg, orphaned.Hex())
+	}
+	if cp, _ := cs.Get(ctx, models.Ethereum); cp != 2 {
+		t.Fatalf("checkpoint = %d, want rewound to 2", cp)
+	}
+	if replaced := readOut(t, bp.Out(), time.Second); replaced.NumberU64() != 3 || replaced.Hash() == orphaned {
+		t.Fatalf("got block #%d %s, want new canonical block 3", replaced.NumberU64(), replaced.Hash().Hex())
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"sync"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+)
+
+// subscriberBuffer is the number of states a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+// Broker fans out deposit states committed by the state machine to in process subscribers. Publishing never blocks,
+// a subscriber that falls behind is dropped by closing its channel, it can catch up from the state store and resubscribe.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+type subscription struct {
+	filter stores.DepositFilter
+	ch     chan *models.DepositState
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[*subscription]struct{})}
+}
+
+// Subscribe returns a channel receiving every published state matching the filter. The channel is closed by cancel,
+// or when the subscriber falls behind.
+func (b *Broker) Subscribe(filter stores.DepositFilter) (states <-chan *models.DepositState, cancel func()) {
+	sub := &subscription{filter: filter, ch: make(chan *models.DepositState, subscriberBuffer)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.drop(sub)
+	}
+}
+
+func (b *Broker) Publish(st *models.DepositState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.filter.Match(st) {
+			continue
+		}
+		cp := *st
+		select {
+		case sub.ch <- &cp:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+func (b *Broker) drop(sub *subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.ch)
+}
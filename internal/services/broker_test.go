@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBroker_DeliversMatchingStates(t *testing.T) {
+	b := NewBroker()
+	a := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	other := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	states, cancel := b.Subscribe(stores.DepositFilter{DepositAddr: a})
+	defer cancel()
+
+	b.Publish(&models.DepositState{ID: "other", DepositAddr: other})
+	st := &models.DepositState{ID: "mine", DepositAddr: a, State: models.StateSrcTxConfirmed}
+	b.Publish(st)
+	st.State = models.StateDone // published states are copies
+
+	select {
+	case got := <-states:
+		if got.ID != "mine" || got.State != models.StateSrcTxConfirmed {
+			t.Fatalf("got %s at %s", got.ID, got.State)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no state delivered")
+	}
+	select {
+	case got := <-states:
+		t.Fatalf("unexpected state %s", got.ID)
+	default:
+	}
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	a := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	states, cancel := b.Subscribe(stores.DepositFilter{DepositAddr: a})
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(&models.DepositState{ID: "dep", DepositAddr: a})
+	}
+	n := 0
+	for range states {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("received %d states before the channel closed, want %d", n, subscriberBuffer)
+	}
+	cancel() // no double close
+}
+
+func TestStateMachine_processDeposit_PublishesTransitions(t *testing.T) {
+	polls := 0
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			polls++
+			return polls > 1, nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	sm.states = newMockStateStore()
+	b := NewBroker()
+	sm.SetBroker(b)
+
+	addr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	states, cancel := b.Subscribe(stores.DepositFilter{DepositAddr: addr})
+	defer cancel()
+
+	st := &models.DepositState{ID: "dep", DepositAddr: addr, State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xsrc"}
+	sm.processDeposit(context.Background(), st) // still waiting, nothing published
+	sm.processDeposit(context.Background(), st)
+
+	select {
+	case got := <-states:
+		if got.State != models.StateSrcTxConfirmed {
+			t.Fatalf("published %s, want %s", got.State, models.StateSrcTxConfirmed)
+		}
+	default:
+		t.Fatal("transition not published")
+	}
+	select {
+	case got := <-states:
+		t.Fatalf("unexpected publish of %s", got.State)
+	default:
+	}
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,12 +17,46 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	hyperliquid "github.com/sonirico/go-hyperliquid"
 )
 
 var (
 	ErrorRejectedTransaction = errors.New("rejected transaction")
+	// the chain has no fee market to bump, or txs are final once accepted
+	ErrBumpNotSupported = errors.New("fee bumping not supported")
+	// a tx with the same nonce was already mined, there is nothing left to replace
+	ErrNonceConsumed = errors.New("nonce already consumed")
+	// the deposit address holds too little native balance to pay for a token sweep at current fees
+	ErrInsufficientGas = errors.New("insufficient gas balance")
+	// a chain RPC call failed, the same call may succeed when retried
+	ErrChainUnavailable = errors.New("chain unavailable")
+)
+
+// TxBroadcastError is returned by AbandonTx when the tx to abandon already reached the node, its nonce is kept
+type TxBroadcastError struct {
+	Hash string
+}
+
+func (e *TxBroadcastError) Error() string {
+	return fmt.Sprintf("tx %s was already broadcast", e.Hash)
+}
+
+var (
+	// topic of the ERC-20 Transfer(address,address,uint256) event
+	transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+	balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	transferSelector  = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+)
+
+// minimum fee increase in percent nodes accept for a same nonce replacement (geth txpool default)
+const replacementBumpPercent = 10
+
+const (
+	ethTransferGas   = uint64(21000)
+	tokenTransferGas = uint64(65000) // typical gas used by an ERC-20 transfer, only used for estimates
 )
 
 type IChainProvider interface {
@@ -31,34 +64,48 @@ type IChainProvider interface {
 }
 
 type ChainProvider struct {
-	ks        stores.IKeyStore
-	clients   map[models.Chain]*ethclient.Client
-	info      *hyperliquid.Info
-	hlPrivKey *ecdsa.PrivateKey
-	hlClient  *clients.HttpClient
+	ks       stores.IKeyStore
+	nonces   stores.INonceStore
+	clients  map[models.Chain]*ethclient.Client
+	info     *hyperliquid.Info
+	hlClient *clients.HttpClient
+
+	// max fee per gas of dynamic fee txs is base fee * maxFeeMultiplier + tip
+	maxFeeMultiplier uint64
+	// spot sends not in the sender's ledger this long after they were signed are rejected
+	hlConfirmDeadline time.Duration
 }
 
-func NewChainProvider(ks stores.IKeyStore, clients map[models.Chain]*ethclient.Client, info *hyperliquid.Info, privKey *ecdsa.PrivateKey, hlClient *clients.HttpClient) *ChainProvider {
+func NewChainProvider(ks stores.IKeyStore, ns stores.INonceStore, clients map[models.Chain]*ethclient.Client, info *hyperliquid.Info, hlClient *clients.HttpClient) *ChainProvider {
 	return &ChainProvider{
-		ks:        ks,
-		clients:   clients,
-		info:      info,
-		hlPrivKey: privKey,
-		hlClient:  hlClient,
+		ks:       ks,
+		nonces:   ns,
+		clients:  clients,
+		info:     info,
+		hlClient: hlClient,
+
+		maxFeeMultiplier:  2,
+		hlConfirmDeadline: 2 * time.Minute,
 	}
 }
 
+// SetMaxFeeMultiplier configures how many times the latest base fee a dynamic fee tx is willing to pay.
+// A higher multiplier keeps txs includable through longer base fee spikes, unused fees are refunded.
+func (wm *ChainProvider) SetMaxFeeMultiplier(multiplier uint64) {
+	wm.maxFeeMultiplier = multiplier
+}
+
 func (wm *ChainProvider) WithChain(chain models.Chain) ChainCtx {
 	if chain == models.Hyperliquid {
 		return &HlCtx{
-			wm:        wm,
-			info:      wm.info,
-			hlPrivKey: wm.hlPrivKey,
-			hlClient:  wm.hlClient,
+			wm:       wm,
+			info:     wm.info,
+			hlClient: wm.hlClient,
 		}
 	}
 	return &EvmCtx{
 		wm:     wm,
+		chain:  chain,
 		client: wm.clients[chain],
 	}
 }
@@ -70,12 +117,41 @@ type ChainCtx interface {
 	BuildSendTx(ctx context.Context, fromAddr string, toAddr string, amount *big.Int) (rawTx string, err error)
 	// Builds an unsigned transaction to send total balance (minus gas costs) from `fromAddr` to `toAddr`. Used to sweep from deposit addresses
 	BuildSweepTx(ctx context.Context, fromAddr string, toAddr string) (rawTx string, err error)
+	// Builds an unsigned transaction from `fromAddr` funding `toAddr` with exactly enough native balance to pay for one
+	// transfer of token `asset` out of `toAddr`. rawTx is empty if `toAddr` already holds enough.
+	BuildTopUpTx(ctx context.Context, fromAddr string, toAddr string, asset string) (rawTx string, amount *big.Int, err error)
+	// Builds an unsigned transaction sending the full token balance of `asset` from `fromAddr` to `toAddr`, gas is paid
+	// from the native balance topped up by BuildTopUpTx. Returns ErrInsufficientGas if it does not cover current fees.
+	BuildTokenSweepTx(ctx context.Context, fromAddr string, toAddr string, asset string) (rawTx string, err error)
 	// Waits for `minConfirmations` confirmations on `txHash`
 	IsTxConfirmed(ctx context.Context, txHash string, minConfirmations uint64) (bool, error)
+	// Rebuilds an unsigned transaction with the same nonce and higher fees to replace a stuck one. For sweeps the value
+	// is lowered so the address is still fully drained.
+	BumpTx(ctx context.Context, rawTx string, fromAddr string, sweep bool) (bumpedTx string, err error)
+	// Gives back the nonce of a tx built by BuildSendTx that will never be broadcast, so the next build fills the gap
+	// Returns a *TxBroadcastError if the tx already reached the node, its nonce is kept then.
+	AbandonTx(ctx context.Context, rawTx string, fromAddr string) error
+	// Resyncs nonce allocation for `fromAddr` with the chain. `unsent` are txs built by BuildSendTx still waiting to be broadcast
+	SyncNonces(ctx context.Context, fromAddr string, unsent []string) error
+	// Returns the transfers of registered tokens emitted in block `blockHash`
+	TokenTransfers(ctx context.Context, blockHash string) ([]TokenTransfer, error)
+	// Estimates the native cost of one transfer of `asset` at current fees
+	EstimateGasCost(ctx context.Context, asset string) (*big.Int, error)
+}
+
+// TokenTransfer is a Transfer event of a registered ERC-20 token
+type TokenTransfer struct {
+	Token    models.Token
+	TxHash   string
+	LogIndex uint
+	From     common.Address
+	To       common.Address
+	Amount   *big.Int
 }
 
 type EvmCtx struct {
 	wm     *ChainProvider
+	chain  models.Chain
 	client *ethclient.Client
 }
 
@@ -85,9 +161,9 @@ func (c *EvmCtx) BroadcastTx(ctx context.Context, rawTx string, fromAddr string)
 		return "", fmt.Errorf("error unmarshaling tx: %v", err)
 	}
 
-	chainID, err := c.client.NetworkID(ctx)
+	chainID, err := c.client.ChainID(ctx)
 	if err != nil {
-		return "", fmt.Errorf("NetworkID: %v", err)
+		return "", fmt.Errorf("ChainID: %v", err)
 	}
 
 	signed, err := c.wm.ks.SignTx(ctx, fromAddr, tx, chainID)
@@ -111,30 +187,50 @@ func (c *EvmCtx) BuildSendTx(ctx context.Context, fromAddr string, toAddr string
 	from := common.HexToAddress(fromAddr)
 	to := common.HexToAddress(toAddr)
 
-	nonce, err := c.client.PendingNonceAt(ctx, from)
+	balance, err := c.client.BalanceAt(ctx, from, nil)
 	if err != nil {
 		return "", err
 	}
-	balance, err := c.client.BalanceAt(ctx, from, nil)
+
+	chainID, err := c.client.ChainID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ChainID: %v", err)
+	}
+	tipCap, feeCap, err := c.suggestFees(ctx)
 	if err != nil {
 		return "", err
 	}
-
-	gasPrice, gasLimit, err := c.estimateGas(ctx, from, to, amount)
+	gasLimit, err := c.estimateGas(ctx, from, to, amount, nil)
 	if err != nil {
 		return "", err
 	}
 
-	gasCost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	// worst case cost, the unused part of the max fee is refunded
+	gasCost := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(gasLimit))
 	total := new(big.Int).Add(amount, gasCost)
 	if balance.Cmp(total) < 0 {
 		return "", fmt.Errorf("insufficient balance: have %s, need %s",
 			balance.String(), total.String())
 	}
 
-	tx := types.NewTransaction(nonce, to, amount, gasLimit, gasPrice, nil)
+	// reserved last so a failed balance or gas check does not leak it
+	nonce, err := c.reserveNonce(ctx, from)
+	if err != nil {
+		return "", err
+	}
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       gasLimit,
+		To:        &to,
+		Value:     amount,
+	})
 	rawTxBytes, err := tx.MarshalBinary()
 	if err != nil {
+		_ = c.releaseNonce(ctx, from, nonce)
 		return "", fmt.Errorf("error marshaling tx: %v", err)
 	}
 
@@ -155,19 +251,32 @@ func (c *EvmCtx) BuildSweepTx(ctx context.Context, fromAddr, toAddr string) (str
 		return "", err
 	}
 
-	gasPrice, err := c.client.SuggestGasPrice(ctx)
+	chainID, err := c.client.ChainID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ChainID: %v", err)
+	}
+	tipCap, feeCap, err := c.suggestFees(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	const ethTransferGas = uint64(21000)
-	gasCost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(ethTransferGas))
+	// the sender is charged at most feeCap * gas, reserving exactly that drains the address without the tx ever being underfunded.
+	// whatever part of the max fee is not used is refunded to the deposit address as dust
+	gasCost := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(ethTransferGas))
 	if balance.Cmp(gasCost) <= 0 {
 		return "", fmt.Errorf("insufficient balance: have %s need %s", balance, gasCost)
 	}
 	value := new(big.Int).Sub(balance, gasCost)
 
-	tx := types.NewTransaction(nonce, to, value, ethTransferGas, gasPrice, nil)
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       ethTransferGas,
+		To:        &to,
+		Value:     value,
+	})
 	raw, err := tx.MarshalBinary()
 	if err != nil {
 		return "", fmt.Errorf("marshal tx: %w", err)
@@ -175,9 +284,123 @@ func (c *EvmCtx) BuildSweepTx(ctx context.Context, fromAddr, toAddr string) (str
 	return common.Bytes2Hex(raw), nil
 }
 
+func (c *EvmCtx) BuildTopUpTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, *big.Int, error) {
+	sweep, err := c.tokenSweep(ctx, common.HexToAddress(toAddr), common.HexToAddress(fromAddr), asset)
+	if err != nil {
+		return "", nil, err
+	}
+	_, feeCap, err := c.suggestFees(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the sweep pays at most feeCap * gas, fund exactly the part the deposit address does not hold yet
+	need := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(sweep.gas))
+	need.Sub(need, sweep.native)
+	if need.Sign() <= 0 {
+		return "", new(big.Int), nil
+	}
+
+	raw, err := c.BuildSendTx(ctx, fromAddr, toAddr, need)
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, need, nil
+}
+
+func (c *EvmCtx) BuildTokenSweepTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, error) {
+	from := common.HexToAddress(fromAddr)
+	sweep, err := c.tokenSweep(ctx, from, common.HexToAddress(toAddr), asset)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := c.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return "", err
+	}
+	chainID, err := c.client.ChainID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ChainID: %v", err)
+	}
+	tipCap, feeCap, err := c.suggestFees(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	gas := new(big.Int).SetUint64(sweep.gas)
+	need := new(big.Int).Mul(feeCap, gas)
+	if sweep.native.Cmp(need) < 0 {
+		return "", fmt.Errorf("%w: have %s need %s", ErrInsufficientGas, sweep.native, need)
+	}
+	// spend the whole top-up on the max fee, whatever is not used is refunded to the deposit address as dust
+	feeCap = new(big.Int).Quo(sweep.native, gas)
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       sweep.gas,
+		To:        &sweep.token.Address,
+		Value:     new(big.Int),
+		Data:      sweep.data,
+	})
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("marshal tx: %w", err)
+	}
+	return common.Bytes2Hex(raw), nil
+}
+
+// tokenSweepPlan is the token transfer draining a deposit address and what the address holds to pay for it
+type tokenSweepPlan struct {
+	token  models.Token
+	data   []byte
+	gas    uint64
+	native *big.Int
+}
+
+// tokenSweep plans the transfer of the full `asset` balance of `from` to `to`
+func (c *EvmCtx) tokenSweep(ctx context.Context, from, to common.Address, asset string) (*tokenSweepPlan, error) {
+	token, ok := models.TokenByAsset(c.chain, asset)
+	if !ok {
+		return nil, fmt.Errorf("no %s token on chain %s", asset, c.chain)
+	}
+
+	out, err := c.client.CallContract(ctx, ethereum.CallMsg{
+		To:   &token.Address,
+		Data: append(append([]byte(nil), balanceOfSelector...), common.LeftPadBytes(from.Bytes(), 32)...),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balanceOf: %v", err)
+	}
+	balance := new(big.Int).SetBytes(out)
+	if balance.Sign() == 0 {
+		return nil, fmt.Errorf("zero %s balance for address %s", asset, from.Hex())
+	}
+
+	data := append(append([]byte(nil), transferSelector...), common.LeftPadBytes(to.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(balance.Bytes(), 32)...)
+	gas, err := c.estimateGas(ctx, from, token.Address, nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("error estimating token transfer gas: %v", err)
+	}
+
+	native, err := c.client.BalanceAt(ctx, from, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenSweepPlan{token: token, data: data, gas: gas, native: native}, nil
+}
+
 func (c *EvmCtx) IsTxConfirmed(ctx context.Context, txHash string, minConfirmations uint64) (bool, error) {
 	rcpt, err := c.client.TransactionReceipt(ctx, common.HexToHash(txHash))
 	if err != nil {
+		if errors.Is(err, ethereum.NotFound) {
+			// still pending, or dropped in favour of a replacement
+			return false, nil
+		}
 		return false, fmt.Errorf("error getting receipt: %v", err)
 	}
 	if rcpt.Status != types.ReceiptStatusSuccessful {
@@ -194,28 +417,257 @@ func (c *EvmCtx) IsTxConfirmed(ctx context.Context, txHash string, minConfirmati
 	return true, nil
 }
 
-func (c *EvmCtx) estimateGas(ctx context.Context, from, to common.Address, value *big.Int) (gasPrice *big.Int, gasLimit uint64, err error) {
-	gasPrice, err = c.client.SuggestGasPrice(ctx)
+func (c *EvmCtx) BumpTx(ctx context.Context, rawTx string, fromAddr string, sweep bool) (string, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.Hex2Bytes(rawTx)); err != nil {
+		return "", fmt.Errorf("error unmarshaling tx: %v", err)
+	}
+	if tx.Type() != types.DynamicFeeTxType {
+		return "", fmt.Errorf("cannot bump tx of type %d", tx.Type())
+	}
+
+	mined, err := c.client.NonceAt(ctx, common.HexToAddress(fromAddr), nil)
+	if err != nil {
+		return "", fmt.Errorf("NonceAt: %v", err)
+	}
+	if mined > tx.Nonce() {
+		return "", ErrNonceConsumed
+	}
+
+	// pay whichever is higher, current network fees or the minimum increment over the stuck tx
+	tipCap, feeCap, err := c.suggestFees(ctx)
+	if err != nil {
+		return "", err
+	}
+	tipCap = maxBig(tipCap, bumpFee(tx.GasTipCap()))
+	feeCap = maxBig(feeCap, bumpFee(tx.GasFeeCap()))
+	feeCap = maxBig(feeCap, tipCap)
+
+	value := tx.Value()
+	if sweep && len(tx.Data()) > 0 {
+		// token sweeps spend their whole native balance on gas, paying more needs another top-up
+		return "", ErrBumpNotSupported
+	}
+	if sweep {
+		gas := new(big.Int).SetUint64(tx.Gas())
+		reserved := new(big.Int).Add(tx.Value(), new(big.Int).Mul(tx.GasFeeCap(), gas))
+		value = reserved.Sub(reserved, new(big.Int).Mul(feeCap, gas))
+		if value.Sign() <= 0 {
+			return "", fmt.Errorf("insufficient balance to bump sweep: max fee %s", feeCap)
+		}
+	}
+
+	bumped := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   tx.ChainId(),
+		Nonce:     tx.Nonce(),
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       tx.Gas(),
+		To:        tx.To(),
+		Value:     value,
+		Data:      tx.Data(),
+	})
+	raw, err := bumped.MarshalBinary()
 	if err != nil {
-		return nil, 0, err
+		return "", fmt.Errorf("marshal tx: %w", err)
 	}
-	gasLimit, err = c.client.EstimateGas(ctx, ethereum.CallMsg{
+	return common.Bytes2Hex(raw), nil
+}
+
+func (c *EvmCtx) estimateGas(ctx context.Context, from, to common.Address, value *big.Int, data []byte) (gasLimit uint64, err error) {
+	return c.client.EstimateGas(ctx, ethereum.CallMsg{
 		From:  from,
 		To:    &to,
-		Data:  nil,
+		Data:  data,
 		Value: value,
 	})
+}
+
+// suggestFees prices a dynamic fee tx. The max fee is the latest base fee times the configured multiplier plus the tip,
+// leaving headroom for the base fee to rise over the next blocks before the tx is included.
+func (c *EvmCtx) suggestFees(ctx context.Context) (tipCap *big.Int, feeCap *big.Int, err error) {
+	tipCap, baseFee, err := c.latestFees(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	feeCap = new(big.Int).Mul(baseFee, new(big.Int).SetUint64(c.wm.maxFeeMultiplier))
+	feeCap.Add(feeCap, tipCap)
+	return tipCap, feeCap, nil
+}
+
+// latestFees returns the suggested tip and the base fee of the latest block
+func (c *EvmCtx) latestFees(ctx context.Context) (tipCap *big.Int, baseFee *big.Int, err error) {
+	tipCap, err = c.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SuggestGasTipCap: %v", err)
+	}
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting latest header: %v", err)
+	}
+	if head.BaseFee == nil {
+		return nil, nil, fmt.Errorf("no base fee in block %s, chain does not support EIP-1559", head.Number)
+	}
+	return tipCap, head.BaseFee, nil
+}
+
+// EstimateGasCost prices one transfer at the latest base fee plus the suggested tip, what a tx included right away pays
+func (c *EvmCtx) EstimateGasCost(ctx context.Context, asset string) (*big.Int, error) {
+	gas := ethTransferGas
+	if _, ok := models.TokenByAsset(c.chain, asset); ok {
+		gas = tokenTransferGas
+	}
+	tipCap, baseFee, err := c.latestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	price := new(big.Int).Add(baseFee, tipCap)
+	return price.Mul(price, new(big.Int).SetUint64(gas)), nil
+}
+
+// AbandonTx releases the nonce of a built tx only if the node does not know it. A broadcast whose response was lost may
+// still have reached the mempool, a new tx reusing its nonce would replace it or get stuck behind it.
+func (c *EvmCtx) AbandonTx(ctx context.Context, rawTx string, fromAddr string) error {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.Hex2Bytes(rawTx)); err != nil {
+		return fmt.Errorf("error unmarshaling tx: %v", err)
+	}
+	from := common.HexToAddress(fromAddr)
+
+	chainID, err := c.client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("ChainID: %v", err)
+	}
+	// signatures are deterministic, the tx signs to the hash it was broadcast with
+	signed, err := c.wm.ks.SignTx(ctx, fromAddr, tx, chainID)
+	if err != nil {
+		return fmt.Errorf("SignTx: %v", err)
+	}
+	_, _, err = c.client.TransactionByHash(ctx, signed.Hash())
+	if err == nil {
+		return &TxBroadcastError{Hash: signed.Hash().Hex()}
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		return fmt.Errorf("TransactionByHash: %v", err)
+	}
+
+	pending, err := c.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return fmt.Errorf("PendingNonceAt: %v", err)
+	}
+	if pending > tx.Nonce() {
+		return fmt.Errorf("%w: pending nonce of %s is %d, nonce %d is kept", ErrNonceConsumed, from.Hex(), pending, tx.Nonce())
+	}
+	return c.releaseNonce(ctx, from, tx.Nonce())
+}
+
+func (c *EvmCtx) SyncNonces(ctx context.Context, fromAddr string, unsent []string) error {
+	if c.wm.nonces == nil {
+		return nil
+	}
+	from := common.HexToAddress(fromAddr)
+	pending, err := c.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return fmt.Errorf("PendingNonceAt: %v", err)
+	}
+	inUse := make([]uint64, 0, len(unsent))
+	for _, raw := range unsent {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(common.Hex2Bytes(raw)); err != nil {
+			return fmt.Errorf("error unmarshaling tx: %v", err)
+		}
+		inUse = append(inUse, tx.Nonce())
+	}
+	return c.wm.nonces.Sync(ctx, c.chain, from.Hex(), pending, inUse)
+}
+
+func (c *EvmCtx) TokenTransfers(ctx context.Context, blockHash string) ([]TokenTransfer, error) {
+	tokens := models.TokensOn(c.chain)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	addresses := make([]common.Address, 0, len(tokens))
+	for _, t := range tokens {
+		addresses = append(addresses, t.Address)
+	}
+
+	hash := common.HexToHash(blockHash)
+	logs, err := c.client.FilterLogs(ctx, ethereum.FilterQuery{
+		BlockHash: &hash,
+		Addresses: addresses,
+		Topics:    [][]common.Hash{{transferTopic}},
+	})
 	if err != nil {
-		return nil, 0, err
+		return nil, fmt.Errorf("FilterLogs: %v", err)
 	}
-	return gasPrice, gasLimit, nil
+
+	transfers := make([]TokenTransfer, 0, len(logs))
+	for _, l := range logs {
+		// Transfer has indexed from and to, the amount is the only data word
+		if l.Removed || len(l.Topics) != 3 || len(l.Data) != 32 {
+			continue
+		}
+		token, ok := models.TokenByAddress(c.chain, l.Address)
+		if !ok {
+			continue
+		}
+		transfers = append(transfers, TokenTransfer{
+			Token:    token,
+			TxHash:   l.TxHash.Hex(),
+			LogIndex: l.Index,
+			From:     common.BytesToAddress(l.Topics[1].Bytes()),
+			To:       common.BytesToAddress(l.Topics[2].Bytes()),
+			Amount:   new(big.Int).SetBytes(l.Data),
+		})
+	}
+	return transfers, nil
+}
+
+// reserveNonce allocates the nonce for a new tx from `from`. Without a nonce store the pending nonce is used,
+// which is only safe while a single tx per wallet is built at a time.
+func (c *EvmCtx) reserveNonce(ctx context.Context, from common.Address) (uint64, error) {
+	pending, err := c.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+	if c.wm.nonces == nil {
+		return pending, nil
+	}
+	nonce, err := c.wm.nonces.Reserve(ctx, c.chain, from.Hex(), pending)
+	if err != nil {
+		return 0, fmt.Errorf("error reserving nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+func (c *EvmCtx) releaseNonce(ctx context.Context, from common.Address, nonce uint64) error {
+	if c.wm.nonces == nil {
+		return nil
+	}
+	if err := c.wm.nonces.Release(ctx, c.chain, from.Hex(), nonce); err != nil {
+		return fmt.Errorf("error releasing nonce %d: %v", nonce, err)
+	}
+	return nil
+}
+
+// bumpFee returns the smallest fee a node accepts to replace a tx paying `fee`
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+replacementBumpPercent))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Quo(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
 }
 
 type HlCtx struct {
-	wm        *ChainProvider
-	info      *hyperliquid.Info
-	hlPrivKey *ecdsa.PrivateKey
-	hlClient  *clients.HttpClient
+	wm       *ChainProvider
+	info     *hyperliquid.Info
+	hlClient *clients.HttpClient
 }
 
 func (c *HlCtx) BroadcastTx(ctx context.Context, rawPayload string, fromAddr string) (hash string, err error) {
@@ -223,10 +675,20 @@ func (c *HlCtx) BroadcastTx(ctx context.Context, rawPayload string, fromAddr str
 	if err := json.Unmarshal([]byte(rawPayload), &action); err != nil {
 		return "", fmt.Errorf("unmarshalling spot send action %v", err)
 	}
+	// the action moves funds of the signer, sends are signed by the hot wallet and sweeps by the deposit address
+	if ok := c.wm.ks.HasKey(ctx, fromAddr); !ok {
+		return "", fmt.Errorf("private key not found for %s", fromAddr)
+	}
+
+	// the nonce is picked when the send is built and stored with it, a rebroadcast signs the same action again and
+	// the exchange executes it at most once. Payloads built before the nonce was stored fall back to the current time.
+	nonce := action.Nonce
+	if nonce == 0 {
+		nonce = uint64(time.Now().UnixMilli())
+	}
 
 	// constructing payload here as there are issues with serializing/deserializing big.Int values
 	// in the future introduce parsing helpers that can deal with these edge cases and use deserialized result directly to make this generalizable
-	nonce := time.Now().UnixMilli()
 	payloadTypes := []hlutil.TypeProperty{
 		{Name: "hyperliquidChain", Type: "string"},
 		{Name: "destination", Type: "string"},
@@ -239,10 +701,10 @@ func (c *HlCtx) BroadcastTx(ctx context.Context, rawPayload string, fromAddr str
 		"destination": strings.ToLower(action.Destination), // must be lowercased https://hyperliquid.gitbook.io/hyperliquid-docs/for-developers/api/signing
 		"token":       action.Token,
 		"amount":      action.Amount,
-		"time":        new(big.Int).SetUint64(uint64(nonce)), // needs to be big.Int, otherwise signing fails
+		"time":        new(big.Int).SetUint64(nonce), // needs to be big.Int, otherwise signing fails
 	}
 
-	sig, err := hlutil.SignUserSignedAction(c.hlPrivKey, actionPayload, payloadTypes, action.PrimaryType, false /* isMainnet */)
+	sig, err := hlutil.SignUserSignedAction(ctx, c.wm.ks, fromAddr, actionPayload, payloadTypes, action.PrimaryType, false /* isMainnet */)
 	if err != nil {
 		return "", fmt.Errorf("SignUserSignedAction: %v", err)
 	}
@@ -263,29 +725,31 @@ func (c *HlCtx) BroadcastTx(ctx context.Context, rawPayload string, fromAddr str
 	if err := json.Unmarshal(resp, &result); err != nil {
 		return "", err
 	}
-	if result.Status != "ok" {
-		return "", fmt.Errorf("api response error %v", result)
+	// a nonce error on a rebroadcast means an earlier broadcast may have been executed with a lost response, the
+	// ref is returned either way and the ledger decides whether the send landed or is rejected after the deadline
+	if result.Status != "ok" && !result.IsNonceError() {
+		return "", fmt.Errorf("api response error %s: %s", result.Status, result.Response)
 	}
 
-	// Hyperliquid API does not return hash
-	return "", nil
+	// Hyperliquid API does not return a hash, the ref of the send is used to find it in the ledger
+	return hlutil.SpotSendRef{
+		User:        fromAddr,
+		Nonce:       nonce,
+		Destination: action.Destination,
+		Amount:      action.Amount,
+		Token:       action.Token,
+	}.String(), nil
 }
 
+// BuildSendTx builds a USDC spot send, `amount` is in USDC base units (6 decimals)
 func (c *HlCtx) BuildSendTx(ctx context.Context, fromAddr string, toAddr string, amount *big.Int) (rawTx string, err error) {
-	// hardcoded conversion of eth wei to usdc amount for POC. Assume 0.01 ETH = 10 USDC for testing
-	divisor := new(big.Int)
-	divisor.SetString("1000000000000000000", 10) // 18 decimals
-	ethValue := new(big.Float).SetInt(amount)
-	ethValue.Quo(ethValue, new(big.Float).SetInt(divisor))
-	usdcValue := new(big.Float).Mul(ethValue, big.NewFloat(1000))
-
 	nonce := time.Now().UnixMilli()
 
 	action := hlutil.SpotSendAction{
 		PrimaryType: "HyperliquidTransaction:SpotSend",
 		Type:        "spotSend",
 		Destination: strings.ToLower(toAddr),
-		Amount:      fmt.Sprintf("%.6f", usdcValue),
+		Amount:      hlutil.FormatAmount(amount, usdcDecimals),
 		Token:       hlutil.USDCTestnet,
 		Nonce:       uint64(nonce),
 	}
@@ -297,6 +761,10 @@ func (c *HlCtx) BuildSendTx(ctx context.Context, fromAddr string, toAddr string,
 }
 
 func (c *HlCtx) BuildSweepTx(ctx context.Context, fromAddr string, toAddr string) (rawTx string, err error) {
+	if ok := c.wm.ks.HasKey(ctx, fromAddr); !ok {
+		return "", fmt.Errorf("private key not found for %s", fromAddr)
+	}
+
 	response, err := c.info.SpotUserState(ctx, fromAddr)
 	if err != nil {
 		return "", fmt.Errorf("error fetching balances %v", err)
@@ -330,8 +798,91 @@ func (c *HlCtx) BuildSweepTx(ctx context.Context, fromAddr string, toAddr string
 	return string(bytes), nil
 }
 
+func (c *HlCtx) BuildTopUpTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, *big.Int, error) {
+	// spot sends are gasless
+	return "", new(big.Int), nil
+}
+
+func (c *HlCtx) BuildTokenSweepTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, error) {
+	return c.BuildSweepTx(ctx, fromAddr, toAddr)
+}
+
+// IsTxConfirmed looks up the spot send identified by the ref `txHash` in the sender's non-funding ledger. Hyperliquid
+// core has one block finality, a send is final once it shows up in the ledger. An accepted send that does not show up
+// within hlConfirmDeadline of its nonce was dropped by the exchange and is rejected.
 func (c *HlCtx) IsTxConfirmed(ctx context.Context, txHash string, minConfirmations uint64) (bool, error) {
-	// hyperliquid core has one block finality with block times of 200ms.
-	// for this POC effectively consider transfer finalized, for correctess we need a way to get core's block number
-	return true, nil
+	if txHash == "" {
+		return false, fmt.Errorf("no spot send recorded to confirm")
+	}
+	ref, err := hlutil.ParseSpotSendRef(txHash)
+	if err != nil {
+		return false, err
+	}
+
+	sentAt := time.UnixMilli(int64(ref.Nonce))
+	resp, err := c.hlClient.Post(ctx, "/info", map[string]any{
+		"type":      "userNonFundingLedgerUpdates",
+		"user":      ref.User,
+		"startTime": sentAt.Add(-time.Minute).UnixMilli(), // tolerate clock skew between us and the exchange
+	})
+	if err != nil {
+		return false, fmt.Errorf("error fetching ledger updates for %s: %w", ref.User, err)
+	}
+	var updates []clients.LedgerUpdate
+	if err := json.Unmarshal(resp, &updates); err != nil {
+		return false, fmt.Errorf("error decoding ledger updates for %s: %w", ref.User, err)
+	}
+	for _, u := range updates {
+		if spotSendLanded(ref, u.Delta) {
+			return true, nil
+		}
+	}
+
+	if time.Since(sentAt) > c.wm.hlConfirmDeadline {
+		return false, fmt.Errorf("%w: spot send %d from %s not in ledger after %s", ErrorRejectedTransaction, ref.Nonce, ref.User, c.wm.hlConfirmDeadline)
+	}
+	return false, nil
+}
+
+// spotSendLanded reports whether the ledger delta is the transfer made by the spot send `ref`. The nonce identifies
+// the send, an earlier send of the same amount to the same destination never confirms a later one. The ledger names
+// tokens without their id.
+func spotSendLanded(ref hlutil.SpotSendRef, delta clients.LedgerDelta) bool {
+	if delta.Type != "spotTransfer" || !strings.EqualFold(delta.User, ref.User) || !strings.EqualFold(delta.Destination, ref.Destination) {
+		return false
+	}
+	if delta.Nonce != ref.Nonce {
+		return false
+	}
+	tokenName, _, _ := strings.Cut(ref.Token, ":")
+	if delta.Token != ref.Token && delta.Token != tokenName {
+		return false
+	}
+	amount, ok := new(big.Rat).SetString(delta.Amount)
+	want, wantOk := new(big.Rat).SetString(ref.Amount)
+	return ok && wantOk && amount.Cmp(want) == 0
+}
+
+func (c *HlCtx) BumpTx(ctx context.Context, rawTx string, fromAddr string, sweep bool) (string, error) {
+	// no mempool, a spot send is either accepted and final or rejected right away
+	return "", ErrBumpNotSupported
+}
+
+func (c *HlCtx) AbandonTx(ctx context.Context, rawTx string, fromAddr string) error {
+	// nonces are timestamps assigned when the send is built, nothing is reserved
+	return nil
+}
+
+func (c *HlCtx) SyncNonces(ctx context.Context, fromAddr string, unsent []string) error {
+	return nil
+}
+
+func (c *HlCtx) EstimateGasCost(ctx context.Context, asset string) (*big.Int, error) {
+	// spot sends are gasless
+	return new(big.Int), nil
+}
+
+func (c *HlCtx) TokenTransfers(ctx context.Context, blockHash string) ([]TokenTransfer, error) {
+	// no blocks to scan, incoming spot transfers are picked up by the LedgerPublisher
+	return nil, nil
 }
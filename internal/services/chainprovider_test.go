@@ -4,19 +4,27 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"unit/agent/internal/clients"
 	"unit/agent/internal/mocks"
 	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
 	hlutil "unit/agent/internal/utils/hyperliquid"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 func newHLClient(ts *httptest.Server) *clients.HttpClient {
@@ -36,9 +44,9 @@ func createPrivateKey(t *testing.T) *ecdsa.PrivateKey {
 }
 
 func TestChainProvider_WithChain_ReturnsCorrectCtx(t *testing.T) {
-	wm := NewChainProvider(&mocks.MockKeyStore{HasKeyResp: true}, map[models.Chain]*ethclient.Client{
+	wm := NewChainProvider(&mocks.MockKeyStore{HasKeyResp: true}, nil, map[models.Chain]*ethclient.Client{
 		models.Ethereum: nil,
-	}, nil, createPrivateKey(t), &clients.HttpClient{})
+	}, nil, &clients.HttpClient{})
 
 	if _, ok := wm.WithChain(models.Hyperliquid).(*HlCtx); !ok {
 		t.Fatalf("expected HlCtx for Hyperliquid")
@@ -80,16 +88,15 @@ func TestEvmCtx_BroadcastTx_BadRaw(t *testing.T) {
 func TestHlCtx_BuildSendTx_JSONShape(t *testing.T) {
 	cp := &ChainProvider{}
 	h := &HlCtx{
-		wm:        cp,
-		info:      nil,
-		hlPrivKey: createPrivateKey(t),
-		hlClient:  &clients.HttpClient{},
+		wm:       cp,
+		info:     nil,
+		hlClient: &clients.HttpClient{},
 	}
 	raw, err := h.BuildSendTx(context.Background(),
 		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		"0xABCDabcdABCDabcdABCDabcdABCDabcdABCDabcd",
-		// 0.02 ETH => 20.0 USDC
-		new(big.Int).Mul(big.NewInt(2), new(big.Int).Exp(big.NewInt(10), big.NewInt(16), nil)))
+		// 20.0 USDC
+		big.NewInt(20_000_000))
 	if err != nil {
 		t.Fatalf("BuildSendTx err: %v", err)
 	}
@@ -119,6 +126,9 @@ func TestHlCtx_BuildSendTx_JSONShape(t *testing.T) {
 }
 
 func TestHlCtx_BroadcastTx_SendsToExchangeAndReturnsHash(t *testing.T) {
+	priv := createPrivateKey(t)
+	signer := crypto.PubkeyToAddress(priv.PublicKey)
+
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var payload struct {
 			Action    map[string]any `json:"action"`
@@ -145,13 +155,11 @@ func TestHlCtx_BroadcastTx_SendsToExchangeAndReturnsHash(t *testing.T) {
 
 	hc := newHLClient(ts)
 
-	priv := createPrivateKey(t)
-
+	ks := &signingKeyStore{MockKeyStore: mocks.MockKeyStore{Addr: signer.Hex(), Key: priv}}
 	h := &HlCtx{
-		wm:        &ChainProvider{},
-		info:      nil,
-		hlPrivKey: priv,
-		hlClient:  hc,
+		wm:       &ChainProvider{ks: ks},
+		info:     nil,
+		hlClient: hc,
 	}
 
 	action := hlutil.SpotSendAction{
@@ -163,20 +171,144 @@ func TestHlCtx_BroadcastTx_SendsToExchangeAndReturnsHash(t *testing.T) {
 	}
 	bs, _ := json.Marshal(action)
 
-	hash, err := h.BroadcastTx(context.Background(), string(bs), "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	hash, err := h.BroadcastTx(context.Background(), string(bs), signer.Hex())
 	if err != nil {
 		t.Fatalf("BroadcastTx err: %v", err)
 	}
-	if hash != "" {
-		t.Fatalf("hash = %s, want empty", hash)
+	ref, err := hlutil.ParseSpotSendRef(hash)
+	if err != nil {
+		t.Fatalf("ParseSpotSendRef(%q): %v", hash, err)
+	}
+	if !strings.EqualFold(ref.User, signer.Hex()) || ref.Destination != action.Destination || ref.Amount != action.Amount || ref.Nonce == 0 {
+		t.Fatalf("ref = %+v", ref)
+	}
+	if len(ks.signedBy) != 1 || ks.signedBy[0] != signer.Hex() {
+		t.Fatalf("typed data signed by %v, want %s", ks.signedBy, signer.Hex())
+	}
+}
+
+func TestHlCtx_BroadcastTx_SignsWithSweptDepositAddress(t *testing.T) {
+	priv := createPrivateKey(t)
+	deposit := crypto.PubkeyToAddress(priv.PublicKey)
+
+	var posted int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+	}))
+	defer ts.Close()
+
+	// only the deposit address key is held, the hot wallet key must not be used for sweeps
+	ks := &signingKeyStore{MockKeyStore: mocks.MockKeyStore{Addr: deposit.Hex(), Key: priv}}
+	h := &HlCtx{
+		wm:       &ChainProvider{ks: ks},
+		hlClient: newHLClient(ts),
+	}
+
+	action := hlutil.SpotSendAction{
+		PrimaryType: "HyperliquidTransaction:SpotSend",
+		Type:        "spotSend",
+		Destination: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		Amount:      "10.000000",
+		Token:       hlutil.USDCTestnet,
+	}
+	bs, _ := json.Marshal(action)
+
+	if _, err := h.BroadcastTx(context.Background(), string(bs), deposit.Hex()); err != nil {
+		t.Fatalf("BroadcastTx err: %v", err)
+	}
+	if len(ks.signedBy) != 1 || ks.signedBy[0] != deposit.Hex() {
+		t.Fatalf("typed data signed by %v, want %s", ks.signedBy, deposit.Hex())
+	}
+
+	_, err := h.BroadcastTx(context.Background(), string(bs), "0xcccccccccccccccccccccccccccccccccccccccc")
+	if err == nil || !strings.Contains(err.Error(), "private key not found") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+	if posted != 1 {
+		t.Fatalf("exchange called %d times, want 1", posted)
+	}
+}
+
+func TestHlCtx_BroadcastTx_RebroadcastReusesBuiltNonce(t *testing.T) {
+	priv := createPrivateKey(t)
+	signer := crypto.PubkeyToAddress(priv.PublicKey)
+
+	var nonces []uint64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Action map[string]any `json:"action"`
+			Nonce  uint64         `json:"nonce"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		nonces = append(nonces, payload.Nonce)
+		if len(nonces) > 1 {
+			// the first broadcast was executed, its response lost
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": "err", "response": "Invalid nonce: duplicate nonce"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+	}))
+	defer ts.Close()
+
+	ks := &signingKeyStore{MockKeyStore: mocks.MockKeyStore{Addr: signer.Hex(), Key: priv}}
+	h := &HlCtx{wm: &ChainProvider{ks: ks}, hlClient: newHLClient(ts)}
+
+	raw, err := h.BuildSendTx(context.Background(), signer.Hex(), "0x2222222222222222222222222222222222222222", big.NewInt(1_000_000))
+	if err != nil {
+		t.Fatalf("BuildSendTx: %v", err)
+	}
+	var action hlutil.SpotSendAction
+	if err := json.Unmarshal([]byte(raw), &action); err != nil {
+		t.Fatalf("unmarshal: %v", err)
 	}
+
+	first, err := h.BroadcastTx(context.Background(), raw, signer.Hex())
+	if err != nil {
+		t.Fatalf("first BroadcastTx: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := h.BroadcastTx(context.Background(), raw, signer.Hex())
+	if err != nil {
+		t.Fatalf("rebroadcast: %v", err)
+	}
+	if first != second {
+		t.Fatalf("rebroadcast ref %q, want %q", second, first)
+	}
+	if len(nonces) != 2 || nonces[0] != action.Nonce || nonces[1] != action.Nonce {
+		t.Fatalf("posted nonces %v, want both %d", nonces, action.Nonce)
+	}
+}
+
+func TestHlCtx_BuildSweepTx_NoKey(t *testing.T) {
+	h := &HlCtx{
+		wm:       &ChainProvider{ks: &mocks.MockKeyStore{HasKeyResp: false}},
+		hlClient: &clients.HttpClient{},
+	}
+	_, err := h.BuildSweepTx(context.Background(), "0xcccccccccccccccccccccccccccccccccccccccc", "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	if err == nil || !strings.Contains(err.Error(), "private key not found") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+}
+
+// signingKeyStore records which addresses typed data was signed with
+type signingKeyStore struct {
+	mocks.MockKeyStore
+	signedBy []string
+}
+
+func (s *signingKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	s.signedBy = append(s.signedBy, address)
+	return s.MockKeyStore.SignTypedData(ctx, address, td)
 }
 
 func TestHlCtx_BroadcastTx_BadJSON(t *testing.T) {
 	h := &HlCtx{
-		wm:        &ChainProvider{},
-		hlPrivKey: createPrivateKey(t),
-		hlClient:  &clients.HttpClient{},
+		wm:       &ChainProvider{},
+		hlClient: &clients.HttpClient{},
 	}
 	_, err := h.BroadcastTx(context.Background(), "{not-json", "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
 	if err == nil || err.Error() == "" {
@@ -184,25 +316,87 @@ func TestHlCtx_BroadcastTx_BadJSON(t *testing.T) {
 	}
 }
 
-func TestHlCtx_IsTxConfirmed_ReturnsTrueA(t *testing.T) {
-	h := &HlCtx{}
-	ok, err := h.IsTxConfirmed(context.Background(), "0xabc", 0)
-	if err != nil {
-		t.Fatalf("IsTxConfirmed err: %v", err)
+func newLedgerServer(t *testing.T, updates *[]clients.LedgerUpdate) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["type"] != "userNonFundingLedgerUpdates" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(*updates)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestHlCtx_IsTxConfirmed_MatchesLedger(t *testing.T) {
+	ref := hlutil.SpotSendRef{
+		User:        "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		Nonce:       uint64(time.Now().UnixMilli()),
+		Destination: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		Amount:      "10.000000",
+		Token:       hlutil.USDCTestnet,
 	}
-	if !ok {
-		t.Fatalf("IsTxConfirmed = false, want true")
+	transfer := clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "10.0", User: ref.User, Destination: ref.Destination, Nonce: ref.Nonce}
+	var updates []clients.LedgerUpdate
+	h := &HlCtx{
+		wm:       &ChainProvider{hlConfirmDeadline: time.Minute},
+		hlClient: newHLClient(newLedgerServer(t, &updates)),
+	}
+
+	// other transfers of the user do not confirm the send
+	other := transfer
+	other.Nonce = ref.Nonce + 1
+	noNonce := transfer
+	noNonce.Nonce = 0
+	wrongAmount := transfer
+	wrongAmount.Amount = "9.0"
+	updates = []clients.LedgerUpdate{{Delta: other}, {Delta: noNonce}, {Delta: wrongAmount}}
+	ok, err := h.IsTxConfirmed(context.Background(), ref.String(), 0)
+	if err != nil || ok {
+		t.Fatalf("IsTxConfirmed = %v, %v, want pending", ok, err)
+	}
+
+	updates = append(updates, clients.LedgerUpdate{Delta: transfer})
+	ok, err = h.IsTxConfirmed(context.Background(), ref.String(), 0)
+	if err != nil || !ok {
+		t.Fatalf("IsTxConfirmed = %v, %v, want confirmed", ok, err)
+	}
+}
+
+func TestHlCtx_IsTxConfirmed_RejectsAfterDeadline(t *testing.T) {
+	ref := hlutil.SpotSendRef{
+		User:        "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		Nonce:       uint64(time.Now().Add(-2 * time.Minute).UnixMilli()),
+		Destination: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		Amount:      "10.000000",
+		Token:       hlutil.USDCTestnet,
+	}
+	var updates []clients.LedgerUpdate
+	h := &HlCtx{
+		wm:       &ChainProvider{hlConfirmDeadline: time.Minute},
+		hlClient: newHLClient(newLedgerServer(t, &updates)),
+	}
+	_, err := h.IsTxConfirmed(context.Background(), ref.String(), 0)
+	if !errors.Is(err, ErrorRejectedTransaction) {
+		t.Fatalf("expected ErrorRejectedTransaction, got %v", err)
+	}
+
+	// a deposit without a recorded send has nothing to confirm
+	if ok, err := h.IsTxConfirmed(context.Background(), "", 0); err == nil || ok {
+		t.Fatalf("IsTxConfirmed(\"\") = %v, %v, want an error", ok, err)
 	}
 }
 
 func TestHlCtx_BuildSendTx(t *testing.T) {
-	h := &HlCtx{hlPrivKey: createPrivateKey(t), hlClient: &clients.HttpClient{}}
+	h := &HlCtx{hlClient: &clients.HttpClient{}}
 
-	oneEth := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	// amounts are USDC base units, conversion from the deposited asset happens in the state machine
 	raw, err := h.BuildSendTx(context.Background(),
 		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		"0xBBBBbbbbBBBBbbbbBBBBbbbbBBBBbbbbBBBBbbbb",
-		oneEth,
+		big.NewInt(1_000_000_000),
 	)
 	if err != nil {
 		t.Fatalf("BuildSendTx err: %v", err)
@@ -222,3 +416,590 @@ func TestHlCtx_BuildSendTx(t *testing.T) {
 		t.Fatalf("token empty")
 	}
 }
+
+// fakeEvmNode answers the JSON-RPC calls made while building txs
+type fakeEvmNode struct {
+	chainID uint64
+	nonce   uint64 // pending nonce
+	mined   uint64 // latest nonce
+	balance *big.Int
+	// per address balances overriding balance
+	balances map[common.Address]*big.Int
+	// balanceOf result of every token
+	tokenBalance *big.Int
+	baseFee      *big.Int // nil for a pre-London head
+	tip          *big.Int
+	gas          uint64
+	logs         []map[string]any
+	filter       map[string]any // last eth_getLogs filter
+	known        map[common.Hash]*types.Transaction
+}
+
+func (n *fakeEvmNode) serveRPC(w http.ResponseWriter, r *http.Request) {
+	var req rpcReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	res := rpcResp{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "eth_chainId":
+		res.Result = hexutil.EncodeUint64(n.chainID)
+	case "eth_getTransactionCount":
+		if len(req.Params) > 1 && req.Params[1] == "latest" {
+			res.Result = hexutil.EncodeUint64(n.mined)
+		} else {
+			res.Result = hexutil.EncodeUint64(n.nonce)
+		}
+	case "eth_getTransactionByHash":
+		// a null result is reported as not found
+		res.Result = json.RawMessage("null")
+		if hash, ok := req.Params[0].(string); ok {
+			if tx, ok := n.known[common.HexToHash(hash)]; ok {
+				res.Result = tx
+			}
+		}
+	case "eth_getBalance":
+		balance := n.balance
+		if addr, ok := req.Params[0].(string); ok {
+			if b, ok := n.balances[common.HexToAddress(addr)]; ok {
+				balance = b
+			}
+		}
+		res.Result = hexutil.EncodeBig(balance)
+	case "eth_call":
+		res.Result = hexutil.Encode(common.BigToHash(n.tokenBalance).Bytes())
+	case "eth_maxPriorityFeePerGas":
+		res.Result = hexutil.EncodeBig(n.tip)
+	case "eth_estimateGas":
+		res.Result = hexutil.EncodeUint64(n.gas)
+	case "eth_getLogs":
+		n.filter, _ = req.Params[0].(map[string]any)
+		logs := n.logs
+		if logs == nil {
+			logs = []map[string]any{}
+		}
+		res.Result = logs
+	case "eth_getBlockByNumber":
+		head := map[string]any{
+			"number":           "0x10",
+			"parentHash":       "0x" + strings.Repeat("0", 64),
+			"sha3Uncles":       "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+			"miner":            "0x0000000000000000000000000000000000000000",
+			"stateRoot":        "0x" + strings.Repeat("0", 64),
+			"transactionsRoot": "0x" + strings.Repeat("0", 64),
+			"receiptsRoot":     "0x" + strings.Repeat("0", 64),
+			"logsBloom":        "0x" + strings.Repeat("0", 512),
+			"difficulty":       "0x0",
+			"gasLimit":         "0x1c9c380",
+			"gasUsed":          "0x0",
+			"timestamp":        "0x0",
+			"extraData":        "0x",
+			"nonce":            "0x0000000000000000",
+		}
+		if n.baseFee != nil {
+			head["baseFeePerGas"] = hexutil.EncodeBig(n.baseFee)
+		}
+		res.Result = head
+	default:
+		res.Error = &rpcError{Code: -32601, Message: "method not found"}
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func newEvmCtxForTest(t *testing.T, node *fakeEvmNode) *EvmCtx {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(node.serveRPC))
+	t.Cleanup(srv.Close)
+	client, err := ethclient.Dial(srv.URL)
+	if err != nil {
+		t.Fatalf("ethclient.Dial: %v", err)
+	}
+	ns, err := stores.NewLocalNonceStore(filepath.Join(t.TempDir(), "nonces.db"))
+	if err != nil {
+		t.Fatalf("NewLocalNonceStore: %v", err)
+	}
+	t.Cleanup(func() { _ = ns.Close() })
+	cp := NewChainProvider(&mocks.MockKeyStore{HasKeyResp: true}, ns, map[models.Chain]*ethclient.Client{models.Ethereum: client}, nil, nil)
+	return cp.WithChain(models.Ethereum).(*EvmCtx)
+}
+
+func decodeTx(t *testing.T, raw string) *types.Transaction {
+	t.Helper()
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.Hex2Bytes(raw)); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	return tx
+}
+
+func TestEvmCtx_BuildSendTx_DynamicFee(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 11155111,
+		nonce:   7,
+		balance: big.NewInt(1_000_000_000_000_000_000),
+		baseFee: big.NewInt(10_000_000_000),
+		tip:     big.NewInt(1_000_000_000),
+		gas:     21000,
+	}
+	c := newEvmCtxForTest(t, node)
+
+	raw, err := c.BuildSendTx(context.Background(),
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		big.NewInt(12345))
+	if err != nil {
+		t.Fatalf("BuildSendTx: %v", err)
+	}
+
+	tx := decodeTx(t, raw)
+	if tx.Type() != types.DynamicFeeTxType {
+		t.Fatalf("tx type = %d, want dynamic fee", tx.Type())
+	}
+	if tx.ChainId().Uint64() != node.chainID || tx.Nonce() != 7 || tx.Gas() != 21000 || tx.Value().Int64() != 12345 {
+		t.Fatalf("unexpected tx chain=%s nonce=%d gas=%d value=%s", tx.ChainId(), tx.Nonce(), tx.Gas(), tx.Value())
+	}
+	if tx.GasTipCap().Cmp(node.tip) != 0 {
+		t.Fatalf("tip cap = %s, want %s", tx.GasTipCap(), node.tip)
+	}
+	// default multiplier 2: 2 * 10 gwei + 1 gwei
+	if tx.GasFeeCap().Cmp(big.NewInt(21_000_000_000)) != 0 {
+		t.Fatalf("fee cap = %s, want 21000000000", tx.GasFeeCap())
+	}
+}
+
+func TestEvmCtx_BuildSendTx_MaxFeeMultiplier(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 1,
+		balance: big.NewInt(1_000_000_000_000_000_000),
+		baseFee: big.NewInt(100),
+		tip:     big.NewInt(1),
+		gas:     21000,
+	}
+	c := newEvmCtxForTest(t, node)
+	c.wm.SetMaxFeeMultiplier(3)
+
+	raw, err := c.BuildSendTx(context.Background(),
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		big.NewInt(1))
+	if err != nil {
+		t.Fatalf("BuildSendTx: %v", err)
+	}
+	if got := decodeTx(t, raw).GasFeeCap(); got.Cmp(big.NewInt(301)) != 0 {
+		t.Fatalf("fee cap = %s, want 301", got)
+	}
+}
+
+func TestEvmCtx_BuildSweepTx_DrainsBalance(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 11155111,
+		nonce:   0,
+		balance: big.NewInt(10_000_000_000_000_000), // 0.01 ETH
+		baseFee: big.NewInt(10_000_000_000),
+		tip:     big.NewInt(1_000_000_000),
+	}
+	c := newEvmCtxForTest(t, node)
+
+	raw, err := c.BuildSweepTx(context.Background(),
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	if err != nil {
+		t.Fatalf("BuildSweepTx: %v", err)
+	}
+
+	tx := decodeTx(t, raw)
+	if tx.Type() != types.DynamicFeeTxType {
+		t.Fatalf("tx type = %d, want dynamic fee", tx.Type())
+	}
+	// value + maxFeePerGas * gasLimit must equal the balance exactly
+	maxCost := new(big.Int).Mul(tx.GasFeeCap(), new(big.Int).SetUint64(tx.Gas()))
+	if total := new(big.Int).Add(tx.Value(), maxCost); total.Cmp(node.balance) != 0 {
+		t.Fatalf("value %s + max gas cost %s = %s, want balance %s", tx.Value(), maxCost, total, node.balance)
+	}
+}
+
+func TestEvmCtx_BuildSweepTx_InsufficientBalance(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 1,
+		balance: big.NewInt(21000 * 21), // exactly max gas cost at fee cap 21
+		baseFee: big.NewInt(10),
+		tip:     big.NewInt(1),
+	}
+	c := newEvmCtxForTest(t, node)
+
+	_, err := c.BuildSweepTx(context.Background(),
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	if err == nil || !strings.Contains(err.Error(), "insufficient balance") {
+		t.Fatalf("expected insufficient balance error, got %v", err)
+	}
+}
+
+func TestEvmCtx_BuildSweepTx_NoBaseFee(t *testing.T) {
+	node := &fakeEvmNode{chainID: 1, balance: big.NewInt(1_000_000_000_000_000), tip: big.NewInt(1)}
+	c := newEvmCtxForTest(t, node)
+
+	_, err := c.BuildSweepTx(context.Background(),
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	if err == nil || !strings.Contains(err.Error(), "EIP-1559") {
+		t.Fatalf("expected missing base fee error, got %v", err)
+	}
+}
+
+func buildRawDynamicTx(t *testing.T, nonce uint64, tip, feeCap, value int64) string {
+	t.Helper()
+	to := common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	raw, err := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(11155111),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(tip),
+		GasFeeCap: big.NewInt(feeCap),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(value),
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	return common.Bytes2Hex(raw)
+}
+
+func TestEvmCtx_BumpTx_MinimumIncrement(t *testing.T) {
+	// network fees dropped below the stuck tx, the replacement still has to pay the minimum increment
+	node := &fakeEvmNode{chainID: 11155111, nonce: 3, mined: 3, baseFee: big.NewInt(10), tip: big.NewInt(1)}
+	c := newEvmCtxForTest(t, node)
+
+	raw := buildRawDynamicTx(t, 3, 1000, 5000, 12345)
+	bumped, err := c.BumpTx(context.Background(), raw, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false)
+	if err != nil {
+		t.Fatalf("BumpTx: %v", err)
+	}
+
+	tx := decodeTx(t, bumped)
+	if tx.Nonce() != 3 || tx.Value().Int64() != 12345 || tx.Gas() != 21000 {
+		t.Fatalf("unexpected replacement nonce=%d value=%s gas=%d", tx.Nonce(), tx.Value(), tx.Gas())
+	}
+	if tx.GasTipCap().Int64() != 1100 || tx.GasFeeCap().Int64() != 5500 {
+		t.Fatalf("tip=%s fee=%s, want 1100 and 5500", tx.GasTipCap(), tx.GasFeeCap())
+	}
+}
+
+func TestEvmCtx_BumpTx_FollowsNetworkFees(t *testing.T) {
+	node := &fakeEvmNode{chainID: 11155111, baseFee: big.NewInt(10_000), tip: big.NewInt(2_000)}
+	c := newEvmCtxForTest(t, node)
+
+	raw := buildRawDynamicTx(t, 0, 1000, 5000, 1)
+	bumped, err := c.BumpTx(context.Background(), raw, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false)
+	if err != nil {
+		t.Fatalf("BumpTx: %v", err)
+	}
+	tx := decodeTx(t, bumped)
+	if tx.GasTipCap().Int64() != 2_000 || tx.GasFeeCap().Int64() != 22_000 {
+		t.Fatalf("tip=%s fee=%s, want 2000 and 22000", tx.GasTipCap(), tx.GasFeeCap())
+	}
+}
+
+func TestEvmCtx_BumpTx_SweepKeepsTotal(t *testing.T) {
+	node := &fakeEvmNode{chainID: 11155111, baseFee: big.NewInt(10), tip: big.NewInt(1)}
+	c := newEvmCtxForTest(t, node)
+
+	raw := buildRawDynamicTx(t, 0, 1000, 5000, 1_000_000_000)
+	bumped, err := c.BumpTx(context.Background(), raw, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", true)
+	if err != nil {
+		t.Fatalf("BumpTx: %v", err)
+	}
+	tx := decodeTx(t, bumped)
+	total := new(big.Int).Add(tx.Value(), new(big.Int).Mul(tx.GasFeeCap(), big.NewInt(21000)))
+	want := big.NewInt(1_000_000_000 + 5000*21000)
+	if total.Cmp(want) != 0 {
+		t.Fatalf("value + max gas cost = %s, want %s", total, want)
+	}
+}
+
+func TestEvmCtx_BumpTx_NonceConsumed(t *testing.T) {
+	node := &fakeEvmNode{chainID: 11155111, nonce: 4, mined: 4, baseFee: big.NewInt(10), tip: big.NewInt(1)}
+	c := newEvmCtxForTest(t, node)
+
+	raw := buildRawDynamicTx(t, 3, 1000, 5000, 1)
+	if _, err := c.BumpTx(context.Background(), raw, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false); !errors.Is(err, ErrNonceConsumed) {
+		t.Fatalf("expected ErrNonceConsumed, got %v", err)
+	}
+}
+
+func TestEvmCtx_BuildSendTx_ReservesDistinctNonces(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 11155111,
+		nonce:   7,
+		mined:   7,
+		balance: big.NewInt(1_000_000_000_000_000_000),
+		baseFee: big.NewInt(10),
+		tip:     big.NewInt(1),
+		gas:     21000,
+	}
+	c := newEvmCtxForTest(t, node)
+	ctx := context.Background()
+	from := "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	to := "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	build := func() string {
+		raw, err := c.BuildSendTx(ctx, from, to, big.NewInt(1))
+		if err != nil {
+			t.Fatalf("BuildSendTx: %v", err)
+		}
+		return raw
+	}
+
+	// nothing broadcast in between, the pending nonce stays at 7
+	first, second := build(), build()
+	if n1, n2 := decodeTx(t, first).Nonce(), decodeTx(t, second).Nonce(); n1 != 7 || n2 != 8 {
+		t.Fatalf("nonces = %d, %d, want 7, 8", n1, n2)
+	}
+
+	if err := c.AbandonTx(ctx, first, from); err != nil {
+		t.Fatalf("AbandonTx: %v", err)
+	}
+	if n := decodeTx(t, build()).Nonce(); n != 7 {
+		t.Fatalf("nonce after abandon = %d, want 7", n)
+	}
+	if n := decodeTx(t, build()).Nonce(); n != 9 {
+		t.Fatalf("nonce = %d, want 9", n)
+	}
+
+	// restart: only the tx with nonce 8 is still waiting to be broadcast, 7 and 9 were lost
+	if err := c.SyncNonces(ctx, from, []string{second}); err != nil {
+		t.Fatalf("SyncNonces: %v", err)
+	}
+	if n := decodeTx(t, build()).Nonce(); n != 7 {
+		t.Fatalf("nonce after sync = %d, want 7", n)
+	}
+	if n := decodeTx(t, build()).Nonce(); n != 9 {
+		t.Fatalf("nonce after sync = %d, want 9", n)
+	}
+}
+
+func TestEvmCtx_AbandonTx_KeepsNonceOfKnownTx(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 11155111,
+		nonce:   7,
+		mined:   7,
+		balance: big.NewInt(1_000_000_000_000_000_000),
+		baseFee: big.NewInt(10),
+		tip:     big.NewInt(1),
+		gas:     21000,
+	}
+	c := newEvmCtxForTest(t, node)
+	ctx := context.Background()
+	from := "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+	raw, err := c.BuildSendTx(ctx, from, "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", big.NewInt(1))
+	if err != nil {
+		t.Fatalf("BuildSendTx: %v", err)
+	}
+	tx := decodeTx(t, raw)
+
+	// the broadcast reached the mempool but its response was lost
+	node.known = map[common.Hash]*types.Transaction{tx.Hash(): tx}
+	var broadcast *TxBroadcastError
+	if err := c.AbandonTx(ctx, raw, from); !errors.As(err, &broadcast) || broadcast.Hash != tx.Hash().Hex() {
+		t.Fatalf("AbandonTx err = %v, want TxBroadcastError for %s", err, tx.Hash().Hex())
+	}
+
+	// the node dropped the tx after mining another one with its nonce
+	node.known = nil
+	node.nonce = 8
+	if err := c.AbandonTx(ctx, raw, from); !errors.Is(err, ErrNonceConsumed) {
+		t.Fatalf("AbandonTx err = %v, want ErrNonceConsumed", err)
+	}
+	if n := decodeTx(t, mustBuild(t, c, from)).Nonce(); n != 8 {
+		t.Fatalf("nonce after kept abandon = %d, want 8", n)
+	}
+}
+
+func mustBuild(t *testing.T, c *EvmCtx, from string) string {
+	t.Helper()
+	raw, err := c.BuildSendTx(context.Background(), from, "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", big.NewInt(1))
+	if err != nil {
+		t.Fatalf("BuildSendTx: %v", err)
+	}
+	return raw
+}
+
+func transferLog(token common.Address, to common.Address, amount int64, index uint64) map[string]any {
+	return map[string]any{
+		"address": token.Hex(),
+		"topics": []string{
+			transferTopic.Hex(),
+			common.BytesToHash(common.HexToAddress("0x9999999999999999999999999999999999999999").Bytes()).Hex(),
+			common.BytesToHash(to.Bytes()).Hex(),
+		},
+		"data":             hexutil.Encode(common.BigToHash(big.NewInt(amount)).Bytes()),
+		"blockNumber":      "0x10",
+		"blockHash":        "0x" + strings.Repeat("ab", 32),
+		"transactionHash":  "0x" + strings.Repeat("cd", 32),
+		"transactionIndex": "0x0",
+		"logIndex":         hexutil.EncodeUint64(index),
+		"removed":          false,
+	}
+}
+
+func TestEvmCtx_TokenTransfers_DecodesRegisteredTokens(t *testing.T) {
+	usdc := models.TokensOn(models.Ethereum)[0]
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	unknown := transferLog(common.HexToAddress("0x7777777777777777777777777777777777777777"), to, 5, 2)
+	node := &fakeEvmNode{logs: []map[string]any{transferLog(usdc.Address, to, 25_000_000, 1), unknown}}
+	c := newEvmCtxForTest(t, node)
+
+	blockHash := "0x" + strings.Repeat("ab", 32)
+	transfers, err := c.TokenTransfers(context.Background(), blockHash)
+	if err != nil {
+		t.Fatalf("TokenTransfers: %v", err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("expected 1 transfer, got %d", len(transfers))
+	}
+	got := transfers[0]
+	if got.Token.Asset != models.AssetUsdc || got.To != to || got.Amount.Int64() != 25_000_000 || got.LogIndex != 1 {
+		t.Fatalf("unexpected transfer %+v", got)
+	}
+	if got.TxHash != "0x"+strings.Repeat("cd", 32) {
+		t.Fatalf("tx hash = %s", got.TxHash)
+	}
+
+	// filtered by block hash, token contracts and the Transfer topic
+	if node.filter["blockHash"] != blockHash {
+		t.Fatalf("filter blockHash = %v", node.filter["blockHash"])
+	}
+	if addrs, _ := node.filter["address"].([]any); len(addrs) != 1 || !strings.EqualFold(addrs[0].(string), usdc.Address.Hex()) {
+		t.Fatalf("filter address = %v", node.filter["address"])
+	}
+}
+
+func TestEvmCtx_BuildTopUpTx_FundsExactSweepGas(t *testing.T) {
+	hot := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	deposit := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	node := &fakeEvmNode{
+		chainID:      11155111,
+		balance:      big.NewInt(1_000_000_000_000_000_000),
+		balances:     map[common.Address]*big.Int{deposit: big.NewInt(1_000)},
+		tokenBalance: big.NewInt(25_000_000),
+		baseFee:      big.NewInt(10_000_000_000),
+		tip:          big.NewInt(1_000_000_000),
+		gas:          60000,
+	}
+	c := newEvmCtxForTest(t, node)
+
+	raw, amount, err := c.BuildTopUpTx(context.Background(), hot.Hex(), deposit.Hex(), models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("BuildTopUpTx: %v", err)
+	}
+	// fee cap 21 gwei * 60000 gas, minus what the deposit address already holds
+	want := new(big.Int).Sub(big.NewInt(21_000_000_000*60000), big.NewInt(1_000))
+	if amount.Cmp(want) != 0 {
+		t.Fatalf("top-up amount = %s, want %s", amount, want)
+	}
+	tx := decodeTx(t, raw)
+	if *tx.To() != deposit || tx.Value().Cmp(want) != 0 {
+		t.Fatalf("unexpected top-up to=%s value=%s", tx.To().Hex(), tx.Value())
+	}
+}
+
+func TestEvmCtx_BuildTopUpTx_AlreadyFunded(t *testing.T) {
+	deposit := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	node := &fakeEvmNode{
+		chainID:      1,
+		balance:      big.NewInt(1_000_000_000_000_000_000),
+		tokenBalance: big.NewInt(1),
+		baseFee:      big.NewInt(10),
+		tip:          big.NewInt(1),
+		gas:          60000,
+	}
+	c := newEvmCtxForTest(t, node)
+
+	raw, amount, err := c.BuildTopUpTx(context.Background(), "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", deposit.Hex(), models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("BuildTopUpTx: %v", err)
+	}
+	if raw != "" || amount.Sign() != 0 {
+		t.Fatalf("expected no top-up, got raw=%q amount=%s", raw, amount)
+	}
+}
+
+func TestEvmCtx_BuildTokenSweepTx_TransfersFullBalance(t *testing.T) {
+	hot := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	deposit := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	node := &fakeEvmNode{
+		chainID:      11155111,
+		balance:      big.NewInt(21 * 60000), // exactly a top-up at fee cap 21
+		tokenBalance: big.NewInt(25_000_000),
+		baseFee:      big.NewInt(10),
+		tip:          big.NewInt(1),
+		gas:          60000,
+	}
+	c := newEvmCtxForTest(t, node)
+
+	raw, err := c.BuildTokenSweepTx(context.Background(), deposit.Hex(), hot.Hex(), models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("BuildTokenSweepTx: %v", err)
+	}
+	tx := decodeTx(t, raw)
+	usdc, _ := models.TokenByAsset(models.Ethereum, models.AssetUsdc)
+	if *tx.To() != usdc.Address || tx.Value().Sign() != 0 || tx.Gas() != 60000 {
+		t.Fatalf("unexpected sweep to=%s value=%s gas=%d", tx.To().Hex(), tx.Value(), tx.Gas())
+	}
+	if tx.GasFeeCap().Int64() != 21 {
+		t.Fatalf("fee cap = %s, want 21", tx.GasFeeCap())
+	}
+
+	data := tx.Data()
+	if len(data) != 68 || !strings.EqualFold(hexutil.Encode(data[:4]), "0xa9059cbb") {
+		t.Fatalf("not an ERC-20 transfer: %x", data)
+	}
+	if common.BytesToAddress(data[4:36]) != hot || new(big.Int).SetBytes(data[36:]).Int64() != 25_000_000 {
+		t.Fatalf("unexpected transfer calldata %x", data)
+	}
+}
+
+func TestEvmCtx_BuildTokenSweepTx_InsufficientGas(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID:      1,
+		balance:      big.NewInt(21*60000 - 1),
+		tokenBalance: big.NewInt(25_000_000),
+		baseFee:      big.NewInt(10),
+		tip:          big.NewInt(1),
+		gas:          60000,
+	}
+	c := newEvmCtxForTest(t, node)
+
+	_, err := c.BuildTokenSweepTx(context.Background(),
+		"0x1111111111111111111111111111111111111111",
+		"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", models.AssetUsdc)
+	if !errors.Is(err, ErrInsufficientGas) {
+		t.Fatalf("expected ErrInsufficientGas, got %v", err)
+	}
+}
+
+func TestEvmCtx_EstimateGasCost(t *testing.T) {
+	node := &fakeEvmNode{
+		chainID: 11155111,
+		baseFee: big.NewInt(10),
+		tip:     big.NewInt(2),
+	}
+	c := newEvmCtxForTest(t, node)
+
+	// latest base fee plus tip, not the max fee a tx is willing to pay
+	got, err := c.EstimateGasCost(context.Background(), models.AssetEth)
+	if err != nil {
+		t.Fatalf("EstimateGasCost: %v", err)
+	}
+	if got.Cmp(big.NewInt(12*21000)) != 0 {
+		t.Fatalf("eth transfer cost = %s, want %d", got, 12*21000)
+	}
+
+	got, err = c.EstimateGasCost(context.Background(), models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("EstimateGasCost: %v", err)
+	}
+	if got.Cmp(big.NewInt(12*65000)) != 0 {
+		t.Fatalf("token transfer cost = %s, want %d", got, 12*65000)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"unit/agent/internal/models"
+)
+
+var ErrFeeExceedsAmount = errors.New("fees exceed deposited amount")
+
+// FeeModel computes what a deposit is credited. The route fee and, if the route recovers gas, the estimated gas of the
+// credit and of the sweep are deducted from the gross amount, the rest is converted into the payout asset.
+type FeeModel struct {
+	provider  IChainProvider
+	oracle    PriceOracle
+	schedules map[models.Route]models.FeeSchedule
+	fallback  models.FeeSchedule // routes without their own schedule
+}
+
+func NewFeeModel(provider IChainProvider, oracle PriceOracle, fallback models.FeeSchedule) *FeeModel {
+	return &FeeModel{
+		provider:  provider,
+		oracle:    oracle,
+		schedules: make(map[models.Route]models.FeeSchedule),
+		fallback:  fallback,
+	}
+}
+
+// SetSchedule configures the fee charged on `route`. Must be called before the model is used.
+func (f *FeeModel) SetSchedule(route models.Route, schedule models.FeeSchedule) {
+	f.schedules[route] = schedule
+}
+
+func (f *FeeModel) schedule(route models.Route) models.FeeSchedule {
+	if s, ok := f.schedules[route]; ok {
+		return s
+	}
+	return f.fallback
+}
+
+// Credit computes the credit of `gross` base units deposited on `route`. The quote is nil if no price was needed.
+func (f *FeeModel) Credit(ctx context.Context, route models.Route, gross *big.Int) (*models.FeeBreakdown, *models.Quote, error) {
+	asset := route.Asset
+	if asset == "" {
+		asset = models.AssetEth
+	}
+	payout := payoutAsset(route.DstChain)
+	schedule := f.schedule(route)
+
+	// gas is paid in ETH
+	gasWei := new(big.Int)
+	if schedule.RecoverGas {
+		var err error
+		if gasWei, err = f.gasCost(ctx, route, asset, payout); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var quote *models.Quote
+	if asset != payout || (gasWei.Sign() > 0 && asset != models.AssetEth) {
+		// USDC is always the quote asset
+		base := asset
+		if base == models.AssetUsdc {
+			base = payout
+		}
+		if base == models.AssetUsdc {
+			base = models.AssetEth
+		}
+		q, err := f.oracle.Quote(ctx, base, models.AssetUsdc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error quoting %s: %w", base, err)
+		}
+		quote = q
+	}
+
+	gas := new(big.Int)
+	if gasWei.Sign() > 0 {
+		var err error
+		if gas, err = convertAmount(gasWei, models.AssetEth, asset, quote); err != nil {
+			return nil, nil, err
+		}
+	}
+	fee := schedule.Fee(gross)
+	net := new(big.Int).Sub(gross, fee)
+	net.Sub(net, gas)
+	if net.Sign() <= 0 {
+		return nil, nil, fmt.Errorf("%w: gross %s fee %s gas %s", ErrFeeExceedsAmount, gross, fee, gas)
+	}
+	amount, err := convertAmount(net, asset, payout, quote)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.FeeBreakdown{
+		Asset:       asset,
+		Gross:       new(big.Int).Set(gross),
+		Fee:         fee,
+		Gas:         gas,
+		Net:         net,
+		PayoutAsset: payout,
+		Payout:      amount,
+	}, quote, nil
+}
+
+// gasCost estimates the native cost of crediting a deposit of `asset` on the destination chain and sweeping it on the
+// source chain. Token sweeps are preceded by a native top-up of the deposit address.
+func (f *FeeModel) gasCost(ctx context.Context, route models.Route, asset, payout string) (*big.Int, error) {
+	credit, err := f.provider.WithChain(route.DstChain).EstimateGasCost(ctx, payout)
+	if err != nil {
+		return nil, fmt.Errorf("error estimating credit gas: %w", err)
+	}
+	src := f.provider.WithChain(route.SrcChain)
+	sweep, err := src.EstimateGasCost(ctx, asset)
+	if err != nil {
+		return nil, fmt.Errorf("error estimating sweep gas: %w", err)
+	}
+	total := new(big.Int).Add(credit, sweep)
+	if _, ok := models.TokenByAsset(route.SrcChain, asset); ok {
+		topUp, err := src.EstimateGasCost(ctx, models.AssetEth)
+		if err != nil {
+			return nil, fmt.Errorf("error estimating top-up gas: %w", err)
+		}
+		total.Add(total, topUp)
+	}
+	return total, nil
+}
+
+// credit computes what the deposit is credited in the payout asset, the fee breakdown and the quote are kept on the deposit
+func (sm *StateMachine) credit(ctx context.Context, st *models.DepositState) (*big.Int, error) {
+	route := models.Route{SrcChain: st.SrcChain, DstChain: st.DstChain, Asset: depositAsset(st)}
+	fees, quote, err := sm.fees.Credit(ctx, route, creditedAmount(st))
+	if err != nil {
+		return nil, err
+	}
+	st.Quote = quote
+	st.Fees = fees
+	return fees.Payout, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"unit/agent/internal/models"
+)
+
+func newFeeModelForTest(t *testing.T, gas map[models.Chain]*big.Int, schedule models.FeeSchedule) *FeeModel {
+	t.Helper()
+	provider := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{
+		models.Ethereum:    {gasCost: gas[models.Ethereum]},
+		models.Hyperliquid: {gasCost: gas[models.Hyperliquid]},
+	}}
+	oracle, err := NewStaticOracle(map[string]string{"eth/usdc": "1000"})
+	if err != nil {
+		t.Fatalf("NewStaticOracle: %v", err)
+	}
+	return NewFeeModel(provider, oracle, schedule)
+}
+
+func TestFeeModel_Credit_ByDestinationChain(t *testing.T) {
+	f := newFeeModelForTest(t, nil, models.FeeSchedule{})
+	ctx := context.Background()
+
+	// same asset needs no quote
+	fees, quote, err := f.Credit(ctx, models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid, Asset: models.AssetUsdc}, big.NewInt(5_000_000))
+	if err != nil || quote != nil || fees.Payout.Cmp(big.NewInt(5_000_000)) != 0 || fees.PayoutAsset != models.AssetUsdc {
+		t.Fatalf("usdc to hyperliquid = %+v, quote %+v, err %v", fees, quote, err)
+	}
+
+	fees, quote, err = f.Credit(ctx, models.Route{SrcChain: models.Hyperliquid, DstChain: models.Ethereum, Asset: models.AssetUsdc}, big.NewInt(1_000_000))
+	if err != nil || quote == nil || fees.Payout.Cmp(big.NewInt(1_000_000_000_000_000)) != 0 || fees.PayoutAsset != models.AssetEth {
+		t.Fatalf("usdc to ethereum = %+v, quote %+v, err %v", fees, quote, err)
+	}
+
+	// untracked asset is eth
+	fees, _, err = f.Credit(ctx, models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid}, big.NewInt(1_000_000_000_000_000))
+	if err != nil || fees.Asset != models.AssetEth || fees.Payout.Cmp(big.NewInt(1_000_000)) != 0 {
+		t.Fatalf("untracked to hyperliquid = %+v, err %v", fees, err)
+	}
+}
+
+func TestFeeModel_Credit_DeductsFeeAndGas(t *testing.T) {
+	// 0.0001 ETH to credit on Ethereum, 0.00005 ETH per transfer on the source chain
+	gas := map[models.Chain]*big.Int{
+		models.Ethereum: big.NewInt(100_000_000_000_000),
+	}
+	f := newFeeModelForTest(t, gas, models.FeeSchedule{})
+	f.SetSchedule(models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid, Asset: models.AssetUsdc},
+		models.FeeSchedule{Flat: big.NewInt(100_000), Bps: 10, RecoverGas: true})
+
+	// token sweep on Ethereum is a top-up plus the token transfer, 0.0002 ETH = 0.2 USDC
+	fees, quote, err := f.Credit(context.Background(), models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid, Asset: models.AssetUsdc}, big.NewInt(100_000_000))
+	if err != nil {
+		t.Fatalf("Credit: %v", err)
+	}
+	if quote == nil {
+		t.Fatalf("gas priced in ETH needs a quote")
+	}
+	// fee 0.1 USDC flat + 10 bps of 100 USDC
+	if fees.Fee.Cmp(big.NewInt(200_000)) != 0 || fees.Gas.Cmp(big.NewInt(200_000)) != 0 {
+		t.Fatalf("fee = %s gas = %s, want 200000 and 200000", fees.Fee, fees.Gas)
+	}
+	if fees.Gross.Cmp(big.NewInt(100_000_000)) != 0 || fees.Net.Cmp(big.NewInt(99_600_000)) != 0 || fees.Payout.Cmp(fees.Net) != 0 {
+		t.Fatalf("breakdown = %+v", fees)
+	}
+
+	// routes without a schedule use the fallback, here no fee and no gas recovery
+	fees, _, err = f.Credit(context.Background(), models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid, Asset: models.AssetEth}, big.NewInt(1_000))
+	if err != nil || fees.Fee.Sign() != 0 || fees.Gas.Sign() != 0 {
+		t.Fatalf("fallback breakdown = %+v, err %v", fees, err)
+	}
+
+	_, _, err = f.Credit(context.Background(), models.Route{SrcChain: models.Ethereum, DstChain: models.Hyperliquid, Asset: models.AssetUsdc}, big.NewInt(300_000))
+	if !errors.Is(err, ErrFeeExceedsAmount) {
+		t.Fatalf("expected ErrFeeExceedsAmount, got %v", err)
+	}
+}
+
+func TestCredit_StoresBreakdownAndQuote(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	oracle, _ := NewStaticOracle(map[string]string{"eth/usdc": "2500.5"})
+	sm.fees = NewFeeModel(sm.provider, oracle, models.FeeSchedule{})
+
+	st := &models.DepositState{Asset: models.AssetUsdc, DstChain: models.Ethereum, AmountWei: big.NewInt(2_000_000_000), CreditedWithWei: big.NewInt(500_500_000)}
+	amount, err := sm.credit(context.Background(), st)
+	if err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+	if amount.Cmp(weiPerEth) != 0 {
+		t.Fatalf("amount = %s, want 1 ETH", amount)
+	}
+	if st.Quote == nil || st.Quote.Base != models.AssetEth || st.Quote.Price != "2500.50000000" || st.Quote.Source != "static" {
+		t.Fatalf("quote = %+v", st.Quote)
+	}
+	// below minimum deposits credited with it are part of the gross amount
+	if st.Fees == nil || st.Fees.Gross.Cmp(big.NewInt(2_500_500_000)) != 0 || st.Fees.Payout.Cmp(amount) != 0 {
+		t.Fatalf("fees = %+v", st.Fees)
+	}
+}
+
+func TestProcessDeposit_FailsRightAwayWhenFeesExceedAmount(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: {}}})
+	sm.fees = newFeeModelForTest(t, nil, models.FeeSchedule{Flat: big.NewInt(1_000_000)})
+	states := newMockStateStore()
+	sm.states = states
+
+	st := &models.DepositState{
+		ID: "dep", State: models.StateSrcTxConfirmed, SrcChain: models.Ethereum, DstChain: models.Hyperliquid,
+		Asset: models.AssetUsdc, AmountWei: big.NewInt(500_000),
+	}
+	sm.processDeposit(context.Background(), st)
+
+	got := states.get("dep")
+	if got.State != models.StateFailed || got.Attempts != 1 || !strings.Contains(got.Error, ErrFeeExceedsAmount.Error()) {
+		t.Fatalf("got state=%s attempts=%d error=%q, want FAILED after one attempt with the fee error", got.State, got.Attempts, got.Error)
+	}
+}
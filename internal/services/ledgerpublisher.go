@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+)
+
+// LedgerBatch holds the spot transfers into Hyperliquid deposit addresses with a ledger time up to Until (unix ms)
+type LedgerBatch struct {
+	Until     uint64
+	Transfers []clients.LedgerUpdate
+}
+
+// LedgerPublisher polls the Hyperliquid non-funding ledger of every deposit address with Hyperliquid as source chain
+// and publishes incoming spot transfers. It is the Hyperliquid counterpart of BlockPublisher, the checkpoint is a unix ms timestamp.
+// The ledger is queried per user, so each tick only polls the batchSize addresses polled longest ago, bounding the
+// requests made to the exchange however many deposit addresses there are.
+type LedgerPublisher struct {
+	client      *clients.HttpClient
+	accounts    stores.IAccountStore
+	checkpoints stores.ICheckpointStore
+	interval    time.Duration
+	lag         time.Duration // ledger updates newer than now-lag are left for the next poll
+	batchSize   int           // deposit addresses polled per tick
+
+	out chan *LedgerBatch
+	err chan error
+
+	lastTime uint64
+	polled   map[string]uint64 // per deposit address, ledger time (unix ms) it was polled up to
+}
+
+func NewLedgerPublisher(client *clients.HttpClient, as stores.IAccountStore, cs stores.ICheckpointStore) *LedgerPublisher {
+	return &LedgerPublisher{
+		client:      client,
+		accounts:    as,
+		checkpoints: cs,
+		interval:    2 * time.Second,
+		lag:         2 * time.Second,
+		batchSize:   5,
+		out:         make(chan *LedgerBatch, 20),
+		err:         make(chan error, 1),
+		polled:      make(map[string]uint64),
+	}
+}
+
+// SetBatchSize sets how many deposit addresses are polled per tick. Must be called before Start.
+func (lp *LedgerPublisher) SetBatchSize(n int) {
+	if n > 0 {
+		lp.batchSize = n
+	}
+}
+
+func (lp *LedgerPublisher) Start(ctx context.Context) error {
+	defer close(lp.out)
+	defer close(lp.err)
+
+	ticker := time.NewTicker(lp.interval)
+	defer ticker.Stop()
+
+	if lp.lastTime == 0 {
+		checkpoint, err := lp.checkpoints.Get(ctx, models.Hyperliquid)
+		if err != nil && !errors.Is(err, stores.ErrCheckpointNotFound) {
+			return fmt.Errorf("error getting checkpoint: %w", err)
+		}
+		if err == nil {
+			lp.lastTime = checkpoint
+		}
+	}
+
+	// no checkpoint, only transfers from now on are picked up
+	if lp.lastTime == 0 {
+		lp.lastTime = uint64(time.Now().Add(-lp.lag).UnixMilli())
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			until := uint64(time.Now().Add(-lp.lag).UnixMilli())
+			if until <= lp.lastTime {
+				continue
+			}
+
+			batch, err := lp.poll(ctx, until)
+			if err != nil {
+				select {
+				case lp.err <- err:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				continue
+			}
+
+			if len(batch.Transfers) == 0 && batch.Until == lp.lastTime {
+				continue
+			}
+			select {
+			case lp.out <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			lp.lastTime = batch.Until
+		}
+	}
+}
+
+func (lp *LedgerPublisher) Out() <-chan *LedgerBatch { return lp.out }
+
+func (lp *LedgerPublisher) Err() <-chan error { return lp.err }
+
+// Commit advances the checkpoint. Call only once the batch has been fully processed, on restart polling resumes after it.
+func (lp *LedgerPublisher) Commit(ctx context.Context, until uint64) error {
+	return lp.checkpoints.Put(ctx, models.Hyperliquid, until)
+}
+
+// poll collects incoming spot transfers of the batchSize Hyperliquid deposit addresses polled longest ago, from the time
+// each was polled up to until `end` (unix ms, inclusive). The batch is complete up to the time every address was polled
+// up to, addresses new to the publisher are polled from the checkpoint.
+func (lp *LedgerPublisher) poll(ctx context.Context, end uint64) (*LedgerBatch, error) {
+	polled := make(map[string]uint64)
+	var users []string
+	if err := lp.accounts.Scan(ctx, func(a *models.Account) error {
+		if a.SrcChain != models.Hyperliquid {
+			return nil
+		}
+		user := a.DepositAddr.Hex()
+		since, ok := lp.polled[user]
+		if !ok {
+			since = lp.lastTime
+		}
+		polled[user] = since
+		users = append(users, user)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error scanning accounts: %w", err)
+	}
+	sort.SliceStable(users, func(i, j int) bool { return polled[users[i]] < polled[users[j]] })
+
+	batch := &LedgerBatch{}
+	for _, user := range users[:min(lp.batchSize, len(users))] {
+		transfers, err := lp.incomingTransfers(ctx, user, polled[user]+1, end)
+		if err != nil {
+			return nil, err
+		}
+		batch.Transfers = append(batch.Transfers, transfers...)
+		polled[user] = end
+	}
+
+	batch.Until = end
+	for _, since := range polled {
+		batch.Until = min(batch.Until, since)
+	}
+	lp.polled = polled
+	return batch, nil
+}
+
+func (lp *LedgerPublisher) incomingTransfers(ctx context.Context, user string, start, end uint64) ([]clients.LedgerUpdate, error) {
+	resp, err := lp.client.Post(ctx, "/info", map[string]any{
+		"type":      "userNonFundingLedgerUpdates",
+		"user":      strings.ToLower(user),
+		"startTime": start,
+		"endTime":   end,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching ledger updates for %s: %w", user, err)
+	}
+
+	var updates []clients.LedgerUpdate
+	if err := json.Unmarshal(resp, &updates); err != nil {
+		return nil, fmt.Errorf("error decoding ledger updates for %s: %w", user, err)
+	}
+
+	var incoming []clients.LedgerUpdate
+	for _, u := range updates {
+		// outgoing transfers, e.g. sweeps, have the deposit address as user
+		if u.Delta.Type == "spotTransfer" && strings.EqualFold(u.Delta.Destination, user) {
+			incoming = append(incoming, u)
+		}
+	}
+	return incoming, nil
+}
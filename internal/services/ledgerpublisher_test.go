@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/mocks"
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type ledgerRequest struct {
+	Type      string `json:"type"`
+	User      string `json:"user"`
+	StartTime uint64 `json:"startTime"`
+	EndTime   uint64 `json:"endTime"`
+}
+
+func newFakeLedger(t *testing.T, updates map[string][]clients.LedgerUpdate) (*httptest.Server, func() []ledgerRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []ledgerRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var req ledgerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		resp := updates[req.User]
+		if resp == nil {
+			resp = []clients.LedgerUpdate{}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []ledgerRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ledgerRequest(nil), requests...)
+	}
+}
+
+func TestLedgerPublisher_Poll_FiltersIncomingTransfers(t *testing.T) {
+	hlAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	ethAddr := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	user := "0x1111111111111111111111111111111111111111"
+	srv, requests := newFakeLedger(t, map[string][]clients.LedgerUpdate{
+		user: {
+			{Hash: "0xin", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "10", User: "0x9999999999999999999999999999999999999999", Destination: user}},
+			{Hash: "0xout", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "10", User: user, Destination: "0x9999999999999999999999999999999999999999"}},
+			{Hash: "0xdeposit", Delta: clients.LedgerDelta{Type: "deposit", Amount: "5"}},
+		},
+	})
+
+	as := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{
+		hlAddr.Hex():  {ID: "hl", SrcChain: models.Hyperliquid, DepositAddr: hlAddr},
+		ethAddr.Hex(): {ID: "eth", SrcChain: models.Ethereum, DepositAddr: ethAddr},
+	}}
+	lp := NewLedgerPublisher(clients.NewHttpClient(srv.URL), as, &mocks.MockCheckpointStore{})
+
+	lp.lastTime = 99
+	batch, err := lp.poll(context.Background(), 200)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if batch.Until != 200 {
+		t.Fatalf("Until = %d, want 200", batch.Until)
+	}
+	if len(batch.Transfers) != 1 || batch.Transfers[0].Hash != "0xin" {
+		t.Fatalf("unexpected transfers %+v", batch.Transfers)
+	}
+
+	// only Hyperliquid deposit addresses are polled
+	if len(requests()) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests()))
+	}
+	req := requests()[0]
+	if req.Type != "userNonFundingLedgerUpdates" || req.User != user || req.StartTime != 100 || req.EndTime != 200 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+}
+
+func TestLedgerPublisher_Start_ResumesFromCheckpoint(t *testing.T) {
+	hlAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	srv, requests := newFakeLedger(t, nil)
+
+	as := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{
+		hlAddr.Hex(): {ID: "hl", SrcChain: models.Hyperliquid, DepositAddr: hlAddr},
+	}}
+	checkpoint := uint64(time.Now().Add(-time.Hour).UnixMilli())
+	cs := &mocks.MockCheckpointStore{Blocks: map[models.Chain]uint64{models.Hyperliquid: checkpoint}}
+	lp := NewLedgerPublisher(clients.NewHttpClient(srv.URL), as, cs)
+	lp.interval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lp.Start(ctx)
+
+	var batch *LedgerBatch
+	select {
+	case batch = <-lp.Out():
+	case err := <-lp.Err():
+		t.Fatalf("publisher error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for batch")
+	}
+	cancel()
+
+	if got := requests()[0].StartTime; got != checkpoint+1 {
+		t.Fatalf("startTime = %d, want %d", got, checkpoint+1)
+	}
+
+	if err := lp.Commit(context.Background(), batch.Until); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got, _ := cs.Get(context.Background(), models.Hyperliquid); got != batch.Until {
+		t.Fatalf("checkpoint = %d, want %d", got, batch.Until)
+	}
+}
+
+func TestLedgerPublisher_Poll_BoundsRequestsPerTick(t *testing.T) {
+	srv, requests := newFakeLedger(t, nil)
+	byAddr := make(map[string]*models.Account)
+	for i := 1; i <= 3; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i)))
+		byAddr[addr.Hex()] = &models.Account{ID: addr.Hex(), SrcChain: models.Hyperliquid, DepositAddr: addr}
+	}
+	lp := NewLedgerPublisher(clients.NewHttpClient(srv.URL), &mocks.MockAccountStore{ByAddr: byAddr}, &mocks.MockCheckpointStore{})
+	lp.SetBatchSize(2)
+	lp.lastTime = 99
+
+	// the third address has not been polled yet, the batch is only complete up to the checkpoint
+	batch, err := lp.poll(context.Background(), 200)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(requests()) != 2 || batch.Until != 99 {
+		t.Fatalf("%d requests, Until = %d, want 2 requests up to 99", len(requests()), batch.Until)
+	}
+
+	// the address polled longest ago goes first
+	batch, err = lp.poll(context.Background(), 300)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	reqs := requests()
+	if len(reqs) != 4 || reqs[2].StartTime != 100 || reqs[3].StartTime != 201 || batch.Until != 200 {
+		t.Fatalf("requests %+v, Until = %d, want the unpolled address from 100 then one from 201, Until 200", reqs[2:], batch.Until)
+	}
+}
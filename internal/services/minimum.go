@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+// defaultMinDeposits are the smallest deposits credited per asset, in base units of the asset
+func defaultMinDeposits() map[string]*big.Int {
+	return map[string]*big.Int{
+		models.AssetEth:  big.NewInt(10_000_000_000_000_000), // 0.01 ETH
+		models.AssetUsdc: big.NewInt(10_000_000),             // 10 USDC
+	}
+}
+
+// claimBelowMinimum checks the deposit against the minimum of its asset. Deposits to the same address that ended below the
+// minimum are added to the deposit, once the sum reaches the minimum they are marked as credited by it and credit is true.
+// Claims made by an earlier attempt of the same deposit are picked up again, so the check can be retried. Claims of a
+// deposit that fails or is canceled before its credit is broadcast are given back by releaseClaims.
+func (sm *StateMachine) claimBelowMinimum(ctx context.Context, st *models.DepositState) (credit bool, err error) {
+	asset := depositAsset(st)
+	min, ok := sm.minDeposits[asset]
+	if !ok {
+		return true, nil
+	}
+
+	// deposits to the same address are checked one at a time, so a below minimum deposit is never claimed twice
+	sm.minimumMu.Lock()
+	defer sm.minimumMu.Unlock()
+
+	var dust []*models.DepositState
+	total := new(big.Int).Set(st.AmountWei)
+	err = sm.states.BelowMinimum(ctx, st.DepositAddr, asset, func(d *models.DepositState) error {
+		if d.CreditedBy == "" || d.CreditedBy == st.ID {
+			dust = append(dust, d)
+			total.Add(total, d.AmountWei)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting deposits below minimum: %w", err)
+	}
+	if total.Cmp(min) < 0 {
+		return false, nil
+	}
+
+	st.CreditedWith = nil
+	st.CreditedWithWei = new(big.Int)
+	for _, d := range dust {
+		if d.CreditedBy == "" {
+			d.CreditedBy = st.ID
+			d.UpdatedAt = time.Now()
+			if err := sm.states.Put(ctx, d); err != nil {
+				return false, fmt.Errorf("error claiming deposit %s: %w", d.ID, err)
+			}
+		}
+		st.CreditedWith = append(st.CreditedWith, d.ID)
+		st.CreditedWithWei.Add(st.CreditedWithWei, d.AmountWei)
+	}
+	return true, nil
+}
+
+// releaseClaims gives back the below minimum deposits claimed by `st` when it fails or is canceled before its credit
+// was broadcast, they are credited with a later deposit to the address instead. Claims of a deposit whose credit may
+// have been broadcast are kept, so the same deposits are never credited twice.
+func (sm *StateMachine) releaseClaims(ctx context.Context, st *models.DepositState) {
+	if len(st.CreditedWith) == 0 || st.SentDstTxHash != "" || len(st.DstTxHashes) > 0 {
+		return
+	}
+
+	sm.minimumMu.Lock()
+	defer sm.minimumMu.Unlock()
+	for _, id := range st.CreditedWith {
+		d, err := sm.states.Get(ctx, id)
+		if err != nil {
+			// the claims stay recorded on the deposit, a retry of it picks them up again
+			fmt.Printf("error releasing deposit %s claimed by %s: %v\n", id, st.ID, err)
+			return
+		}
+		if d.CreditedBy != st.ID {
+			continue
+		}
+		d.CreditedBy = ""
+		d.UpdatedAt = time.Now()
+		if err := sm.states.Put(ctx, d); err != nil {
+			fmt.Printf("error releasing deposit %s claimed by %s: %v\n", id, st.ID, err)
+			return
+		}
+	}
+	st.CreditedWith = nil
+	st.CreditedWithWei = nil
+}
+
+// creditedAmount is the deposited amount plus the below minimum deposits credited with it
+func creditedAmount(st *models.DepositState) *big.Int {
+	amount := new(big.Int).Set(st.AmountWei)
+	if st.CreditedWithWei != nil {
+		amount.Add(amount, st.CreditedWithWei)
+	}
+	return amount
+}
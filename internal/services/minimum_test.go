@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newMinimumTest(t *testing.T) (*StateMachine, *mockStateStore, *[]*big.Int) {
+	t.Helper()
+	var credited []*big.Int
+	dstCtx := &mockChainCtx{
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			credited = append(credited, amount)
+			return "raw_dst", nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{
+		models.Ethereum:    {},
+		models.Hyperliquid: dstCtx,
+	}})
+	states := newMockStateStore()
+	sm.states = states
+	sm.SetMinDeposits(map[string]*big.Int{models.AssetUsdc: big.NewInt(10_000_000)})
+	return sm, states, &credited
+}
+
+func usdcDeposit(id string, amount int64, state models.State) *models.DepositState {
+	return &models.DepositState{
+		ID:          id,
+		State:       state,
+		SrcChain:    models.Ethereum,
+		DstChain:    models.Hyperliquid,
+		TxHash:      id,
+		DepositAddr: common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		DstAddr:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		Asset:       models.AssetUsdc,
+		AmountWei:   big.NewInt(amount),
+	}
+}
+
+func TestTransitionDeposit_BelowMinimumNotCredited(t *testing.T) {
+	sm, _, credited := newMinimumTest(t)
+
+	st := usdcDeposit("a", 4_000_000, models.StateSrcTxConfirmed)
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || !changed || next != models.StateBelowMinimum {
+		t.Fatalf("got next=%s changed=%v err=%v, want BELOW_MINIMUM", next, changed, err)
+	}
+	if !next.IsTerminal() {
+		t.Fatalf("BELOW_MINIMUM should be terminal")
+	}
+	if len(*credited) != 0 {
+		t.Fatalf("deposit below minimum was credited %v", *credited)
+	}
+}
+
+func TestTransitionDeposit_CreditsCumulativeDeposits(t *testing.T) {
+	sm, states, credited := newMinimumTest(t)
+	ctx := context.Background()
+
+	_ = states.Put(ctx, usdcDeposit("a", 4_000_000, models.StateBelowMinimum))
+	_ = states.Put(ctx, usdcDeposit("b", 3_000_000, models.StateBelowMinimum))
+	// other assets and addresses accumulate separately
+	other := usdcDeposit("c", 9_000_000, models.StateBelowMinimum)
+	other.DepositAddr = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	_ = states.Put(ctx, other)
+
+	// 2 + 4 + 3 USDC is still below 10
+	st := usdcDeposit("d", 2_000_000, models.StateSrcTxConfirmed)
+	if next, _, err := sm.TransitionDeposit(ctx, st); err != nil || next != models.StateBelowMinimum {
+		t.Fatalf("got next=%s err=%v, want BELOW_MINIMUM", next, err)
+	}
+	st.State = models.StateBelowMinimum
+	_ = states.Put(ctx, st)
+
+	// 1 + 2 + 4 + 3 USDC reaches the minimum
+	st = usdcDeposit("e", 1_000_000, models.StateSrcTxConfirmed)
+	next, _, err := sm.TransitionDeposit(ctx, st)
+	if err != nil || next != models.StateDstTxBuilt {
+		t.Fatalf("got next=%s err=%v, want DST_TX_BUILT", next, err)
+	}
+	if len(*credited) != 1 || (*credited)[0].Cmp(big.NewInt(10_000_000)) != 0 {
+		t.Fatalf("credited %v, want 10000000", *credited)
+	}
+	if len(st.CreditedWith) != 3 || st.CreditedWithWei.Cmp(big.NewInt(9_000_000)) != 0 {
+		t.Fatalf("credited with %v (%s)", st.CreditedWith, st.CreditedWithWei)
+	}
+	for _, id := range []string{"a", "b", "d"} {
+		if got := states.get(id); got.CreditedBy != "e" || got.State != models.StateBelowMinimum {
+			t.Fatalf("deposit %s credited by %q in state %s", id, got.CreditedBy, got.State)
+		}
+	}
+	if got := states.get("c"); got.CreditedBy != "" {
+		t.Fatalf("deposit to another address credited by %q", got.CreditedBy)
+	}
+
+	// a retried check picks up its own claims, later deposits do not credit them again
+	st.State = models.StateSrcTxConfirmed
+	if _, _, err := sm.TransitionDeposit(ctx, st); err != nil || len(st.CreditedWith) != 3 {
+		t.Fatalf("retry credited with %v, err %v", st.CreditedWith, err)
+	}
+	later := usdcDeposit("f", 1_000_000, models.StateSrcTxConfirmed)
+	if next, _, err := sm.TransitionDeposit(ctx, later); err != nil || next != models.StateBelowMinimum {
+		t.Fatalf("got next=%s err=%v, want BELOW_MINIMUM", next, err)
+	}
+}
+
+func TestTransitionWithdrawal_BelowMinimumNotPaidOut(t *testing.T) {
+	sm, _, credited := newMinimumTest(t)
+
+	st := usdcDeposit("w", 1_000_000, models.StateWithdrawalDetected)
+	st.SrcChain, st.DstChain = models.Hyperliquid, models.Ethereum
+	next, _, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || next != models.StateBelowMinimum {
+		t.Fatalf("got next=%s err=%v, want BELOW_MINIMUM", next, err)
+	}
+	if len(*credited) != 0 {
+		t.Fatalf("withdrawal below minimum was paid out %v", *credited)
+	}
+}
+
+func TestProcessDeposit_ReleasesClaimsOfUnpaidDeposits(t *testing.T) {
+	sm, states, _ := newMinimumTest(t)
+	ctx := context.Background()
+	sm.provider.(*mockChainProvider).byChain[models.Hyperliquid].buildSendTxFn = func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+		return "", errors.New("exchange down")
+	}
+	sm.retries[models.StateSrcTxConfirmed] = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxAttempts: 1}
+
+	_ = states.Put(ctx, usdcDeposit("a", 6_000_000, models.StateBelowMinimum))
+	st := usdcDeposit("b", 5_000_000, models.StateSrcTxConfirmed)
+	_ = states.Put(ctx, st)
+
+	// the claim is made before the credit fails to build, failing gives it back
+	sm.processDeposit(ctx, st)
+	if got := states.get("b"); got.State != models.StateFailed || len(got.CreditedWith) != 0 {
+		t.Fatalf("deposit b in state %s credited with %v", got.State, got.CreditedWith)
+	}
+	if got := states.get("a"); got.CreditedBy != "" {
+		t.Fatalf("deposit a still credited by %q", got.CreditedBy)
+	}
+
+	// claims of a deposit whose credit was broadcast are kept when it is canceled
+	sent := usdcDeposit("c", 5_000_000, models.StateSrcTxConfirmed)
+	if ok, err := sm.claimBelowMinimum(ctx, sent); err != nil || !ok {
+		t.Fatalf("claimBelowMinimum = %v, %v", ok, err)
+	}
+	sent.State = models.StateDstTxSent
+	sent.SentDstTxHash = "0xdst"
+	sent.DstTxHashes = []string{"0xdst"}
+	_ = states.Put(ctx, sent)
+	if _, err := sm.Cancel(ctx, "c", "ops", "stuck"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if got := states.get("a"); got.CreditedBy != "c" {
+		t.Fatalf("deposit a credited by %q, want c", got.CreditedBy)
+	}
+}
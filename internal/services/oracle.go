@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/models"
+)
+
+var (
+	ErrNoPrice        = errors.New("no price for pair")
+	ErrStaleQuote     = errors.New("stale quote")
+	ErrQuoteDeviation = errors.New("quote deviates from reference")
+)
+
+// PriceOracle quotes the price of one unit of `base` in units of `quote`, e.g. USDC per ETH
+type PriceOracle interface {
+	Quote(ctx context.Context, base, quote string) (*models.Quote, error)
+}
+
+// StaticOracle serves prices from configuration, quotes are stamped with the time they are served
+type StaticOracle struct {
+	prices map[string]*big.Rat
+}
+
+// NewStaticOracle takes decimal prices keyed by "base/quote", e.g. {"eth/usdc": "1000"}
+func NewStaticOracle(prices map[string]string) (*StaticOracle, error) {
+	o := &StaticOracle{prices: make(map[string]*big.Rat)}
+	for pair, price := range prices {
+		p, ok := new(big.Rat).SetString(price)
+		if !ok || p.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid price %q for %s", price, pair)
+		}
+		o.prices[strings.ToLower(pair)] = p
+	}
+	return o, nil
+}
+
+func (o *StaticOracle) Quote(ctx context.Context, base, quote string) (*models.Quote, error) {
+	p, ok := o.prices[strings.ToLower(base+"/"+quote)]
+	if !ok {
+		return nil, fmt.Errorf("%w %s/%s", ErrNoPrice, base, quote)
+	}
+	return &models.Quote{
+		Base:   base,
+		Quote:  quote,
+		Price:  p.FloatString(priceDecimals),
+		Source: "static",
+		Time:   time.Now(),
+	}, nil
+}
+
+// HyperliquidOracle prices assets in USDC from the Hyperliquid `allMids` info endpoint. Coins map an asset to its
+// key in the response, a perp coin such as "ETH" or a spot pair such as "@151". Mids are cached for `ttl`, if a
+// refresh fails the last mids are served with the time they were fetched so staleness checks can reject them.
+type HyperliquidOracle struct {
+	client *clients.HttpClient
+	coins  map[string]string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	mids      map[string]string
+	fetchedAt time.Time
+}
+
+func NewHyperliquidOracle(client *clients.HttpClient, coins map[string]string) *HyperliquidOracle {
+	return &HyperliquidOracle{
+		client: client,
+		coins:  coins,
+		ttl:    2 * time.Second,
+	}
+}
+
+func (o *HyperliquidOracle) Quote(ctx context.Context, base, quote string) (*models.Quote, error) {
+	coin, ok := o.coins[strings.ToLower(base)]
+	if !ok || !strings.EqualFold(quote, models.AssetUsdc) {
+		return nil, fmt.Errorf("%w %s/%s", ErrNoPrice, base, quote)
+	}
+
+	mids, fetchedAt, err := o.allMids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mid, ok := mids[coin]
+	if !ok {
+		return nil, fmt.Errorf("%w %s/%s, no mid for %s", ErrNoPrice, base, quote, coin)
+	}
+	p, ok := new(big.Rat).SetString(mid)
+	if !ok || p.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid mid %q for %s", mid, coin)
+	}
+	return &models.Quote{
+		Base:   base,
+		Quote:  quote,
+		Price:  p.FloatString(priceDecimals),
+		Source: "hyperliquid:" + coin,
+		Time:   fetchedAt,
+	}, nil
+}
+
+func (o *HyperliquidOracle) allMids(ctx context.Context) (map[string]string, time.Time, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.mids != nil && time.Since(o.fetchedAt) < o.ttl {
+		return o.mids, o.fetchedAt, nil
+	}
+
+	mids, err := o.fetchMids(ctx)
+	if err != nil {
+		if o.mids == nil {
+			return nil, time.Time{}, err
+		}
+		fmt.Printf("error refreshing mids, serving mids from %s: %v\n", o.fetchedAt, err)
+		return o.mids, o.fetchedAt, nil
+	}
+	o.mids = mids
+	o.fetchedAt = time.Now()
+	return o.mids, o.fetchedAt, nil
+}
+
+func (o *HyperliquidOracle) fetchMids(ctx context.Context) (map[string]string, error) {
+	resp, err := o.client.Post(ctx, "/info", map[string]any{"type": "allMids"})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching mids: %w", err)
+	}
+	var mids map[string]string
+	if err := json.Unmarshal(resp, &mids); err != nil {
+		return nil, fmt.Errorf("error decoding mids: %w", err)
+	}
+	return mids, nil
+}
+
+// CheckedOracle rejects quotes older than maxAge, and if a reference oracle is set quotes deviating from the
+// reference price by more than maxDeviationBps.
+type CheckedOracle struct {
+	oracle          PriceOracle
+	maxAge          time.Duration
+	reference       PriceOracle
+	maxDeviationBps int64
+}
+
+func NewCheckedOracle(oracle PriceOracle, maxAge time.Duration) *CheckedOracle {
+	return &CheckedOracle{
+		oracle: oracle,
+		maxAge: maxAge,
+	}
+}
+
+// SetReference configures a second source quotes are cross checked against
+func (o *CheckedOracle) SetReference(reference PriceOracle, maxDeviationBps int64) {
+	o.reference = reference
+	o.maxDeviationBps = maxDeviationBps
+}
+
+func (o *CheckedOracle) Quote(ctx context.Context, base, quote string) (*models.Quote, error) {
+	q, err := o.oracle.Quote(ctx, base, quote)
+	if err != nil {
+		return nil, err
+	}
+	if age := time.Since(q.Time); age > o.maxAge {
+		return nil, fmt.Errorf("%w from %s, %s old", ErrStaleQuote, q.Source, age.Truncate(time.Millisecond))
+	}
+	if o.reference == nil {
+		return q, nil
+	}
+
+	ref, err := o.reference.Quote(ctx, base, quote)
+	if err != nil {
+		return nil, fmt.Errorf("error getting reference quote: %w", err)
+	}
+	price, _ := new(big.Rat).SetString(q.Price)
+	refPrice, _ := new(big.Rat).SetString(ref.Price)
+	if price == nil || refPrice == nil || refPrice.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid quote prices %q and %q", q.Price, ref.Price)
+	}
+	// |price - ref| / ref in bps
+	dev := new(big.Rat).Sub(price, refPrice)
+	dev.Abs(dev).Quo(dev, refPrice).Mul(dev, big.NewRat(10_000, 1))
+	if dev.Cmp(big.NewRat(o.maxDeviationBps, 1)) > 0 {
+		return nil, fmt.Errorf("%w, %s from %s and %s from %s", ErrQuoteDeviation, q.Price, q.Source, ref.Price, ref.Source)
+	}
+	q.ReferencePrice = ref.Price
+	return q, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+func TestStaticOracle_Quote(t *testing.T) {
+	o, err := NewStaticOracle(map[string]string{"ETH/USDC": "1000.25"})
+	if err != nil {
+		t.Fatalf("NewStaticOracle: %v", err)
+	}
+	q, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("Quote: %v", err)
+	}
+	if q.Price != "1000.25000000" || q.Base != models.AssetEth || q.Quote != models.AssetUsdc {
+		t.Fatalf("quote = %+v", q)
+	}
+	if _, err := o.Quote(context.Background(), "btc", models.AssetUsdc); !errors.Is(err, ErrNoPrice) {
+		t.Fatalf("expected ErrNoPrice, got %v", err)
+	}
+	if _, err := NewStaticOracle(map[string]string{"eth/usdc": "-1"}); err == nil {
+		t.Fatalf("expected error for negative price")
+	}
+}
+
+func TestHyperliquidOracle_QuotesMidAndServesLastMidsOnError(t *testing.T) {
+	fail := false
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ETH":"3120.5","@151":"3119.9","BTC":"65000.0"}`))
+	}))
+	defer ts.Close()
+
+	o := NewHyperliquidOracle(newHLClient(ts), map[string]string{models.AssetEth: "ETH"})
+	o.ttl = time.Hour
+
+	q, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("Quote: %v", err)
+	}
+	if q.Price != "3120.50000000" || q.Source != "hyperliquid:ETH" {
+		t.Fatalf("quote = %+v", q)
+	}
+	// cached within ttl
+	if _, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc); err != nil || calls != 1 {
+		t.Fatalf("expected cached mids, calls = %d, err = %v", calls, err)
+	}
+
+	fail = true
+	o.ttl = 0
+	stale, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("Quote with failing refresh: %v", err)
+	}
+	if !stale.Time.Equal(q.Time) {
+		t.Fatalf("quote time = %s, want time of last successful fetch %s", stale.Time, q.Time)
+	}
+
+	if _, err := o.Quote(context.Background(), "btc", models.AssetUsdc); !errors.Is(err, ErrNoPrice) {
+		t.Fatalf("expected ErrNoPrice for unmapped asset, got %v", err)
+	}
+	if _, err := o.Quote(context.Background(), models.AssetEth, "usdt"); !errors.Is(err, ErrNoPrice) {
+		t.Fatalf("expected ErrNoPrice for non USDC quote, got %v", err)
+	}
+}
+
+type fixedOracle struct {
+	quote *models.Quote
+}
+
+func (o *fixedOracle) Quote(ctx context.Context, base, quote string) (*models.Quote, error) {
+	q := *o.quote
+	return &q, nil
+}
+
+func TestCheckedOracle_RejectsStaleQuotes(t *testing.T) {
+	src := &fixedOracle{quote: &models.Quote{Base: models.AssetEth, Quote: models.AssetUsdc, Price: "1000", Source: "test", Time: time.Now().Add(-time.Minute)}}
+	o := NewCheckedOracle(src, 30*time.Second)
+	if _, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc); !errors.Is(err, ErrStaleQuote) {
+		t.Fatalf("expected ErrStaleQuote, got %v", err)
+	}
+
+	src.quote.Time = time.Now()
+	if _, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc); err != nil {
+		t.Fatalf("fresh quote: %v", err)
+	}
+}
+
+func TestCheckedOracle_DeviationFromReference(t *testing.T) {
+	src := &fixedOracle{quote: &models.Quote{Base: models.AssetEth, Quote: models.AssetUsdc, Price: "1040", Source: "test", Time: time.Now()}}
+	ref, _ := NewStaticOracle(map[string]string{"eth/usdc": "1000"})
+	o := NewCheckedOracle(src, time.Minute)
+
+	// 4% off the reference
+	o.SetReference(ref, 300)
+	if _, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc); !errors.Is(err, ErrQuoteDeviation) {
+		t.Fatalf("expected ErrQuoteDeviation, got %v", err)
+	}
+
+	o.SetReference(ref, 400)
+	q, err := o.Quote(context.Background(), models.AssetEth, models.AssetUsdc)
+	if err != nil {
+		t.Fatalf("Quote within deviation: %v", err)
+	}
+	if q.ReferencePrice != "1000.00000000" {
+		t.Fatalf("reference price = %q", q.ReferencePrice)
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idle buckets are dropped this often, a dropped bucket is recreated full which is where it would have refilled to
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit allows Burst requests at once, refilled at PerSecond
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimiter keeps a token bucket per key
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token from the bucket of `key` under `limit`. If the bucket is empty it returns false and how long
+// until a token is available.
+func (l *RateLimiter) Allow(key string, limit RateLimit, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, found := l.buckets[key]
+	if !found || b.limit != limit {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if limit.PerSecond <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - b.tokens) / limit.PerSecond * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.limit.PerSecond > 0 && b.tokens+now.Sub(b.last).Seconds()*b.limit.PerSecond >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
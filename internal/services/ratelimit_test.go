@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_BurstThenRefill(t *testing.T) {
+	l := NewRateLimiter()
+	limit := RateLimit{PerSecond: 2, Burst: 3}
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a", limit, now); !ok {
+			t.Fatalf("request %d of the burst rejected", i)
+		}
+	}
+	ok, retryAfter := l.Allow("a", limit, now)
+	if ok {
+		t.Fatal("request past the burst allowed")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Fatalf("retryAfter = %s, want 500ms", retryAfter)
+	}
+
+	// other keys have their own bucket
+	if ok, _ := l.Allow("b", limit, now); !ok {
+		t.Fatal("other key rejected")
+	}
+
+	if ok, _ := l.Allow("a", limit, now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("request after refill rejected")
+	}
+	if ok, _ := l.Allow("a", limit, now.Add(500*time.Millisecond)); ok {
+		t.Fatal("second request after refilling one token allowed")
+	}
+}
+
+func TestRateLimiter_SweepsFullBuckets(t *testing.T) {
+	l := NewRateLimiter()
+	limit := RateLimit{PerSecond: 1, Burst: 1}
+	now := time.Unix(1_700_000_000, 0)
+
+	l.Allow("a", limit, now)
+	l.Allow("b", limit, now.Add(2*time.Minute))
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("refilled bucket not swept")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("bucket in use swept")
+	}
+}
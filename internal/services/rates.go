@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+
+	"unit/agent/internal/models"
+)
+
+// decimals of USDC, both on Hyperliquid spot and as ERC-20
+const usdcDecimals = 6
+
+// decimals quoted prices are rounded to
+const priceDecimals = 8
+
+var (
+	weiPerEth   = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	usdcPerUnit = new(big.Int).Exp(big.NewInt(10), big.NewInt(6), nil) // USDC base units per USDC
+)
+
+// payoutAsset is the asset deposits are credited in on each destination chain
+func payoutAsset(chain models.Chain) string {
+	if chain == models.Hyperliquid {
+		return models.AssetUsdc
+	}
+	return models.AssetEth
+}
+
+// baseUnits is the number of base units in one unit of `asset`
+func baseUnits(asset string) (*big.Int, error) {
+	switch asset {
+	case models.AssetEth:
+		return weiPerEth, nil
+	case models.AssetUsdc:
+		return usdcPerUnit, nil
+	}
+	return nil, fmt.Errorf("unknown asset %s", asset)
+}
+
+// depositAsset is the asset of the deposit, deposits recorded before assets were tracked have no asset and are native ETH
+func depositAsset(st *models.DepositState) string {
+	if st.Asset == "" {
+		return models.AssetEth
+	}
+	return st.Asset
+}
+
+// convertAmount converts `amount` base units of asset `from` into base units of asset `to` at the quoted price,
+// rounding down. The quote may price either asset in the other.
+func convertAmount(amount *big.Int, from, to string, q *models.Quote) (*big.Int, error) {
+	if from == to {
+		return new(big.Int).Set(amount), nil
+	}
+	if q == nil {
+		return nil, fmt.Errorf("no quote to convert %s to %s", from, to)
+	}
+	price, ok := new(big.Rat).SetString(q.Price)
+	if !ok || price.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid quote price %q", q.Price)
+	}
+	switch {
+	case q.Base == from && q.Quote == to:
+	case q.Base == to && q.Quote == from:
+		price.Inv(price)
+	default:
+		return nil, fmt.Errorf("quote for %s/%s cannot convert %s to %s", q.Base, q.Quote, from, to)
+	}
+	fromUnits, err := baseUnits(from)
+	if err != nil {
+		return nil, err
+	}
+	toUnits, err := baseUnits(to)
+	if err != nil {
+		return nil, err
+	}
+
+	// amount / fromUnits * price * toUnits
+	out := new(big.Rat).SetFrac(amount, fromUnits)
+	out.Mul(out, price).Mul(out, new(big.Rat).SetInt(toUnits))
+	return new(big.Int).Quo(out.Num(), out.Denom()), nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"math/big"
+	"testing"
+
+	"unit/agent/internal/models"
+)
+
+func TestConvertAmount(t *testing.T) {
+	oneEth := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ethUsdc := &models.Quote{Base: models.AssetEth, Quote: models.AssetUsdc, Price: "1000"}
+	cases := []struct {
+		name     string
+		amount   *big.Int
+		from, to string
+		quote    *models.Quote
+		want     *big.Int
+	}{
+		{"eth to usdc", new(big.Int).Quo(oneEth, big.NewInt(100)), models.AssetEth, models.AssetUsdc, ethUsdc, big.NewInt(10_000_000)},
+		{"usdc to eth", big.NewInt(10_000_000), models.AssetUsdc, models.AssetEth, ethUsdc, new(big.Int).Quo(oneEth, big.NewInt(100))},
+		{"same asset needs no quote", big.NewInt(42), models.AssetUsdc, models.AssetUsdc, nil, big.NewInt(42)},
+		{"fractional price", oneEth, models.AssetEth, models.AssetUsdc, &models.Quote{Base: models.AssetEth, Quote: models.AssetUsdc, Price: "3120.45678912"}, big.NewInt(3_120_456_789)},
+		{"rounds down", big.NewInt(999_999_999), models.AssetEth, models.AssetUsdc, ethUsdc, big.NewInt(0)},
+	}
+	for _, c := range cases {
+		got, err := convertAmount(c.amount, c.from, c.to, c.quote)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got.Cmp(c.want) != 0 {
+			t.Fatalf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	if _, err := convertAmount(big.NewInt(1), "doge", models.AssetUsdc, &models.Quote{Base: "doge", Quote: models.AssetUsdc, Price: "1"}); err == nil {
+		t.Fatalf("expected error for unknown asset")
+	}
+	if _, err := convertAmount(big.NewInt(1), models.AssetEth, models.AssetUsdc, nil); err == nil {
+		t.Fatalf("expected error without quote")
+	}
+	if _, err := convertAmount(big.NewInt(1), models.AssetEth, models.AssetUsdc, &models.Quote{Base: "btc", Quote: models.AssetUsdc, Price: "1"}); err == nil {
+		t.Fatalf("expected error for quote of another pair")
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+// sentTx points at the deposit fields tracking one outgoing tx and its same nonce replacements
+type sentTx struct {
+	chain  models.Chain
+	from   string
+	sweep  bool
+	raw    *string
+	hash   *string
+	hashes *[]string
+	sentAt *time.Time
+}
+
+func dstTx(st *models.DepositState, from string) *sentTx {
+	return &sentTx{chain: st.DstChain, from: from, raw: &st.UnsignedDstTx, hash: &st.SentDstTxHash, hashes: &st.DstTxHashes, sentAt: &st.DstTxSentAt}
+}
+
+func sweepTx(st *models.DepositState) *sentTx {
+	return &sentTx{chain: st.SrcChain, from: st.DepositAddr.Hex(), sweep: true, raw: &st.UnsignedSweepTx, hash: &st.SentSweepTxHash, hashes: &st.SweepTxHashes, sentAt: &st.SweepTxSentAt}
+}
+
+// broadcast sends a freshly built tx, earlier broadcasts belonged to a different nonce and are forgotten
+func (t *sentTx) broadcast(ctx context.Context, sm *StateMachine) error {
+	hash, err := sm.provider.WithChain(t.chain).BroadcastTx(ctx, *t.raw, t.from)
+	if err != nil {
+		return fmt.Errorf("error sending tx: %v", err)
+	}
+	t.record(hash)
+	return nil
+}
+
+// record tracks `hash` as the only broadcast of the tx
+func (t *sentTx) record(hash string) {
+	*t.hash = hash
+	*t.hashes = []string{hash}
+	*t.sentAt = time.Now()
+}
+
+// await checks every broadcast of the tx, the first one with enough confirmations wins and becomes the sent hash.
+// A reverted receipt on any of them is final since they share a nonce. While nothing is mined and the last broadcast is
+// older than stuckAfter, the tx is replaced with a same nonce tx paying higher fees.
+func (t *sentTx) await(ctx context.Context, sm *StateMachine) (confirmed bool, err error) {
+	c := sm.provider.WithChain(t.chain)
+
+	hashes := *t.hashes
+	if len(hashes) == 0 && *t.hash != "" {
+		// sent before replacements were tracked
+		hashes = []string{*t.hash}
+	}
+	for _, hash := range hashes {
+		confirmed, err := c.IsTxConfirmed(ctx, hash, sm.minConfirmations)
+		if err != nil {
+			if errors.Is(err, ErrorRejectedTransaction) {
+				*t.hash = hash
+			}
+			return false, err
+		}
+		if confirmed {
+			*t.hash = hash
+			return true, nil
+		}
+	}
+
+	if t.sentAt.IsZero() || time.Since(*t.sentAt) < sm.stuckAfter {
+		return false, nil
+	}
+
+	// bump failures are not returned, the stuck tx may still be mined and errors would count against the retry budget
+	bumped, err := c.BumpTx(ctx, *t.raw, t.from, t.sweep)
+	if err != nil {
+		if !errors.Is(err, ErrBumpNotSupported) && !errors.Is(err, ErrNonceConsumed) {
+			fmt.Printf("error bumping tx %s: %v\n", *t.hash, err)
+		}
+		return false, nil
+	}
+	hash, err := c.BroadcastTx(ctx, bumped, t.from)
+	if err != nil {
+		fmt.Printf("error sending replacement for tx %s: %v\n", *t.hash, err)
+		return false, nil
+	}
+
+	fmt.Printf("replaced stuck tx %s with %s\n", *t.hash, hash)
+	*t.raw = bumped
+	*t.hash = hash
+	*t.hashes = append(hashes, hash)
+	*t.sentAt = time.Now()
+	return false, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newSentDeposit(sentAt time.Time) *models.DepositState {
+	return &models.DepositState{
+		State:         models.StateDstTxSent,
+		SrcChain:      models.Hyperliquid,
+		DstChain:      models.Ethereum,
+		DepositAddr:   common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		AmountWei:     big.NewInt(1),
+		UnsignedDstTx: "raw_1",
+		SentDstTxHash: "0x1",
+		DstTxHashes:   []string{"0x1"},
+		DstTxSentAt:   sentAt,
+	}
+}
+
+func TestTransitionDeposit_BroadcastTracksHash(t *testing.T) {
+	dstCtx := &mockChainCtx{
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) { return "0xdst", nil },
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: dstCtx}})
+
+	st := newSentDeposit(time.Time{})
+	st.State = models.StateDstTxBuilt
+	st.DstTxHashes = []string{"0xold_nonce"}
+
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || !changed || next != models.StateDstTxSent {
+		t.Fatalf("got next=%s changed=%v err=%v", next, changed, err)
+	}
+	if st.SentDstTxHash != "0xdst" || len(st.DstTxHashes) != 1 || st.DstTxHashes[0] != "0xdst" || st.DstTxSentAt.IsZero() {
+		t.Fatalf("unexpected tracking hash=%s hashes=%v sentAt=%s", st.SentDstTxHash, st.DstTxHashes, st.DstTxSentAt)
+	}
+}
+
+func TestTransitionDeposit_ReplacesStuckTx(t *testing.T) {
+	var bumpedFrom string
+	var bumpedSweep bool
+	dstCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return false, nil },
+		bumpTxFn: func(ctx context.Context, raw, from string, sweep bool) (string, error) {
+			bumpedFrom, bumpedSweep = from, sweep
+			return raw + "_bumped", nil
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) { return "0x2", nil },
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: dstCtx}})
+	sm.stuckAfter = time.Minute
+
+	// recently sent, not stuck yet
+	st := newSentDeposit(time.Now())
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || changed || next != models.StateDstTxSent || len(st.DstTxHashes) != 1 {
+		t.Fatalf("expected to keep waiting, got next=%s changed=%v err=%v hashes=%v", next, changed, err, st.DstTxHashes)
+	}
+
+	st = newSentDeposit(time.Now().Add(-2 * time.Minute))
+	next, changed, err = sm.TransitionDeposit(context.Background(), st)
+	if err != nil || changed || next != models.StateDstTxSent {
+		t.Fatalf("got next=%s changed=%v err=%v", next, changed, err)
+	}
+	if bumpedFrom != sm.hotWallets[models.Ethereum] || bumpedSweep {
+		t.Fatalf("bumped from=%s sweep=%v", bumpedFrom, bumpedSweep)
+	}
+	if st.UnsignedDstTx != "raw_1_bumped" || st.SentDstTxHash != "0x2" {
+		t.Fatalf("unexpected replacement raw=%s hash=%s", st.UnsignedDstTx, st.SentDstTxHash)
+	}
+	if len(st.DstTxHashes) != 2 || st.DstTxHashes[0] != "0x1" || st.DstTxHashes[1] != "0x2" {
+		t.Fatalf("hashes = %v, want [0x1 0x2]", st.DstTxHashes)
+	}
+	if time.Since(st.DstTxSentAt) > time.Second {
+		t.Fatalf("sent at not reset: %s", st.DstTxSentAt)
+	}
+}
+
+func TestTransitionDeposit_FirstConfirmedReplacementWins(t *testing.T) {
+	dstCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			// the original was dropped, the first replacement got mined
+			return txHash == "0x2", nil
+		},
+		bumpTxFn: func(ctx context.Context, raw, from string, sweep bool) (string, error) {
+			t.Fatalf("unexpected bump")
+			return "", nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: dstCtx}})
+
+	st := newSentDeposit(time.Now().Add(-time.Hour))
+	st.DstTxHashes = []string{"0x1", "0x2", "0x3"}
+	st.SentDstTxHash = "0x3"
+
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || !changed || next != models.StateDstTxConfirmed {
+		t.Fatalf("got next=%s changed=%v err=%v", next, changed, err)
+	}
+	if st.SentDstTxHash != "0x2" {
+		t.Fatalf("winner = %s, want 0x2", st.SentDstTxHash)
+	}
+}
+
+func TestTransitionDeposit_StuckSweepNotBumpedOnceMined(t *testing.T) {
+	broadcasts := 0
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return false, nil },
+		bumpTxFn: func(ctx context.Context, raw, from string, sweep bool) (string, error) {
+			if !sweep {
+				t.Fatalf("expected sweep bump")
+			}
+			return "", ErrNonceConsumed
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) {
+			broadcasts++
+			return "", nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	sm.stuckAfter = time.Minute
+
+	st := &models.DepositState{
+		State:           models.StateSweepTxSent,
+		SrcChain:        models.Ethereum,
+		DstChain:        models.Hyperliquid,
+		UnsignedSweepTx: "raw_sweep",
+		SentSweepTxHash: "0xs1",
+		SweepTxHashes:   []string{"0xs1"},
+		SweepTxSentAt:   time.Now().Add(-time.Hour),
+	}
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || changed || next != models.StateSweepTxSent {
+		t.Fatalf("got next=%s changed=%v err=%v", next, changed, err)
+	}
+	if broadcasts != 0 || len(st.SweepTxHashes) != 1 {
+		t.Fatalf("expected no replacement, broadcasts=%d hashes=%v", broadcasts, st.SweepTxHashes)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+type RetryPolicy struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+	// delay before checking again when a transition is waiting on something, e.g. confirmations
+	PollInterval time.Duration
+}
+
+// Backoff returns the delay before the next attempt after `attempts` consecutive failures.
+// Grows exponentially from BaseDelay up to MaxDelay, jittered to [delay/2, delay) so failing deposits don't retry in lockstep.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
+
+var (
+	buildRetryPolicy = RetryPolicy{
+		BaseDelay:   5 * time.Second,
+		MaxDelay:    5 * time.Minute,
+		MaxAttempts: 20,
+	}
+	broadcastRetryPolicy = RetryPolicy{
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    2 * time.Minute,
+		MaxAttempts: 20,
+	}
+	confirmationRetryPolicy = RetryPolicy{
+		BaseDelay:    5 * time.Second,
+		MaxDelay:     10 * time.Minute,
+		MaxAttempts:  50,
+		PollInterval: 12 * time.Second, // ~1 Ethereum block
+	}
+)
+
+func defaultRetryPolicies() map[models.State]RetryPolicy {
+	return map[models.State]RetryPolicy{
+		models.StateSrcTxConfirmed: buildRetryPolicy,
+		models.StateDstTxResend:    buildRetryPolicy,
+		models.StateDstTxConfirmed: buildRetryPolicy,
+		models.StateSweepTxResend:  buildRetryPolicy,
+
+		models.StateSweepTopUpResend:    buildRetryPolicy,
+		models.StateSweepTopUpConfirmed: buildRetryPolicy,
+
+		models.StateWithdrawalDetected:        buildRetryPolicy,
+		models.StateWithdrawalPayoutResend:    buildRetryPolicy,
+		models.StateWithdrawalPayoutConfirmed: buildRetryPolicy,
+		models.StateWithdrawalSweepResend:     buildRetryPolicy,
+
+		models.StateDstTxBuilt:   broadcastRetryPolicy,
+		models.StateSweepTxBuilt: broadcastRetryPolicy,
+
+		models.StateSweepTopUpBuilt: broadcastRetryPolicy,
+
+		models.StateWithdrawalPayoutBuilt: broadcastRetryPolicy,
+		models.StateWithdrawalSweepBuilt:  broadcastRetryPolicy,
+
+		models.StateSrcTxDiscovered: confirmationRetryPolicy,
+		models.StateDstTxSent:       confirmationRetryPolicy,
+		models.StateSweepTxSent:     confirmationRetryPolicy,
+
+		models.StateSweepTopUpSent: confirmationRetryPolicy,
+
+		models.StateWithdrawalPayoutSent: confirmationRetryPolicy,
+		models.StateWithdrawalSweepSent:  confirmationRetryPolicy,
+	}
+}
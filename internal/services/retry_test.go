@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+)
+
+func TestRetryPolicy_Backoff_GrowsExponentially(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Hour}
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 5: 16 * time.Second} {
+		for i := 0; i < 50; i++ {
+			got := p.Backoff(attempts)
+			if got < want/2 || got >= want {
+				t.Fatalf("Backoff(%d) = %s, want in [%s, %s)", attempts, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff_CappedAtMaxDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	for i := 0; i < 50; i++ {
+		if got := p.Backoff(1000); got < 5*time.Second || got >= 10*time.Second {
+			t.Fatalf("Backoff(1000) = %s, want in [5s, 10s)", got)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff_Jitters(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	seen := map[time.Duration]struct{}{}
+	for i := 0; i < 20; i++ {
+		seen[p.Backoff(3)] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("Backoff returned the same delay %d times, want jitter", 20)
+	}
+}
+
+func TestStateMachine_retryPolicy_PerState(t *testing.T) {
+	sm := &StateMachine{retries: defaultRetryPolicies()}
+
+	if got := sm.retryPolicy(models.StateSrcTxDiscovered); got != confirmationRetryPolicy {
+		t.Fatalf("SRC_TX_DISCOVERED policy = %+v, want confirmation policy", got)
+	}
+	if got := sm.retryPolicy(models.StateDstTxBuilt); got != broadcastRetryPolicy {
+		t.Fatalf("DST_TX_BUILT policy = %+v, want broadcast policy", got)
+	}
+	if got := sm.retryPolicy(models.StateSrcTxConfirmed); got != buildRetryPolicy {
+		t.Fatalf("SRC_TX_CONFIRMED policy = %+v, want build policy", got)
+	}
+	if got := sm.retryPolicy("UNKNOWN"); got != buildRetryPolicy {
+		t.Fatalf("fallback policy = %+v, want build policy", got)
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/stores"
+	"unit/agent/internal/utils/hmacsig"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SignerServer exposes a key store to agents over HTTP, see stores.RemoteKeyStore for the client side.
+// Every request must carry a fresh HMAC over the shared secret and is accepted once, every response is signed back.
+type SignerServer struct {
+	server  *http.Server
+	keys    stores.IKeyStore
+	secret  []byte
+	replays *hmacsig.ReplayCache
+}
+
+func NewSignerServer(ks stores.IKeyStore, secret []byte) *SignerServer {
+	s := &SignerServer{keys: ks, secret: secret, replays: hmacsig.NewReplayCache()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/create", s.handle(s.createKey))
+	mux.HandleFunc("/keys/has", s.handle(s.hasKey))
+	mux.HandleFunc("/keys/index", s.handle(s.keyIndex))
+	mux.HandleFunc("/keys/restore", s.handle(s.restoreKey))
+	mux.HandleFunc("/sign/tx", s.handle(s.signTx))
+	mux.HandleFunc("/sign/typed", s.handle(s.signTypedData))
+
+	s.server = &http.Server{Handler: mux}
+	return s
+}
+
+func (s *SignerServer) Serve(l net.Listener) error {
+	return s.server.Serve(l)
+}
+
+func (s *SignerServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// signerError is returned to the client with its status, any other error is an internal error
+type signerError struct {
+	status int
+	msg    string
+}
+
+func (e *signerError) Error() string { return e.msg }
+
+// handle authenticates the request, runs `fn` on its body and writes the signed response
+func (s *SignerServer) handle(fn func(ctx context.Context, body []byte) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqSig := r.Header.Get(hmacsig.HeaderSignature)
+		write := func(status int, v any) {
+			body, _ := json.Marshal(v)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(hmacsig.HeaderSignature, hmacsig.Sign(s.secret, hmacsig.ResponseParts(reqSig, status, body)...))
+			w.WriteHeader(status)
+			w.Write(body)
+		}
+
+		if r.Method != http.MethodPost {
+			write(http.StatusMethodNotAllowed, clients.SignerError{Error: "method not allowed"})
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			write(http.StatusBadRequest, clients.SignerError{Error: "error reading body"})
+			return
+		}
+		now := time.Now()
+		ts := r.Header.Get(hmacsig.HeaderTimestamp)
+		nonce := r.Header.Get(hmacsig.HeaderNonce)
+		if !hmacsig.Fresh(ts, now) ||
+			!hmacsig.Verify(s.secret, reqSig, hmacsig.RequestParts(r.Method, r.URL.Path, ts, nonce, body)...) {
+			write(http.StatusUnauthorized, clients.SignerError{Error: "unauthorized"})
+			return
+		}
+		if s.replays.Seen(reqSig, now) {
+			write(http.StatusUnauthorized, clients.SignerError{Error: "replayed request"})
+			return
+		}
+
+		resp, err := fn(r.Context(), body)
+		if err != nil {
+			if se, ok := err.(*signerError); ok {
+				write(se.status, clients.SignerError{Error: se.msg})
+				return
+			}
+			fmt.Printf("signer error on %s: %v\n", r.URL.Path, err)
+			write(http.StatusInternalServerError, clients.SignerError{Error: "internal server error"})
+			return
+		}
+		write(http.StatusOK, resp)
+	}
+}
+
+func decodeSignerRequest(body []byte, v any) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return &signerError{status: http.StatusBadRequest, msg: "invalid request body"}
+	}
+	return nil
+}
+
+func (s *SignerServer) createKey(ctx context.Context, body []byte) (any, error) {
+	addr, err := s.keys.CreateKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return clients.SignerKeyResponse{Address: addr, Ok: true}, nil
+}
+
+func (s *SignerServer) hasKey(ctx context.Context, body []byte) (any, error) {
+	var req clients.SignerKeyRequest
+	if err := decodeSignerRequest(body, &req); err != nil {
+		return nil, err
+	}
+	return clients.SignerKeyResponse{Address: req.Address, Ok: s.keys.HasKey(ctx, req.Address)}, nil
+}
+
+func (s *SignerServer) keyIndex(ctx context.Context, body []byte) (any, error) {
+	var req clients.SignerKeyRequest
+	if err := decodeSignerRequest(body, &req); err != nil {
+		return nil, err
+	}
+	index, ok := s.keys.KeyIndex(ctx, req.Address)
+	return clients.SignerKeyResponse{Address: req.Address, Index: index, Ok: ok}, nil
+}
+
+func (s *SignerServer) restoreKey(ctx context.Context, body []byte) (any, error) {
+	var req clients.SignerKeyRequest
+	if err := decodeSignerRequest(body, &req); err != nil {
+		return nil, err
+	}
+	r, ok := s.keys.(stores.IKeyRestorer)
+	if !ok {
+		return nil, &signerError{status: http.StatusNotImplemented, msg: "key store does not derive keys from a seed"}
+	}
+	if err := r.Restore(ctx, req.Index, req.Address); err != nil {
+		return nil, &signerError{status: http.StatusConflict, msg: err.Error()}
+	}
+	return clients.SignerKeyResponse{Address: req.Address, Index: req.Index, Ok: true}, nil
+}
+
+func (s *SignerServer) signTx(ctx context.Context, body []byte) (any, error) {
+	var req clients.SignerTxRequest
+	if err := decodeSignerRequest(body, &req); err != nil {
+		return nil, err
+	}
+	chainID, ok := new(big.Int).SetString(req.ChainID, 10)
+	if !ok {
+		return nil, &signerError{status: http.StatusBadRequest, msg: "invalid chain id"}
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.Hex2Bytes(req.Tx)); err != nil {
+		return nil, &signerError{status: http.StatusBadRequest, msg: "invalid tx"}
+	}
+	if !s.keys.HasKey(ctx, req.Address) {
+		return nil, &signerError{status: http.StatusNotFound, msg: fmt.Sprintf("address not found: %s", req.Address)}
+	}
+
+	signed, err := s.keys.SignTx(ctx, req.Address, tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return clients.SignerTxResponse{Tx: common.Bytes2Hex(raw)}, nil
+}
+
+func (s *SignerServer) signTypedData(ctx context.Context, body []byte) (any, error) {
+	var req clients.SignerTypedDataRequest
+	if err := decodeSignerRequest(body, &req); err != nil {
+		return nil, err
+	}
+	if !s.keys.HasKey(ctx, req.Address) {
+		return nil, &signerError{status: http.StatusNotFound, msg: fmt.Sprintf("address not found: %s", req.Address)}
+	}
+	sig, err := s.keys.SignTypedData(ctx, req.Address, req.TypedData)
+	if err != nil {
+		return nil, err
+	}
+	return clients.SignerTypedDataResponse{Signature: hexutil.Encode(sig)}, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/stores"
+	"unit/agent/internal/utils/hmacsig"
+	hlutil "unit/agent/internal/utils/hyperliquid"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// startSigner serves an HD key store on a unix socket and returns its endpoint
+func startSigner(t *testing.T, secret []byte) (*SignerServer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	hd, err := stores.NewHDKeyStore("testpass", filepath.Join(dir, "seed.json"))
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+	s := NewSignerServer(hd, secret)
+
+	socket := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return s, "unix://" + socket
+}
+
+func newRemoteKeyStore(t *testing.T, endpoint string, secret []byte) *stores.RemoteKeyStore {
+	t.Helper()
+	client, err := clients.NewSignerClient(endpoint, secret)
+	if err != nil {
+		t.Fatalf("NewSignerClient: %v", err)
+	}
+	return stores.NewRemoteKeyStore(client)
+}
+
+func TestRemoteKeyStore_RoundTripOverUnixSocket(t *testing.T) {
+	secret := []byte("shared-secret")
+	server, endpoint := startSigner(t, secret)
+	ks := newRemoteKeyStore(t, endpoint, secret)
+	ctx := context.Background()
+
+	addr, err := ks.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if !ks.HasKey(ctx, addr) {
+		t.Fatalf("HasKey(%s) = false", addr)
+	}
+	if ks.HasKey(ctx, "0x000000000000000000000000000000000000dEaD") {
+		t.Fatal("HasKey true for unknown address")
+	}
+	if i, ok := ks.KeyIndex(ctx, addr); !ok || i != 0 {
+		t.Fatalf("KeyIndex = %d %v, want 0", i, ok)
+	}
+	if err := ks.Restore(ctx, 0, addr); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if err := ks.Restore(ctx, 1, addr); err == nil {
+		t.Fatal("expected error restoring at the wrong index")
+	}
+
+	chainID := big.NewInt(11155111)
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: 3, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21000, To: &to, Value: big.NewInt(5)})
+	signed, err := ks.SignTx(ctx, addr, tx, chainID)
+	if err != nil {
+		t.Fatalf("SignTx: %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+	if err != nil || sender != common.HexToAddress(addr) {
+		t.Fatalf("sender = %s err=%v, want %s", sender.Hex(), err, addr)
+	}
+
+	if _, err := ks.SignTx(ctx, "0x000000000000000000000000000000000000dEaD", tx, chainID); err == nil {
+		t.Fatal("expected error signing with unknown address")
+	}
+
+	// typed data goes through JSON, integer fields must hash the same on the signer
+	td, err := hlutil.UserSignedPayload("HyperliquidTransaction:SpotSend", []hlutil.TypeProperty{
+		{Name: "destination", Type: "string"},
+		{Name: "amount", Type: "string"},
+		{Name: "time", Type: "uint64"},
+	}, map[string]any{
+		"signatureChainId": "0x66eee",
+		"destination":      "0x2222222222222222222222222222222222222222",
+		"amount":           "1.5",
+		"time":             big.NewInt(1716531066415),
+	})
+	if err != nil {
+		t.Fatalf("UserSignedPayload: %v", err)
+	}
+	sig, err := hlutil.SignInner(ctx, ks, addr, td)
+	if err != nil {
+		t.Fatalf("SignInner: %v", err)
+	}
+	// signatures are deterministic, signing in process must give the same result
+	want, err := hlutil.SignInner(ctx, server.keys, addr, td)
+	if err != nil {
+		t.Fatalf("SignInner in process: %v", err)
+	}
+	if *sig != *want {
+		t.Fatalf("remote signature %+v, want %+v", sig, want)
+	}
+}
+
+func TestRemoteKeyStore_WrongSecret(t *testing.T) {
+	_, endpoint := startSigner(t, []byte("shared-secret"))
+	ks := newRemoteKeyStore(t, endpoint, []byte("other-secret"))
+
+	// the signer rejects the request, and its response does not authenticate under the client's secret either
+	if _, err := ks.CreateKey(context.Background()); !errors.Is(err, clients.ErrSignerAuth) {
+		t.Fatalf("expected ErrSignerAuth, got %v", err)
+	}
+}
+
+func TestSignerServer_RejectsUnauthenticatedRequests(t *testing.T) {
+	secret := []byte("shared-secret")
+	s := NewSignerServer(nil, secret)
+	body := []byte(`{}`)
+
+	cases := map[string]struct {
+		ts  string
+		sig func(ts string) string
+	}{
+		"no signature": {ts: strconv.FormatInt(time.Now().Unix(), 10), sig: func(string) string { return "" }},
+		"bad signature": {ts: strconv.FormatInt(time.Now().Unix(), 10), sig: func(ts string) string {
+			return hmacsig.Sign([]byte("wrong"), hmacsig.RequestParts(http.MethodPost, "/keys/create", ts, "nonce", body)...)
+		}},
+		"stale timestamp": {ts: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10), sig: func(ts string) string {
+			return hmacsig.Sign(secret, hmacsig.RequestParts(http.MethodPost, "/keys/create", ts, "nonce", body)...)
+		}},
+		"signed for another path": {ts: strconv.FormatInt(time.Now().Unix(), 10), sig: func(ts string) string {
+			return hmacsig.Sign(secret, hmacsig.RequestParts(http.MethodPost, "/keys/has", ts, "nonce", body)...)
+		}},
+	}
+	for name, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/keys/create", bytes.NewReader(body))
+		req.Header.Set(hmacsig.HeaderTimestamp, c.ts)
+		req.Header.Set(hmacsig.HeaderNonce, "nonce")
+		req.Header.Set(hmacsig.HeaderSignature, c.sig(c.ts))
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status = %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestSignerServer_RejectsReplayedRequests(t *testing.T) {
+	secret := []byte("shared-secret")
+	hd, err := stores.NewHDKeyStore("testpass", filepath.Join(t.TempDir(), "seed.json"))
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+	s := NewSignerServer(hd, secret)
+
+	body := []byte(`{}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hmacsig.Sign(secret, hmacsig.RequestParts(http.MethodPost, "/keys/create", ts, "nonce", body)...)
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/keys/create", bytes.NewReader(body))
+		req.Header.Set(hmacsig.HeaderTimestamp, ts)
+		req.Header.Set(hmacsig.HeaderNonce, "nonce")
+		req.Header.Set(hmacsig.HeaderSignature, sig)
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", code)
+	}
+	if code := send(); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request status = %d, want 401", code)
+	}
+}
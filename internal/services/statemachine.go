@@ -5,36 +5,66 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"unit/agent/internal/models"
 	"unit/agent/internal/stores"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// number of blocks an orphaned hash is remembered for after the reorg that orphaned it
+const orphanedRetention = 128
+
 type StateMachine struct {
 	provider IChainProvider
 	accounts stores.IAccountStore
 	states   stores.IStateStore
+	fees     *FeeModel
+	broker   *Broker            // optional, receives every committed state transition
+	webhooks *WebhookDispatcher // optional, queues webhook events of committed state transitions
 
 	hotWallets       map[models.Chain]string
 	interval         time.Duration
 	minConfirmations uint64
-	minDepositWei    *big.Int
-	maxAttempts      int
+	minDeposits      map[string]*big.Int // per asset, smaller deposits are not credited on their own
+	stuckAfter       time.Duration       // unmined txs older than this are replaced with higher fees
+	retries          map[models.State]RetryPolicy
+	workers          int
+	chainLimits      map[models.Chain]int
+
+	// held while below minimum deposits are read and claimed or released, so a deposit is never claimed twice
+	minimumMu sync.Mutex
+
+	mu         sync.Mutex
+	inflight   map[string]struct{}            // deposits with a transition in flight
+	chainSlots map[models.Chain]chan struct{} // per chain semaphores for hot wallet transitions
+	paused     map[models.Chain]struct{}      // chains paused by an operator, their deposits are not transitioned
+
+	// hashes of blocks orphaned by a reorg, blocks still buffered in the publisher with these hashes are skipped
+	orphaned map[common.Hash]uint64
 }
 
-func NewStateMachine(c *ChainProvider, as stores.IAccountStore, ss stores.IStateStore, hotWallets map[models.Chain]string) (*StateMachine, error) {
+func NewStateMachine(c *ChainProvider, as stores.IAccountStore, ss stores.IStateStore, hotWallets map[models.Chain]string, fees *FeeModel) (*StateMachine, error) {
 	sm := &StateMachine{
 		provider:         c,
 		accounts:         as,
 		states:           ss,
+		fees:             fees,
 		hotWallets:       hotWallets,
 		interval:         5 * time.Second,
 		minConfirmations: 14, // Ethereum mainnet specific
-		maxAttempts:      1000,
-		minDepositWei:    new(big.Int).SetUint64(1000000000000000000), // .01
+		retries:          defaultRetryPolicies(),
+		minDeposits:      defaultMinDeposits(),
+		stuckAfter:       3 * time.Minute,
+		workers:          8,
+		chainLimits: map[models.Chain]int{
+			models.Ethereum:    1,
+			models.Hyperliquid: 1,
+		},
+		orphaned: make(map[common.Hash]uint64),
 	}
 	return sm, nil
 }
@@ -43,117 +73,529 @@ func (sm *StateMachine) Start(ctx context.Context) error {
 	ticker := time.NewTicker(sm.interval)
 	defer ticker.Stop()
 
+	jobs := make(chan *models.DepositState)
+	var wg sync.WaitGroup
+	for i := 0; i < sm.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for st := range jobs {
+				if current, ok := sm.reload(ctx, st); ok {
+					sm.processDeposit(ctx, current)
+				}
+				sm.unlockDeposit(st.ID)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 
 		case <-ticker.C:
-			now := time.Now()
+			var pending []*models.DepositState
+			if err := sm.states.Due(ctx, time.Now(), func(st *models.DepositState) error {
+				pending = append(pending, st)
+				return nil
+			}); err != nil {
+				fmt.Printf("scan error: %v\n", err)
+				continue
+			}
 
-			depositCount := 0
-			var updates []*models.DepositState
+			for _, st := range pending {
+				if sm.isPaused(st) {
+					continue
+				}
+				// a transition from a previous tick is still in flight, never run two for the same deposit
+				if !sm.tryLockDeposit(st.ID) {
+					continue
+				}
+				select {
+				case jobs <- st:
+				case <-ctx.Done():
+					sm.unlockDeposit(st.ID)
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
 
-			if err := sm.states.Scan(ctx, func(st *models.DepositState) error {
-				depositCount++
+// reload re-reads a due deposit once its lock is held. A transition, reorg or operator write committed between the Due
+// scan and the lock makes the snapshot stale, running it would replay a transition that already happened.
+func (sm *StateMachine) reload(ctx context.Context, snapshot *models.DepositState) (*models.DepositState, bool) {
+	st, err := sm.states.Get(ctx, snapshot.ID)
+	if err != nil {
+		fmt.Printf("error reloading deposit %s: %v\n", snapshot.ID, err)
+		return nil, false
+	}
+	if st.State != snapshot.State || !st.RunAt().Equal(snapshot.RunAt()) {
+		return nil, false
+	}
+	return st, true
+}
 
-				switch st.State {
-				case models.StateDone, models.StateFailed:
-					return nil
-				}
+// SetConcurrency configures the number of deposits transitioned in parallel, and per chain how many
+// transitions spending from the hot wallet may run at once. Must be called before Start.
+func (sm *StateMachine) SetConcurrency(workers int, chainLimits map[models.Chain]int) {
+	sm.workers = workers
+	sm.chainLimits = chainLimits
+	sm.chainSlots = nil
+}
 
-				if st.Attempts >= sm.maxAttempts {
-					st.State = models.StateFailed
-					st.Error = "retries exhausted"
-					st.UpdatedAt = now
-					fmt.Printf("deposit to %s retries exhausted at state %s\n", st.DepositAddr.Hex(), st.State)
-					updates = append(updates, st)
-					return nil
-				}
+// SetMinDeposits configures the smallest deposit credited per asset, in base units of the asset. Assets without a minimum
+// are always credited. Must be called before Start.
+func (sm *StateMachine) SetMinDeposits(minDeposits map[string]*big.Int) {
+	sm.minDeposits = minDeposits
+}
 
-				next, changed, err := sm.TransitionDeposit(ctx, st)
-				if err != nil {
-					st.Attempts++
-					st.Error = err.Error()
-					st.UpdatedAt = now
-					fmt.Printf("deposit to %s failed at state %s: %s, %d/%d attempts\n",
-						st.DepositAddr.Hex(), st.State, err.Error(), st.Attempts, sm.maxAttempts)
-					updates = append(updates, st)
-					return nil
-				}
-				if !changed {
-					st.UpdatedAt = now
-					updates = append(updates, st)
-					return nil
-				}
+// SetBroker publishes every committed state transition to the broker. Must be called before Start.
+func (sm *StateMachine) SetBroker(b *Broker) {
+	sm.broker = b
+}
+
+// SetWebhooks queues webhook events for every committed state transition. Must be called before Start.
+func (sm *StateMachine) SetWebhooks(d *WebhookDispatcher) {
+	sm.webhooks = d
+}
+
+// processDeposit runs a single transition for the deposit and persists the outcome
+func (sm *StateMachine) processDeposit(ctx context.Context, st *models.DepositState) {
+	now := time.Now()
+	policy := sm.retryPolicy(st.State)
 
-				st.State = next
+	if chain, ok := sm.hotWalletChain(st); ok {
+		release, err := sm.acquireChain(ctx, chain)
+		if err != nil {
+			return
+		}
+		defer release()
+	}
+
+	next, changed, err := sm.TransitionDeposit(ctx, st)
+	if err != nil {
+		st.Attempts++
+		st.Error = err.Error()
+		st.UpdatedAt = now
+		// fees exceeding the deposit are not fixed by retrying, the deposit fails right away
+		feeExceeds := errors.Is(err, ErrFeeExceedsAmount)
+		if feeExceeds || st.Attempts >= policy.MaxAttempts {
+			reason := fmt.Sprintf("retries exhausted: %s", err.Error())
+			if feeExceeds {
+				reason = fmt.Sprintf("not credited, %s", err.Error())
+			}
+			fmt.Printf("deposit to %s failed at state %s: %s\n", st.DepositAddr.Hex(), st.State, reason)
+			if abandonErr := sm.abandonUnsent(ctx, st); recoverBroadcast(st, abandonErr) {
+				// the broadcast reached the node and only its response was lost, the tx is awaited instead
+				fmt.Printf("deposit %s built tx was broadcast, awaiting it at state %s\n", st.ID, st.State)
 				st.Attempts = 0
 				st.Error = ""
-				st.UpdatedAt = now
-				fmt.Printf("deposit %s to %s transitioning to state %s\n",
-					st.TxHash, st.DepositAddr.Hex(), st.State)
-				updates = append(updates, st)
-				return nil
-			}); err != nil {
-				fmt.Printf("scan error: %v\n", err)
-				continue
-			}
-
-			for _, update := range updates {
-				if err := sm.states.Put(ctx, update); err != nil {
-					fmt.Printf("put error: %v\n", err)
+				st.NextAttemptAt = now
+				if sm.put(ctx, st) {
+					sm.publish(ctx, st)
 				}
+				return
+			} else if abandonErr != nil {
+				fmt.Printf("%v\n", abandonErr)
+			} else {
+				sm.releaseClaims(ctx, st)
 			}
+			st.State = models.StateFailed
+			st.Error = reason
+			if sm.put(ctx, st) {
+				sm.publish(ctx, st)
+			}
+			return
+		}
+		st.NextAttemptAt = now.Add(policy.Backoff(st.Attempts))
+		fmt.Printf("deposit to %s failed at state %s: %s, %d/%d attempts, retrying at %s\n",
+			st.DepositAddr.Hex(), st.State, err.Error(), st.Attempts, policy.MaxAttempts, st.NextAttemptAt.Format(time.RFC3339))
+		sm.put(ctx, st)
+		return
+	}
+	if !changed {
+		st.UpdatedAt = now
+		st.NextAttemptAt = now.Add(policy.PollInterval)
+		sm.put(ctx, st)
+		return
+	}
+
+	st.State = next
+	st.Attempts = 0
+	st.Error = ""
+	st.UpdatedAt = now
+	st.NextAttemptAt = now
+	fmt.Printf("deposit %s to %s transitioning to state %s\n",
+		st.TxHash, st.DepositAddr.Hex(), st.State)
+	if sm.put(ctx, st) {
+		sm.publish(ctx, st)
+	}
+}
+
+// unsentHotWalletTx returns the tx built from the hot wallet of `chain` that the deposit has not broadcast yet
+func unsentHotWalletTx(st *models.DepositState) (chain models.Chain, rawTx string, ok bool) {
+	switch st.State {
+	case models.StateDstTxBuilt, models.StateWithdrawalPayoutBuilt:
+		return st.DstChain, st.UnsignedDstTx, true
+	case models.StateSweepTopUpBuilt:
+		return st.SrcChain, st.UnsignedTopUpTx, true
+	}
+	return "", "", false
+}
+
+// abandonUnsent gives back the hot wallet nonce of a built tx that will never be broadcast. It fails with a
+// *TxBroadcastError if the tx already reached the node, its nonce is kept then.
+func (sm *StateMachine) abandonUnsent(ctx context.Context, st *models.DepositState) error {
+	chain, raw, ok := unsentHotWalletTx(st)
+	if !ok {
+		return nil
+	}
+	addr, err := sm.getHotWallet(chain)
+	if err != nil {
+		return err
+	}
+	if err := sm.provider.WithChain(chain).AbandonTx(ctx, raw, addr); err != nil {
+		return fmt.Errorf("error abandoning tx of deposit %s: %w", st.ID, err)
+	}
+	return nil
+}
+
+// recoverBroadcast moves a deposit whose built tx turned out to be broadcast by AbandonTx to the state awaiting the tx
+func recoverBroadcast(st *models.DepositState, err error) bool {
+	var broadcast *TxBroadcastError
+	if !errors.As(err, &broadcast) {
+		return false
+	}
+	switch st.State {
+	case models.StateDstTxBuilt:
+		dstTx(st, "").record(broadcast.Hash)
+		st.State = models.StateDstTxSent
+	case models.StateWithdrawalPayoutBuilt:
+		dstTx(st, "").record(broadcast.Hash)
+		st.State = models.StateWithdrawalPayoutSent
+	case models.StateSweepTopUpBuilt:
+		topUpTx(st, "").record(broadcast.Hash)
+		st.State = models.StateSweepTopUpSent
+	default:
+		return false
+	}
+	return true
+}
+
+// SyncNonces resyncs hot wallet nonce allocation with each chain, nonces reserved for txs that were never persisted are
+// released. Run on startup before Start.
+func (sm *StateMachine) SyncNonces(ctx context.Context) error {
+	unsent := make(map[models.Chain][]string)
+	if err := sm.states.Scan(ctx, func(st *models.DepositState) error {
+		if chain, raw, ok := unsentHotWalletTx(st); ok {
+			unsent[chain] = append(unsent[chain], raw)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for chain, addr := range sm.hotWallets {
+		if err := sm.provider.WithChain(chain).SyncNonces(ctx, addr, unsent[chain]); err != nil {
+			return fmt.Errorf("error syncing %s nonces: %w", chain, err)
+		}
+	}
+	return nil
+}
+
+// retryPolicy returns the policy for transitions out of `state`, states without their own policy use the build policy
+func (sm *StateMachine) retryPolicy(state models.State) RetryPolicy {
+	if p, ok := sm.retries[state]; ok {
+		return p
+	}
+	return buildRetryPolicy
+}
+
+func (sm *StateMachine) put(ctx context.Context, st *models.DepositState) bool {
+	if err := sm.states.Put(ctx, st); err != nil {
+		fmt.Printf("put error: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// publish hands a committed state transition to the broker and the webhook queue
+func (sm *StateMachine) publish(ctx context.Context, st *models.DepositState) {
+	if sm.broker != nil {
+		sm.broker.Publish(st)
+	}
+	if sm.webhooks != nil {
+		if err := sm.webhooks.Notify(ctx, st); err != nil {
+			fmt.Printf("error queueing webhooks of deposit %s: %v\n", st.ID, err)
+		}
+	}
+}
+
+func (sm *StateMachine) tryLockDeposit(id string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.inflight == nil {
+		sm.inflight = make(map[string]struct{})
+	}
+	if _, ok := sm.inflight[id]; ok {
+		return false
+	}
+	sm.inflight[id] = struct{}{}
+	return true
+}
+
+// lockDeposit blocks until no transition is in flight for the deposit and takes the lock
+func (sm *StateMachine) lockDeposit(ctx context.Context, id string) error {
+	for !sm.tryLockDeposit(id) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+func (sm *StateMachine) unlockDeposit(id string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.inflight, id)
+}
+
+// hotWalletChain returns the chain whose hot wallet the next transition builds or broadcasts from.
+// These transitions read and consume the hot wallet nonce, so they are limited per chain.
+func (sm *StateMachine) hotWalletChain(st *models.DepositState) (models.Chain, bool) {
+	switch st.State {
+	case models.StateSrcTxConfirmed, models.StateDstTxResend, models.StateDstTxBuilt,
+		models.StateWithdrawalDetected, models.StateWithdrawalPayoutResend, models.StateWithdrawalPayoutBuilt:
+		return st.DstChain, true
+	case models.StateSweepTopUpResend, models.StateSweepTopUpBuilt:
+		return st.SrcChain, true
+	case models.StateDstTxConfirmed:
+		if isTokenDeposit(st) {
+			return st.SrcChain, true
+		}
+	}
+	return "", false
+}
+
+func (sm *StateMachine) acquireChain(ctx context.Context, chain models.Chain) (release func(), err error) {
+	sm.mu.Lock()
+	if sm.chainSlots == nil {
+		sm.chainSlots = make(map[models.Chain]chan struct{})
+	}
+	slots, ok := sm.chainSlots[chain]
+	if !ok {
+		limit := sm.chainLimits[chain]
+		if limit <= 0 {
+			limit = 1
 		}
+		slots = make(chan struct{}, limit)
+		sm.chainSlots[chain] = slots
+	}
+	sm.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func (sm *StateMachine) ProcessBlock(ctx context.Context, block *types.Block) error {
+// ProcessBlock records the deposits of a block. It reports false if the block was skipped because a reorg orphaned it,
+// skipped blocks must not be committed.
+func (sm *StateMachine) ProcessBlock(ctx context.Context, chain models.Chain, block *types.Block) (processed bool, err error) {
+	if _, ok := sm.orphaned[block.Hash()]; ok {
+		fmt.Printf("skipping orphaned block %d, hash=%s\n", block.NumberU64(), block.Hash().Hex())
+		return false, nil
+	}
+
 	for _, tx := range block.Transactions() {
 		to := tx.To()
 		if to == nil {
 			continue
 		}
 
-		// only checks of transaction.to is a deposit address. For a more thorough check, retrieve receipts and check logs for transfer events with deposit address as `to`
-		account, err := sm.accounts.GetByDepositAddress(ctx, to.Hex())
+		// native deposits, tx.to is a deposit address
+		account, err := sm.depositAccount(ctx, *to)
 		if err != nil {
-			if errors.Is(err, stores.ErrAccountNotFound) {
-				continue
-			}
-			return err
+			return false, err
+		}
+		if account == nil {
+			continue
+		}
+		// gas top-ups for token sweeps are sent to deposit addresses from the hot wallet
+		if sm.isHotWallet(chain, tx) {
+			continue
 		}
 
-		// NOTE: no minimum deposit amount for testing
+		// deposits below the minimum are recorded as well, they are credited once the sum of deposits reaches it
 		amount := new(big.Int).Set(tx.Value())
-		if account != nil {
-			fmt.Printf("found deposit to: %s amount: %s\n", to.Hex(), amount.String())
-			deposit := &models.DepositState{
-				ID:          fmt.Sprintf("%s|%s", account.DepositAddr, tx.Hash().Hex()),
-				TxHash:      tx.Hash().Hex(),
-				DepositAddr: account.DepositAddr,
-				DstAddr:     account.DstAddr,
-				DstChain:    account.DstChain,
-				SrcChain:    account.SrcChain,
-				AmountWei:   amount,
-				State:       models.StateSrcTxDiscovered,
-				UpdatedAt:   time.Now(),
-				CreatedAt:   time.Now(),
-			}
+		fmt.Printf("found deposit to: %s amount: %s\n", to.Hex(), amount.String())
+		deposit := newDeposit(account, block, tx.Hash().Hex(), models.AssetEth, amount)
+		deposit.ID = fmt.Sprintf("%s|%s", account.DepositAddr, tx.Hash().Hex())
+		if err := sm.recordDeposit(ctx, deposit); err != nil {
+			return false, err
+		}
+	}
 
-			err := sm.states.PutIfAbsent(ctx, deposit)
-			if err != nil {
-				return err
-			}
+	// token deposits, Transfer events with a deposit address as `to`
+	transfers, err := sm.provider.WithChain(chain).TokenTransfers(ctx, block.Hash().Hex())
+	if err != nil {
+		return false, fmt.Errorf("%w: error getting token transfers: %w", ErrChainUnavailable, err)
+	}
+	for _, t := range transfers {
+		account, err := sm.depositAccount(ctx, t.To)
+		if err != nil {
+			return false, err
+		}
+		if account == nil {
+			continue
+		}
+
+		fmt.Printf("found %s deposit to: %s amount: %s\n", t.Token.Asset, t.To.Hex(), t.Amount.String())
+		deposit := newDeposit(account, block, t.TxHash, t.Token.Asset, t.Amount)
+		// a single tx can carry several transfers to the same address
+		deposit.ID = fmt.Sprintf("%s|%s|%d", account.DepositAddr, t.TxHash, t.LogIndex)
+		if err := sm.recordDeposit(ctx, deposit); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// depositAccount returns the account owning deposit address `addr` if it accepts deposits on an EVM chain, nil otherwise
+func (sm *StateMachine) depositAccount(ctx context.Context, addr common.Address) (*models.Account, error) {
+	account, err := sm.accounts.GetByDepositAddress(ctx, addr.Hex())
+	if err != nil {
+		if errors.Is(err, stores.ErrAccountNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Hyperliquid deposit addresses are funded on Hyperliquid, see ProcessTransfers
+	if account.SrcChain == models.Hyperliquid {
+		return nil, nil
+	}
+	return account, nil
+}
+
+func newDeposit(account *models.Account, block *types.Block, txHash string, asset string, amount *big.Int) *models.DepositState {
+	return &models.DepositState{
+		TxHash:      txHash,
+		BlockNumber: block.NumberU64(),
+		BlockHash:   block.Hash().Hex(),
+		DepositAddr: account.DepositAddr,
+		DstAddr:     account.DstAddr,
+		DstChain:    account.DstChain,
+		SrcChain:    account.SrcChain,
+		Asset:       asset,
+		AmountWei:   amount,
+		State:       models.StateSrcTxDiscovered,
+		UpdatedAt:   time.Now(),
+		CreatedAt:   time.Now(),
+	}
+}
+
+// recordDeposit stores a newly discovered deposit, deposits already known are left untouched
+func (sm *StateMachine) recordDeposit(ctx context.Context, deposit *models.DepositState) error {
+	// a deposit invalidated by a reorg is discovered again once its tx is included in the new canonical chain
+	existing, err := sm.states.Get(ctx, deposit.ID)
+	if err != nil && !errors.Is(err, stores.ErrExecutionNotFound) {
+		return err
+	}
+	if existing != nil && existing.State == models.StateSrcTxInvalidated {
+		deposit.CreatedAt = existing.CreatedAt
+		if err := sm.states.Put(ctx, deposit); err != nil {
+			return err
+		}
+	} else {
+		inserted, err := sm.states.PutIfAbsent(ctx, deposit)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return nil
+		}
+	}
+	sm.publish(ctx, deposit)
+
+	fmt.Printf("found deposit for address %s tx %s\n", deposit.DepositAddr, deposit.TxHash)
+	return nil
+}
+
+// HandleReorg invalidates deposits discovered in orphaned blocks that have not been confirmed yet, so they are never credited.
+func (sm *StateMachine) HandleReorg(ctx context.Context, reorg *ReorgEvent) error {
+	if sm.orphaned == nil {
+		sm.orphaned = make(map[common.Hash]uint64)
+	}
+	orphaned := make(map[string]struct{}, len(reorg.Orphaned))
+	for _, hash := range reorg.Orphaned {
+		orphaned[hash.Hex()] = struct{}{}
+		sm.orphaned[hash] = reorg.To
+	}
+	for hash, number := range sm.orphaned {
+		if number+orphanedRetention < reorg.To {
+			delete(sm.orphaned, hash)
+		}
+	}
 
-			fmt.Printf("found deposit for address %s tx %s\n", account.DepositAddr, tx.Hash().Hex())
+	var candidates []string
+	if err := sm.states.Scan(ctx, func(st *models.DepositState) error {
+		if _, ok := orphaned[st.BlockHash]; ok {
+			candidates = append(candidates, st.ID)
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, id := range candidates {
+		if err := sm.invalidateDeposit(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sm *StateMachine) invalidateDeposit(ctx context.Context, id string) error {
+	if err := sm.lockDeposit(ctx, id); err != nil {
+		return err
+	}
+	defer sm.unlockDeposit(id)
+
+	// re-read under the deposit lock, a worker may have transitioned it since the scan
+	st, err := sm.states.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if st.State != models.StateSrcTxDiscovered {
+		fmt.Printf("deposit %s in orphaned block %s already at state %s\n", st.TxHash, st.BlockHash, st.State)
+		return nil
 	}
+
+	st.State = models.StateSrcTxInvalidated
+	st.Error = fmt.Sprintf("block %d orphaned by reorg", st.BlockNumber)
+	st.UpdatedAt = time.Now()
+	if err := sm.states.Put(ctx, st); err != nil {
+		return err
+	}
+	sm.publish(ctx, st)
+	fmt.Printf("deposit %s to %s invalidated by reorg\n", st.TxHash, st.DepositAddr.Hex())
 	return nil
 }
 
 func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.DepositState) (next models.State, changed bool, err error) {
+	if st.State.IsWithdrawal() {
+		return sm.transitionWithdrawal(ctx, st)
+	}
+
 	switch st.State {
 
 	case models.StateSrcTxDiscovered:
@@ -173,11 +615,26 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 		return st.State, true, nil
 
 	case models.StateSrcTxConfirmed, models.StateDstTxResend:
+		if st.State == models.StateSrcTxConfirmed {
+			credit, err := sm.claimBelowMinimum(ctx, st)
+			if err != nil {
+				return st.State, false, err
+			}
+			if !credit {
+				fmt.Printf("deposit %s to %s below minimum, not credited\n", st.TxHash, st.DepositAddr.Hex())
+				st.State = models.StateBelowMinimum
+				return st.State, true, nil
+			}
+		}
 		addr, err := sm.getHotWallet(st.DstChain)
 		if err != nil {
 			return st.State, false, err
 		}
-		tx, err := sm.provider.WithChain(st.DstChain).BuildSendTx(ctx, addr, st.DstAddr.Hex(), st.AmountWei)
+		amount, err := sm.credit(ctx, st)
+		if err != nil {
+			return st.State, false, err
+		}
+		tx, err := sm.provider.WithChain(st.DstChain).BuildSendTx(ctx, addr, st.DstAddr.Hex(), amount)
 		if err != nil {
 			return st.State, false, fmt.Errorf("error building tx: %v", err)
 		}
@@ -190,16 +647,18 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 		if err != nil {
 			return st.State, false, err
 		}
-		hash, err := sm.provider.WithChain(st.DstChain).BroadcastTx(ctx, st.UnsignedDstTx, addr)
-		if err != nil {
-			return st.State, false, fmt.Errorf("error sending tx: %v", err)
+		if err := dstTx(st, addr).broadcast(ctx, sm); err != nil {
+			return st.State, false, err
 		}
-		st.SentDstTxHash = hash
 		st.State = models.StateDstTxSent
 		return st.State, true, nil
 
 	case models.StateDstTxSent:
-		confirmed, err := sm.provider.WithChain(st.DstChain).IsTxConfirmed(ctx, st.SentDstTxHash, sm.minConfirmations)
+		addr, err := sm.getHotWallet(st.DstChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		confirmed, err := dstTx(st, addr).await(ctx, sm)
 		if err != nil {
 			if errors.Is(err, ErrorRejectedTransaction) {
 				st.State = models.StateDstTxRejected
@@ -214,7 +673,13 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 		st.State = models.StateDstTxConfirmed
 		return st.State, true, nil
 
-	case models.StateDstTxConfirmed, models.StateSweepTxResend:
+	case models.StateDstTxConfirmed, models.StateSweepTxResend, models.StateSweepTopUpConfirmed:
+		if isTokenDeposit(st) {
+			if st.State == models.StateDstTxConfirmed {
+				return sm.buildTopUp(ctx, st)
+			}
+			return sm.buildTokenSweep(ctx, st)
+		}
 		addr, err := sm.getHotWallet(st.SrcChain)
 		if err != nil {
 			return st.State, false, err
@@ -228,16 +693,14 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 		return st.State, true, nil
 
 	case models.StateSweepTxBuilt:
-		hash, err := sm.provider.WithChain(st.SrcChain).BroadcastTx(ctx, st.UnsignedSweepTx, st.DepositAddr.Hex())
-		if err != nil {
-			return st.State, false, fmt.Errorf("error sending tx: %v", err)
+		if err := sweepTx(st).broadcast(ctx, sm); err != nil {
+			return st.State, false, err
 		}
-		st.SentSweepTxHash = hash
 		st.State = models.StateSweepTxSent
 		return st.State, true, nil
 
 	case models.StateSweepTxSent:
-		confirmed, err := sm.provider.WithChain(st.SrcChain).IsTxConfirmed(ctx, st.SentSweepTxHash, sm.minConfirmations)
+		confirmed, err := sweepTx(st).await(ctx, sm)
 		if err != nil {
 			if errors.Is(err, ErrorRejectedTransaction) {
 				st.State = models.StateSweepTxRejected
@@ -256,7 +719,28 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 		st.State = models.StateDone
 		return st.State, true, nil
 
-	case models.StateDone, models.StateFailed:
+	case models.StateSweepTopUpResend:
+		return sm.buildTopUp(ctx, st)
+
+	case models.StateSweepTopUpBuilt:
+		addr, err := sm.getHotWallet(st.SrcChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		if err := topUpTx(st, addr).broadcast(ctx, sm); err != nil {
+			return st.State, false, err
+		}
+		st.State = models.StateSweepTopUpSent
+		return st.State, true, nil
+
+	case models.StateSweepTopUpSent:
+		return sm.awaitTopUp(ctx, st)
+
+	case models.StateSweepTopUpRejected:
+		st.State = models.StateSweepTopUpResend
+		return st.State, true, nil
+
+	case models.StateDone, models.StateFailed, models.StateSrcTxInvalidated, models.StateCanceled:
 		return st.State, false, nil
 
 	case models.StateDstTxRejected:
@@ -272,6 +756,19 @@ func (sm *StateMachine) TransitionDeposit(ctx context.Context, st *models.Deposi
 	}
 }
 
+// isHotWallet reports whether `tx` was sent from the hot wallet of `chain`
+func (sm *StateMachine) isHotWallet(chain models.Chain, tx *types.Transaction) bool {
+	addr, ok := sm.hotWallets[chain]
+	if !ok {
+		return false
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return false
+	}
+	return from == common.HexToAddress(addr)
+}
+
 func (sm *StateMachine) getHotWallet(chain models.Chain) (string, error) {
 	address, ok := sm.hotWallets[chain]
 	if !ok {
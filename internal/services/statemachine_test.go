@@ -3,7 +3,13 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,8 +24,23 @@ import (
 type mockChainCtx struct {
 	buildSendTxFn   func(ctx context.Context, from, to string, amount *big.Int) (string, error)
 	buildSweepTxFn  func(ctx context.Context, from, to string) (string, error)
+	buildTopUpTxFn  func(ctx context.Context, from, to, asset string) (string, *big.Int, error)
+	tokenSweepTxFn  func(ctx context.Context, from, to, asset string) (string, error)
 	broadcastTxFn   func(ctx context.Context, rawTx, fromAddr string) (string, error)
 	isTxConfirmedFn func(ctx context.Context, txHash string, minConf uint64) (bool, error)
+	bumpTxFn        func(ctx context.Context, rawTx, fromAddr string, sweep bool) (string, error)
+	abandonTxFn     func(ctx context.Context, rawTx, fromAddr string) error
+	syncNoncesFn    func(ctx context.Context, fromAddr string, unsent []string) error
+	gasCost         *big.Int
+	transfers       []TokenTransfer
+	transfersErr    error
+}
+
+func (m *mockChainCtx) EstimateGasCost(ctx context.Context, asset string) (*big.Int, error) {
+	if m.gasCost == nil {
+		return new(big.Int), nil
+	}
+	return new(big.Int).Set(m.gasCost), nil
 }
 
 func (m *mockChainCtx) BroadcastTx(ctx context.Context, rawTx string, fromAddr string) (string, error) {
@@ -31,9 +52,36 @@ func (m *mockChainCtx) BuildSendTx(ctx context.Context, fromAddr string, toAddr
 func (m *mockChainCtx) BuildSweepTx(ctx context.Context, fromAddr string, toAddr string) (string, error) {
 	return m.buildSweepTxFn(ctx, fromAddr, toAddr)
 }
+func (m *mockChainCtx) BuildTopUpTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, *big.Int, error) {
+	return m.buildTopUpTxFn(ctx, fromAddr, toAddr, asset)
+}
+func (m *mockChainCtx) BuildTokenSweepTx(ctx context.Context, fromAddr string, toAddr string, asset string) (string, error) {
+	return m.tokenSweepTxFn(ctx, fromAddr, toAddr, asset)
+}
 func (m *mockChainCtx) IsTxConfirmed(ctx context.Context, txHash string, minConfirmations uint64) (bool, error) {
 	return m.isTxConfirmedFn(ctx, txHash, minConfirmations)
 }
+func (m *mockChainCtx) BumpTx(ctx context.Context, rawTx string, fromAddr string, sweep bool) (string, error) {
+	if m.bumpTxFn == nil {
+		return "", ErrBumpNotSupported
+	}
+	return m.bumpTxFn(ctx, rawTx, fromAddr, sweep)
+}
+func (m *mockChainCtx) AbandonTx(ctx context.Context, rawTx string, fromAddr string) error {
+	if m.abandonTxFn == nil {
+		return nil
+	}
+	return m.abandonTxFn(ctx, rawTx, fromAddr)
+}
+func (m *mockChainCtx) TokenTransfers(ctx context.Context, blockHash string) ([]TokenTransfer, error) {
+	return m.transfers, m.transfersErr
+}
+func (m *mockChainCtx) SyncNonces(ctx context.Context, fromAddr string, unsent []string) error {
+	if m.syncNoncesFn == nil {
+		return nil
+	}
+	return m.syncNoncesFn(ctx, fromAddr, unsent)
+}
 
 type mockChainProvider struct {
 	byChain map[models.Chain]*mockChainCtx
@@ -43,8 +91,15 @@ func (m *mockChainProvider) WithChain(chain models.Chain) ChainCtx {
 	return m.byChain[chain]
 }
 
+// newBlockProvider returns a provider whose Ethereum blocks contain the given token transfers
+func newBlockProvider(transfers []TokenTransfer) *mockChainProvider {
+	return &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: {transfers: transfers}}}
+}
+
 type mockStateStore struct {
+	mu      sync.Mutex
 	items   map[string]*models.DepositState
+	events  map[string][]models.DepositEvent
 	putCh   chan *models.DepositState
 	putIfCh chan *models.DepositState
 }
@@ -52,13 +107,17 @@ type mockStateStore struct {
 func newMockStateStore() *mockStateStore {
 	return &mockStateStore{
 		items:   make(map[string]*models.DepositState),
+		events:  make(map[string][]models.DepositEvent),
 		putCh:   make(chan *models.DepositState, 10),
 		putIfCh: make(chan *models.DepositState, 10),
 	}
 }
 
-func (f *mockStateStore) PutIfAbsent(ctx context.Context, state *models.DepositState) error {
-	if _, ok := f.items[state.ID]; !ok {
+func (f *mockStateStore) PutIfAbsent(ctx context.Context, state *models.DepositState) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.items[state.ID]
+	if !exists {
 		cp := *state
 		f.items[state.ID] = &cp
 	}
@@ -66,10 +125,20 @@ func (f *mockStateStore) PutIfAbsent(ctx context.Context, state *models.DepositS
 	case f.putIfCh <- state:
 	default:
 	}
-	return nil
+	return !exists, nil
 }
 
 func (f *mockStateStore) Put(ctx context.Context, state *models.DepositState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	event, ok, err := models.NewDepositEvent(f.items[state.ID], state)
+	if err != nil {
+		return err
+	}
+	if ok {
+		event.Seq = uint64(len(f.events[state.ID]) + 1)
+		f.events[state.ID] = append(f.events[state.ID], event)
+	}
 	cp := *state
 	f.items[state.ID] = &cp
 	select {
@@ -80,6 +149,8 @@ func (f *mockStateStore) Put(ctx context.Context, state *models.DepositState) er
 }
 
 func (f *mockStateStore) Get(ctx context.Context, id string) (*models.DepositState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if v, ok := f.items[id]; ok {
 		cp := *v
 		return &cp, nil
@@ -88,6 +159,8 @@ func (f *mockStateStore) Get(ctx context.Context, id string) (*models.DepositSta
 }
 
 func (f *mockStateStore) Scan(ctx context.Context, visit func(*models.DepositState) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	for _, v := range f.items {
 		select {
 		case <-ctx.Done():
@@ -102,6 +175,65 @@ func (f *mockStateStore) Scan(ctx context.Context, visit func(*models.DepositSta
 	return nil
 }
 
+func (f *mockStateStore) Due(ctx context.Context, now time.Time, visit func(*models.DepositState) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, v := range f.items {
+		if v.State.IsTerminal() || v.RunAt().After(now) {
+			continue
+		}
+		cp := *v
+		if err := visit(&cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *mockStateStore) Events(ctx context.Context, id string) ([]models.DepositEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.events[id]; ok {
+		return v, nil
+	}
+	return nil, stores.ErrExecutionNotFound
+}
+
+func (f *mockStateStore) BelowMinimum(ctx context.Context, depositAddr common.Address, asset string, visit func(*models.DepositState) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, v := range f.items {
+		if v.State != models.StateBelowMinimum || v.DepositAddr != depositAddr || v.Asset != asset {
+			continue
+		}
+		cp := *v
+		if err := visit(&cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *mockStateStore) List(ctx context.Context, filter stores.DepositFilter, after string, limit int) ([]*models.DepositState, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deposits []*models.DepositState
+	for _, id := range slices.Sorted(maps.Keys(f.items)) {
+		if id <= after || !filter.Match(f.items[id]) {
+			continue
+		}
+		if len(deposits) == limit {
+			break
+		}
+		cp := *f.items[id]
+		deposits = append(deposits, &cp)
+	}
+	if len(deposits) < limit {
+		return deposits, "", nil
+	}
+	return deposits, deposits[len(deposits)-1].ID, nil
+}
+
 func (f *mockStateStore) Close() error { return nil }
 
 type mockTrieHasher struct{}
@@ -116,13 +248,23 @@ func newStateMachineForTest(t *testing.T, provider *mockChainProvider) *StateMac
 		models.Ethereum:    "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
 		models.Hyperliquid: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
 	}
-	sm, err := NewStateMachine((*ChainProvider)(nil), nil, nil, hot)
+	// 0.01 ETH = 10 USDC
+	oracle, err := NewStaticOracle(map[string]string{"eth/usdc": "1000"})
+	if err != nil {
+		t.Fatalf("NewStaticOracle: %v", err)
+	}
+	sm, err := NewStateMachine((*ChainProvider)(nil), nil, nil, hot, NewFeeModel(provider, oracle, models.FeeSchedule{}))
 	if err != nil {
 		t.Fatalf("NewStateMachine: %v", err)
 	}
 	sm.provider = provider
 	sm.interval = 1 * time.Millisecond
 	sm.minConfirmations = 1
+	// deposits of any amount are credited unless a test configures minimums
+	sm.minDeposits = nil
+	for state := range sm.retries {
+		sm.retries[state] = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxAttempts: 1000}
+	}
 	return sm
 }
 
@@ -327,6 +469,7 @@ func TestStateMachine_ProcessBlock_InsertsDepositStateForMatchingTx(t *testing.T
 	mstates := newMockStateStore()
 
 	sm := &StateMachine{
+		provider: newBlockProvider(nil),
 		states:   mstates,
 		accounts: maccounts,
 	}
@@ -334,7 +477,7 @@ func TestStateMachine_ProcessBlock_InsertsDepositStateForMatchingTx(t *testing.T
 	to := depAddr
 	tx := types.NewTransaction(0, to, big.NewInt(100), 21000, big.NewInt(1), nil)
 	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, &types.Body{Transactions: types.Transactions{tx}}, nil, new(mockTrieHasher))
-	if err := sm.ProcessBlock(context.Background(), block); err != nil {
+	if _, err := sm.ProcessBlock(context.Background(), models.Ethereum, block); err != nil {
 		t.Fatalf("ProcessBlock error: %v", err)
 	}
 
@@ -363,13 +506,13 @@ func TestStateMachine_ProcessBlock_InsertsDepositStateForMatchingTx(t *testing.T
 func TestStateMachine_ProcessBlock_SkipsUnknownDepositAddress(t *testing.T) {
 	maccounts := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{}}
 	mstates := newMockStateStore()
-	sm := &StateMachine{states: mstates, accounts: maccounts}
+	sm := &StateMachine{provider: newBlockProvider(nil), states: mstates, accounts: maccounts}
 
 	to := common.HexToAddress("0x3333333333333333333333333333333333333333")
 	tx := types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil)
 	block := types.NewBlock(&types.Header{Number: big.NewInt(3)}, &types.Body{Transactions: types.Transactions{tx}}, nil, new(mockTrieHasher))
 
-	if err := sm.ProcessBlock(context.Background(), block); err != nil {
+	if _, err := sm.ProcessBlock(context.Background(), models.Ethereum, block); err != nil {
 		t.Fatalf("ProcessBlock error: %v", err)
 	}
 
@@ -379,3 +522,418 @@ func TestStateMachine_ProcessBlock_SkipsUnknownDepositAddress(t *testing.T) {
 	default:
 	}
 }
+
+func TestStateMachine_HandleReorg_InvalidatesDiscoveredDeposits(t *testing.T) {
+	mstates := newMockStateStore()
+	sm := &StateMachine{states: mstates}
+	ctx := context.Background()
+
+	orphanedHash := common.HexToHash("0x01")
+	mstates.items["discovered"] = &models.DepositState{ID: "discovered", State: models.StateSrcTxDiscovered, BlockHash: orphanedHash.Hex()}
+	mstates.items["confirmed"] = &models.DepositState{ID: "confirmed", State: models.StateSrcTxConfirmed, BlockHash: orphanedHash.Hex()}
+	mstates.items["canonical"] = &models.DepositState{ID: "canonical", State: models.StateSrcTxDiscovered, BlockHash: common.HexToHash("0x02").Hex()}
+
+	err := sm.HandleReorg(ctx, &ReorgEvent{Chain: models.Ethereum, From: 10, To: 10, Orphaned: []common.Hash{orphanedHash}})
+	if err != nil {
+		t.Fatalf("HandleReorg error: %v", err)
+	}
+
+	if got := mstates.items["discovered"].State; got != models.StateSrcTxInvalidated {
+		t.Fatalf("discovered state = %s, want %s", got, models.StateSrcTxInvalidated)
+	}
+	if got := mstates.items["confirmed"].State; got != models.StateSrcTxConfirmed {
+		t.Fatalf("confirmed state = %s, want unchanged", got)
+	}
+	if got := mstates.items["canonical"].State; got != models.StateSrcTxDiscovered {
+		t.Fatalf("canonical state = %s, want unchanged", got)
+	}
+}
+
+func TestStateMachine_ProcessBlock_SkipsOrphanedBlockAndRediscovers(t *testing.T) {
+	depAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	maccounts := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{
+		depAddr.Hex(): {ID: "acct-1", DepositAddr: depAddr},
+	}}
+	mstates := newMockStateStore()
+	sm := &StateMachine{provider: newBlockProvider(nil), states: mstates, accounts: maccounts}
+	ctx := context.Background()
+
+	tx := types.NewTransaction(0, depAddr, big.NewInt(100), 21000, big.NewInt(1), nil)
+	orphan := types.NewBlock(&types.Header{Number: big.NewInt(5)}, &types.Body{Transactions: types.Transactions{tx}}, nil, new(mockTrieHasher))
+	if _, err := sm.ProcessBlock(ctx, models.Ethereum, orphan); err != nil {
+		t.Fatalf("ProcessBlock error: %v", err)
+	}
+	if err := sm.HandleReorg(ctx, &ReorgEvent{From: 5, To: 5, Orphaned: []common.Hash{orphan.Hash()}}); err != nil {
+		t.Fatalf("HandleReorg error: %v", err)
+	}
+
+	id := fmt.Sprintf("%s|%s", depAddr, tx.Hash().Hex())
+	if got := mstates.items[id].State; got != models.StateSrcTxInvalidated {
+		t.Fatalf("state = %s, want %s", got, models.StateSrcTxInvalidated)
+	}
+
+	// a late copy of the orphaned block must not revive the deposit, nor be committed
+	if processed, err := sm.ProcessBlock(ctx, models.Ethereum, orphan); err != nil || processed {
+		t.Fatalf("ProcessBlock = %v, %v, want skipped", processed, err)
+	}
+	if got := mstates.items[id].State; got != models.StateSrcTxInvalidated {
+		t.Fatalf("state = %s, want %s", got, models.StateSrcTxInvalidated)
+	}
+
+	// same tx included in the new canonical block
+	canonical := types.NewBlock(&types.Header{Number: big.NewInt(5), Extra: []byte("canonical")}, &types.Body{Transactions: types.Transactions{tx}}, nil, new(mockTrieHasher))
+	if _, err := sm.ProcessBlock(ctx, models.Ethereum, canonical); err != nil {
+		t.Fatalf("ProcessBlock error: %v", err)
+	}
+	got := mstates.items[id]
+	if got.State != models.StateSrcTxDiscovered || got.BlockHash != canonical.Hash().Hex() {
+		t.Fatalf("got state %s block %s, want %s in %s", got.State, got.BlockHash, models.StateSrcTxDiscovered, canonical.Hash().Hex())
+	}
+}
+
+// get returns a copy of the stored deposit, safe to call while the state machine is running
+func (f *mockStateStore) get(id string) *models.DepositState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.items[id]; ok {
+		cp := *v
+		return &cp
+	}
+	return nil
+}
+
+func waitForState(t *testing.T, store *mockStateStore, id string, want models.State) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		if st := store.get(id); st != nil && st.State == want {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timeout waiting for %s to reach %s, got %+v", id, want, store.get(id))
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestStateMachine_Start_SlowDepositDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			if txHash == "0xslow" {
+				<-release
+			}
+			return true, nil
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+
+	mstates.items["slow"] = &models.DepositState{ID: "slow", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xslow"}
+	mstates.items["fast"] = &models.DepositState{ID: "fast", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xfast"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sm.Start(ctx)
+
+	waitForState(t, mstates, "fast", models.StateSrcTxConfirmed)
+	if got := mstates.get("slow").State; got != models.StateSrcTxDiscovered {
+		t.Fatalf("slow state = %s, want %s", got, models.StateSrcTxDiscovered)
+	}
+}
+
+func TestStateMachine_Start_SingleTransitionPerDeposit(t *testing.T) {
+	var inflight, maxInflight atomic.Int32
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			n := inflight.Add(1)
+			defer inflight.Add(-1)
+			for {
+				m := maxInflight.Load()
+				if n <= m || maxInflight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond) // spans several ticks
+			return false, nil
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+	mstates.items["dep"] = &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xsrc"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = sm.Start(ctx)
+
+	if got := maxInflight.Load(); got != 1 {
+		t.Fatalf("max concurrent transitions for one deposit = %d, want 1", got)
+	}
+}
+
+func TestStateMachine_reload_SkipsStaleSnapshots(t *testing.T) {
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	mstates := newMockStateStore()
+	sm.states = mstates
+	ctx := context.Background()
+
+	now := time.Now()
+	mstates.items["dep"] = &models.DepositState{ID: "dep", State: models.StateDstTxBuilt, NextAttemptAt: now}
+	snapshot := mstates.get("dep")
+	if _, ok := sm.reload(ctx, snapshot); !ok {
+		t.Fatal("unchanged deposit skipped")
+	}
+
+	// a transition committed after the snapshot was taken
+	mstates.items["dep"] = &models.DepositState{ID: "dep", State: models.StateDstTxSent, NextAttemptAt: now}
+	if _, ok := sm.reload(ctx, snapshot); ok {
+		t.Fatal("deposit moved to another state was not skipped")
+	}
+
+	// rescheduled in the same state, e.g. by a retry that failed in the meantime
+	mstates.items["dep"] = &models.DepositState{ID: "dep", State: models.StateDstTxBuilt, NextAttemptAt: now.Add(time.Minute)}
+	if _, ok := sm.reload(ctx, snapshot); ok {
+		t.Fatal("rescheduled deposit was not skipped")
+	}
+}
+
+func TestStateMachine_Start_RespectsChainLimit(t *testing.T) {
+	var inflight, maxInflight atomic.Int32
+	dstCtx := &mockChainCtx{
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			n := inflight.Add(1)
+			defer inflight.Add(-1)
+			for {
+				m := maxInflight.Load()
+				if n <= m || maxInflight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return "raw_dst", nil
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) {
+			return "", errors.New("broadcast disabled")
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}}
+	sm := newStateMachineForTest(t, wm)
+	sm.SetConcurrency(4, map[models.Chain]int{models.Hyperliquid: 1})
+	mstates := newMockStateStore()
+	sm.states = mstates
+
+	for _, id := range []string{"a", "b", "c"} {
+		mstates.items[id] = &models.DepositState{ID: id, State: models.StateSrcTxConfirmed, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, AmountWei: big.NewInt(1)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sm.Start(ctx)
+
+	for _, id := range []string{"a", "b", "c"} {
+		waitForState(t, mstates, id, models.StateDstTxBuilt)
+	}
+	if got := maxInflight.Load(); got != 1 {
+		t.Fatalf("max concurrent hot wallet builds = %d, want 1", got)
+	}
+}
+
+func TestStateMachine_processDeposit_BacksOffOnError(t *testing.T) {
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			return false, errors.New("rpc down")
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+	sm.retries[models.StateSrcTxDiscovered] = RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAttempts: 3}
+
+	st := &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xsrc"}
+	ctx := context.Background()
+
+	before := time.Now()
+	sm.processDeposit(ctx, st)
+	got := mstates.get("dep")
+	if got.Attempts != 1 || got.State != models.StateSrcTxDiscovered {
+		t.Fatalf("got attempts=%d state=%s, want 1 %s", got.Attempts, got.State, models.StateSrcTxDiscovered)
+	}
+	if got.NextAttemptAt.Before(before.Add(30*time.Second)) || got.NextAttemptAt.After(before.Add(time.Minute+time.Second)) {
+		t.Fatalf("NextAttemptAt = %s, want within [30s, 1m] of %s", got.NextAttemptAt, before)
+	}
+
+	sm.processDeposit(ctx, got)
+	got = mstates.get("dep")
+	sm.processDeposit(ctx, got)
+	got = mstates.get("dep")
+	if got.State != models.StateFailed || !strings.Contains(got.Error, "retries exhausted") {
+		t.Fatalf("got state=%s error=%q, want FAILED with retries exhausted", got.State, got.Error)
+	}
+}
+
+func TestStateMachine_processDeposit_PollsWhileWaiting(t *testing.T) {
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return false, nil },
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+	sm.retries[models.StateSrcTxDiscovered] = RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second, MaxAttempts: 3, PollInterval: 12 * time.Second}
+
+	before := time.Now()
+	sm.processDeposit(context.Background(), &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered, SrcChain: models.Ethereum, TxHash: "0xsrc"})
+
+	got := mstates.get("dep")
+	if got.Attempts != 0 {
+		t.Fatalf("Attempts = %d, want 0 while waiting", got.Attempts)
+	}
+	if got.NextAttemptAt.Before(before.Add(12 * time.Second)) {
+		t.Fatalf("NextAttemptAt = %s, want >= 12s after %s", got.NextAttemptAt, before)
+	}
+}
+
+func TestStateMachine_processDeposit_AbandonsUnsentTxWhenExhausted(t *testing.T) {
+	var abandoned, abandonedFrom string
+	dstCtx := &mockChainCtx{
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) { return "", errors.New("rpc down") },
+		abandonTxFn: func(ctx context.Context, raw, from string) error {
+			abandoned, abandonedFrom = raw, from
+			return nil
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+	sm.retries[models.StateDstTxBuilt] = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxAttempts: 1}
+
+	sm.processDeposit(context.Background(), &models.DepositState{
+		ID: "dep", State: models.StateDstTxBuilt, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+	})
+
+	if got := mstates.get("dep"); got.State != models.StateFailed {
+		t.Fatalf("state = %s, want FAILED", got.State)
+	}
+	if abandoned != "raw_dst" || abandonedFrom != sm.hotWallets[models.Hyperliquid] {
+		t.Fatalf("abandoned %q from %q", abandoned, abandonedFrom)
+	}
+}
+
+func TestStateMachine_processDeposit_AwaitsBuiltTxThatWasBroadcast(t *testing.T) {
+	dstCtx := &mockChainCtx{
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) { return "", errors.New("already known") },
+		abandonTxFn: func(ctx context.Context, raw, from string) error {
+			return &TxBroadcastError{Hash: "0xdst"}
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Hyperliquid: dstCtx}})
+	mstates := newMockStateStore()
+	sm.states = mstates
+	sm.retries[models.StateDstTxBuilt] = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxAttempts: 1}
+
+	sm.processDeposit(context.Background(), &models.DepositState{
+		ID: "dep", State: models.StateDstTxBuilt, SrcChain: models.Ethereum, DstChain: models.Hyperliquid, UnsignedDstTx: "raw_dst",
+	})
+
+	got := mstates.get("dep")
+	if got.State != models.StateDstTxSent || got.SentDstTxHash != "0xdst" || len(got.DstTxHashes) != 1 {
+		t.Fatalf("state = %s, sent %q %v, want DST_TX_SENT awaiting 0xdst", got.State, got.SentDstTxHash, got.DstTxHashes)
+	}
+}
+
+func TestStateMachine_SyncNonces_PassesUnsentTxsPerChain(t *testing.T) {
+	synced := map[models.Chain][]string{}
+	newCtx := func(chain models.Chain) *mockChainCtx {
+		return &mockChainCtx{syncNoncesFn: func(ctx context.Context, from string, unsent []string) error {
+			synced[chain] = unsent
+			return nil
+		}}
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{
+		models.Ethereum:    newCtx(models.Ethereum),
+		models.Hyperliquid: newCtx(models.Hyperliquid),
+	}}
+	sm := newStateMachineForTest(t, wm)
+	mstates := newMockStateStore()
+	sm.states = mstates
+	mstates.items["built"] = &models.DepositState{ID: "built", State: models.StateWithdrawalPayoutBuilt, DstChain: models.Ethereum, UnsignedDstTx: "raw_payout"}
+	mstates.items["sent"] = &models.DepositState{ID: "sent", State: models.StateWithdrawalPayoutSent, DstChain: models.Ethereum, UnsignedDstTx: "raw_sent"}
+
+	if err := sm.SyncNonces(context.Background()); err != nil {
+		t.Fatalf("SyncNonces: %v", err)
+	}
+	if got := synced[models.Ethereum]; len(got) != 1 || got[0] != "raw_payout" {
+		t.Fatalf("ethereum unsent = %v, want [raw_payout]", got)
+	}
+	if _, ok := synced[models.Hyperliquid]; !ok {
+		t.Fatalf("hyperliquid hot wallet not synced")
+	}
+}
+
+func TestStateMachine_ProcessBlock_RecordsTokenDeposits(t *testing.T) {
+	depAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	account := &models.Account{
+		ID:          "acct-1",
+		DepositAddr: depAddr,
+		DstAddr:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		SrcChain:    models.Ethereum,
+		DstChain:    models.Hyperliquid,
+	}
+	maccounts := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{depAddr.Hex(): account}}
+	mstates := newMockStateStore()
+
+	usdc := models.TokensOn(models.Ethereum)[0]
+	sm := &StateMachine{
+		provider: newBlockProvider([]TokenTransfer{
+			{Token: usdc, TxHash: "0xtoken", LogIndex: 3, To: depAddr, Amount: big.NewInt(25_000_000)},
+			{Token: usdc, TxHash: "0xtoken", LogIndex: 4, To: depAddr, Amount: big.NewInt(1_000_000)},
+			{Token: usdc, TxHash: "0xother", LogIndex: 0, To: common.HexToAddress("0x3333333333333333333333333333333333333333"), Amount: big.NewInt(1)},
+		}),
+		states:   mstates,
+		accounts: maccounts,
+	}
+
+	tx := types.NewTransaction(0, depAddr, big.NewInt(100), 21000, big.NewInt(1), nil)
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, &types.Body{Transactions: types.Transactions{tx}}, nil, new(mockTrieHasher))
+	if _, err := sm.ProcessBlock(context.Background(), models.Ethereum, block); err != nil {
+		t.Fatalf("ProcessBlock error: %v", err)
+	}
+
+	if len(mstates.items) != 3 {
+		t.Fatalf("expected 3 deposits, got %d", len(mstates.items))
+	}
+	native := mstates.get(fmt.Sprintf("%s|%s", depAddr, tx.Hash().Hex()))
+	if native == nil || native.Asset != models.AssetEth || native.AmountWei.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("unexpected native deposit %+v", native)
+	}
+	token := mstates.get(fmt.Sprintf("%s|0xtoken|3", depAddr))
+	if token == nil || token.Asset != models.AssetUsdc || token.AmountWei.Cmp(big.NewInt(25_000_000)) != 0 {
+		t.Fatalf("unexpected token deposit %+v", token)
+	}
+	if token.State != models.StateSrcTxDiscovered || token.TxHash != "0xtoken" || token.BlockHash != block.Hash().Hex() {
+		t.Fatalf("unexpected token deposit state=%s tx=%s block=%s", token.State, token.TxHash, token.BlockHash)
+	}
+	if mstates.get(fmt.Sprintf("%s|0xtoken|4", depAddr)) == nil {
+		t.Fatalf("second transfer in the same tx not recorded")
+	}
+}
+
+func TestStateMachine_ProcessBlock_ChainErrorsAreRetryable(t *testing.T) {
+	provider := newBlockProvider(nil)
+	provider.byChain[models.Ethereum].transfersErr = errors.New("connection reset")
+	sm := &StateMachine{provider: provider, states: newMockStateStore(), accounts: &mocks.MockAccountStore{}}
+
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, &types.Body{}, nil, new(mockTrieHasher))
+	if _, err := sm.ProcessBlock(context.Background(), models.Ethereum, block); !errors.Is(err, ErrChainUnavailable) {
+		t.Fatalf("err = %v, want ErrChainUnavailable", err)
+	}
+}
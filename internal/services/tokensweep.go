@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"unit/agent/internal/models"
+)
+
+// ERC-20 deposit addresses hold no native balance to pay for their sweep. Once the deposit is credited the hot wallet
+// tops the address up with exactly the gas of one token transfer, and the token balance is swept once the top-up confirms.
+
+// isTokenDeposit reports whether the deposit was made in an ERC-20 token of the source chain
+func isTokenDeposit(st *models.DepositState) bool {
+	_, ok := models.TokenByAsset(st.SrcChain, st.Asset)
+	return ok
+}
+
+func topUpTx(st *models.DepositState, from string) *sentTx {
+	return &sentTx{chain: st.SrcChain, from: from, raw: &st.UnsignedTopUpTx, hash: &st.SentTopUpTxHash, hashes: &st.TopUpTxHashes, sentAt: &st.TopUpTxSentAt}
+}
+
+func (sm *StateMachine) buildTopUp(ctx context.Context, st *models.DepositState) (models.State, bool, error) {
+	addr, err := sm.getHotWallet(st.SrcChain)
+	if err != nil {
+		return st.State, false, err
+	}
+	tx, amount, err := sm.provider.WithChain(st.SrcChain).BuildTopUpTx(ctx, addr, st.DepositAddr.Hex(), st.Asset)
+	if err != nil {
+		return st.State, false, fmt.Errorf("error building top-up tx: %v", err)
+	}
+	if tx == "" {
+		// the deposit address already holds enough to pay for the sweep
+		st.State = models.StateSweepTopUpConfirmed
+		return st.State, true, nil
+	}
+	st.UnsignedTopUpTx = tx
+	st.TopUpWei = amount
+	st.State = models.StateSweepTopUpBuilt
+	return st.State, true, nil
+}
+
+func (sm *StateMachine) awaitTopUp(ctx context.Context, st *models.DepositState) (models.State, bool, error) {
+	addr, err := sm.getHotWallet(st.SrcChain)
+	if err != nil {
+		return st.State, false, err
+	}
+	confirmed, err := topUpTx(st, addr).await(ctx, sm)
+	if err != nil {
+		if errors.Is(err, ErrorRejectedTransaction) {
+			st.State = models.StateSweepTopUpRejected
+			return st.State, true, nil
+		}
+		return st.State, false, fmt.Errorf("error getting confirmation status %v", err)
+	}
+	if !confirmed {
+		fmt.Printf("waiting for confirmations: %s\n", st.SentTopUpTxHash)
+		return st.State, false, nil
+	}
+
+	if st.TotalTopUpWei == nil {
+		st.TotalTopUpWei = new(big.Int)
+	}
+	if st.TopUpWei != nil {
+		st.TotalTopUpWei = new(big.Int).Add(st.TotalTopUpWei, st.TopUpWei)
+	}
+	st.State = models.StateSweepTopUpConfirmed
+	return st.State, true, nil
+}
+
+func (sm *StateMachine) buildTokenSweep(ctx context.Context, st *models.DepositState) (models.State, bool, error) {
+	addr, err := sm.getHotWallet(st.SrcChain)
+	if err != nil {
+		return st.State, false, err
+	}
+	tx, err := sm.provider.WithChain(st.SrcChain).BuildTokenSweepTx(ctx, st.DepositAddr.Hex(), addr, st.Asset)
+	if err != nil {
+		if errors.Is(err, ErrInsufficientGas) {
+			// fees rose since the top-up, fund the difference
+			fmt.Printf("deposit %s needs another top-up: %v\n", st.ID, err)
+			st.State = models.StateSweepTopUpResend
+			return st.State, true, nil
+		}
+		return st.State, false, err
+	}
+	st.UnsignedSweepTx = tx
+	st.State = models.StateSweepTxBuilt
+	return st.State, true, nil
+}
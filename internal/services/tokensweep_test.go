@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"unit/agent/internal/mocks"
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTokenDeposit(state models.State) *models.DepositState {
+	return &models.DepositState{
+		ID:          "token-1",
+		State:       state,
+		SrcChain:    models.Ethereum,
+		DstChain:    models.Hyperliquid,
+		TxHash:      "0xsrc",
+		Asset:       models.AssetUsdc,
+		DepositAddr: common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		DstAddr:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		AmountWei:   big.NewInt(25_000_000),
+	}
+}
+
+func TestTransitionDeposit_TokenSweepWithTopUp(t *testing.T) {
+	var topUpFrom, sweepFrom, sweepTo string
+	srcCtx := &mockChainCtx{
+		buildTopUpTxFn: func(ctx context.Context, from, to, asset string) (string, *big.Int, error) {
+			topUpFrom = from
+			return "raw_topup", big.NewInt(1_260_000), nil
+		},
+		tokenSweepTxFn: func(ctx context.Context, from, to, asset string) (string, error) {
+			sweepFrom, sweepTo = from, to
+			return "raw_sweep", nil
+		},
+		buildSweepTxFn: func(ctx context.Context, from, to string) (string, error) {
+			t.Fatalf("native sweep built for token deposit")
+			return "", nil
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) {
+			return "0x" + raw, nil
+		},
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	st := newTokenDeposit(models.StateDstTxConfirmed)
+
+	if chain, ok := sm.hotWalletChain(st); !ok || chain != models.Ethereum {
+		t.Fatalf("top-up build not limited to the source chain hot wallet: %s %v", chain, ok)
+	}
+
+	ctx := context.Background()
+	want := []models.State{
+		models.StateSweepTopUpBuilt,
+		models.StateSweepTopUpSent,
+		models.StateSweepTopUpConfirmed,
+		models.StateSweepTxBuilt,
+		models.StateSweepTxSent,
+		models.StateSweepTxConfirmed,
+		models.StateDone,
+	}
+	for _, w := range want {
+		next, changed, err := sm.TransitionDeposit(ctx, st)
+		if err != nil || !changed || next != w {
+			t.Fatalf("from %s got next=%s changed=%v err=%v, want %s", st.State, next, changed, err, w)
+		}
+		st.State = next
+	}
+
+	if topUpFrom != sm.hotWallets[models.Ethereum] {
+		t.Fatalf("top-up sent from %s, want hot wallet", topUpFrom)
+	}
+	if sweepFrom != st.DepositAddr.Hex() || sweepTo != sm.hotWallets[models.Ethereum] {
+		t.Fatalf("sweep %s -> %s, want deposit address -> hot wallet", sweepFrom, sweepTo)
+	}
+	if st.SentTopUpTxHash != "0xraw_topup" || st.SentSweepTxHash != "0xraw_sweep" {
+		t.Fatalf("unexpected hashes topup=%s sweep=%s", st.SentTopUpTxHash, st.SentSweepTxHash)
+	}
+	if st.TotalTopUpWei == nil || st.TotalTopUpWei.Int64() != 1_260_000 {
+		t.Fatalf("TotalTopUpWei = %v, want 1260000", st.TotalTopUpWei)
+	}
+}
+
+func TestTransitionDeposit_TokenSweepTopsUpAgainWhenFeesRise(t *testing.T) {
+	topUps := []*big.Int{big.NewInt(1_000), big.NewInt(200)}
+	sweeps := 0
+	srcCtx := &mockChainCtx{
+		buildTopUpTxFn: func(ctx context.Context, from, to, asset string) (string, *big.Int, error) {
+			amount := topUps[0]
+			topUps = topUps[1:]
+			return fmt.Sprintf("raw_topup_%s", amount), amount, nil
+		},
+		tokenSweepTxFn: func(ctx context.Context, from, to, asset string) (string, error) {
+			sweeps++
+			if sweeps == 1 {
+				return "", fmt.Errorf("%w: have 1000 need 1200", ErrInsufficientGas)
+			}
+			return "raw_sweep", nil
+		},
+		broadcastTxFn:   func(ctx context.Context, raw, from string) (string, error) { return "0x" + raw, nil },
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	st := newTokenDeposit(models.StateDstTxConfirmed)
+
+	ctx := context.Background()
+	want := []models.State{
+		models.StateSweepTopUpBuilt,
+		models.StateSweepTopUpSent,
+		models.StateSweepTopUpConfirmed,
+		models.StateSweepTopUpResend, // sweep underfunded at current fees
+		models.StateSweepTopUpBuilt,
+		models.StateSweepTopUpSent,
+		models.StateSweepTopUpConfirmed,
+		models.StateSweepTxBuilt,
+	}
+	for _, w := range want {
+		next, changed, err := sm.TransitionDeposit(ctx, st)
+		if err != nil || !changed || next != w {
+			t.Fatalf("from %s got next=%s changed=%v err=%v, want %s", st.State, next, changed, err, w)
+		}
+		st.State = next
+	}
+	if st.TotalTopUpWei.Int64() != 1_200 {
+		t.Fatalf("TotalTopUpWei = %s, want 1200", st.TotalTopUpWei)
+	}
+}
+
+func TestTransitionDeposit_TokenSweepSkipsTopUpWhenFunded(t *testing.T) {
+	srcCtx := &mockChainCtx{
+		buildTopUpTxFn: func(ctx context.Context, from, to, asset string) (string, *big.Int, error) {
+			return "", new(big.Int), nil
+		},
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	st := newTokenDeposit(models.StateDstTxConfirmed)
+
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err != nil || !changed || next != models.StateSweepTopUpConfirmed {
+		t.Fatalf("got next=%s changed=%v err=%v", next, changed, err)
+	}
+	if st.UnsignedTopUpTx != "" {
+		t.Fatalf("unexpected top-up tx %q", st.UnsignedTopUpTx)
+	}
+}
+
+func TestStateMachine_ProcessBlock_IgnoresHotWalletTopUps(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	hot := crypto.PubkeyToAddress(key.PublicKey)
+	depAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	account := &models.Account{ID: "acct-1", DepositAddr: depAddr, SrcChain: models.Ethereum, DstChain: models.Hyperliquid}
+	mstates := newMockStateStore()
+	sm := &StateMachine{
+		provider:   newBlockProvider(nil),
+		states:     mstates,
+		accounts:   &mocks.MockAccountStore{ByAddr: map[string]*models.Account{depAddr.Hex(): account}},
+		hotWallets: map[models.Chain]string{models.Ethereum: hot.Hex()},
+	}
+
+	signer := types.LatestSignerForChainID(big.NewInt(11155111))
+	topUp := types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+		ChainID: big.NewInt(11155111), Nonce: 0, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21000, To: &depAddr, Value: big.NewInt(100),
+	})
+	deposit := types.NewTransaction(0, depAddr, big.NewInt(5), 21000, big.NewInt(1), nil)
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, &types.Body{Transactions: types.Transactions{topUp, deposit}}, nil, new(mockTrieHasher))
+	if _, err := sm.ProcessBlock(context.Background(), models.Ethereum, block); err != nil {
+		t.Fatalf("ProcessBlock error: %v", err)
+	}
+
+	if len(mstates.items) != 1 {
+		t.Fatalf("expected 1 deposit, got %d", len(mstates.items))
+	}
+	if mstates.get(fmt.Sprintf("%s|%s", depAddr, topUp.Hash().Hex())) != nil {
+		t.Fatalf("hot wallet top-up recorded as deposit")
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+	"unit/agent/internal/utils/hmacsig"
+)
+
+const (
+	HeaderWebhookID        = "X-Webhook-Id"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+var ErrInvalidSubscription = errors.New("invalid webhook subscription")
+
+var webhookRetryPolicy = RetryPolicy{
+	BaseDelay:   10 * time.Second,
+	MaxDelay:    time.Hour,
+	MaxAttempts: 15,
+}
+
+type webhookPayload struct {
+	Event     models.WebhookEvent `json:"event"`
+	CreatedAt time.Time           `json:"created_at"`
+	Deposit   depositResponse     `json:"deposit"`
+}
+
+// WebhookDispatcher queues an event for every subscription interested in a state transition and delivers the queue.
+// Deliveries are POSTed signed with the subscription secret and retried with exponential backoff until the receiver
+// answers 2xx, deliveries that exhaust their retries are dead lettered. Each subscription is delivered to by its own
+// worker, strictly in queue order, so a slow receiver only delays its own deliveries.
+type WebhookDispatcher struct {
+	store       stores.IWebhookStore
+	client      *http.Client
+	policy      RetryPolicy
+	interval    time.Duration
+	concurrency int
+
+	mu      sync.Mutex
+	busy    map[string]bool // subscriptions with a running worker
+	workers sync.WaitGroup
+}
+
+func NewWebhookDispatcher(store stores.IWebhookStore, client *http.Client) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:       store,
+		client:      client,
+		policy:      webhookRetryPolicy,
+		interval:    time.Second,
+		concurrency: 8,
+		busy:        make(map[string]bool),
+	}
+}
+
+// SetConcurrency sets how many subscriptions are delivered to at once. Must be called before Start.
+func (d *WebhookDispatcher) SetConcurrency(n int) {
+	if n > 0 {
+		d.concurrency = n
+	}
+}
+
+// Subscribe registers `rawURL` for `events`, every event if empty. A secret is generated if none is given.
+func (d *WebhookDispatcher) Subscribe(ctx context.Context, rawURL string, events []models.WebhookEvent, secret string) (*models.WebhookSubscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("%w, invalid url %q", ErrInvalidSubscription, rawURL)
+	}
+	for _, e := range events {
+		if !slices.Contains(models.WebhookEvents, e) {
+			return nil, fmt.Errorf("%w, unknown event %q", ErrInvalidSubscription, e)
+		}
+	}
+	if secret == "" {
+		if secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	sub := &models.WebhookSubscription{
+		ID:        id,
+		URL:       rawURL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	if err := d.store.PutSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (d *WebhookDispatcher) Unsubscribe(ctx context.Context, id string) error {
+	return d.store.DeleteSubscription(ctx, id)
+}
+
+func (d *WebhookDispatcher) Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	return d.store.Subscriptions(ctx)
+}
+
+func (d *WebhookDispatcher) DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error) {
+	return d.store.DeadLetters(ctx)
+}
+
+// Redrive queues a dead letter again with fresh attempts
+func (d *WebhookDispatcher) Redrive(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	return d.store.Redrive(ctx, id)
+}
+
+// Notify queues the event of a committed transition for every subscription that wants it
+func (d *WebhookDispatcher) Notify(ctx context.Context, st *models.DepositState) error {
+	event, ok := models.WebhookEventFor(st.State)
+	if !ok {
+		return nil
+	}
+	subs, err := d.store.Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: now, Deposit: newDepositResponse(st)})
+	if err != nil {
+		return err
+	}
+	var deliveries []*models.WebhookDelivery
+	for _, sub := range subs {
+		if !sub.Wants(event) {
+			continue
+		}
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			Event:          event,
+			DepositID:      st.ID,
+			Payload:        payload,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return d.store.Enqueue(ctx, deliveries...)
+}
+
+// Start delivers due deliveries until the context is canceled
+func (d *WebhookDispatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	defer d.workers.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := d.dispatch(ctx, time.Now()); err != nil {
+				fmt.Printf("webhook delivery error: %v\n", err)
+			}
+		}
+	}
+}
+
+// dispatch starts a worker for every subscription whose queue head is due at `now`, up to the concurrency limit.
+// Subscriptions that still have a worker running or did not get one are picked up by a later tick.
+func (d *WebhookDispatcher) dispatch(ctx context.Context, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var subs []string
+	if err := d.store.Due(ctx, now, func(del *models.WebhookDelivery) error {
+		if !d.busy[del.SubscriptionID] {
+			subs = append(subs, del.SubscriptionID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, subID := range subs {
+		if len(d.busy) >= d.concurrency {
+			break
+		}
+		d.busy[subID] = true
+		d.workers.Add(1)
+		go func(subID string) {
+			defer d.workers.Done()
+			if err := d.deliverTo(ctx, subID, now); err != nil {
+				fmt.Printf("webhook delivery error: %v\n", err)
+			}
+			d.mu.Lock()
+			delete(d.busy, subID)
+			d.mu.Unlock()
+		}(subID)
+	}
+	return nil
+}
+
+// deliverTo attempts the queued deliveries of a subscription in order. A delivery is only attempted once every
+// delivery queued before it was delivered or dead lettered, so receivers get events in the order they happened. It
+// stops at the first delivery not due at `now` or that fails, the rest of the queue waits for it.
+func (d *WebhookDispatcher) deliverTo(ctx context.Context, subID string, now time.Time) error {
+	// the worker is the only one taking deliveries off this queue, later events are queued behind the read ones
+	var deliveries []*models.WebhookDelivery
+	if err := d.store.Queued(ctx, subID, func(del *models.WebhookDelivery) error {
+		deliveries = append(deliveries, del)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sub, err := d.store.GetSubscription(ctx, subID)
+	if errors.Is(err, stores.ErrSubscriptionNotFound) {
+		for _, del := range deliveries {
+			fmt.Printf("dropping webhook delivery %s, subscription %s was deleted\n", del.ID, subID)
+			if err := d.store.CompleteDelivery(ctx, del.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, del := range deliveries {
+		if del.NextAttemptAt.After(now) {
+			return nil
+		}
+		err := d.deliver(ctx, sub, del)
+		if err == nil {
+			if err := d.store.CompleteDelivery(ctx, del.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		del.Attempts++
+		del.LastError = err.Error()
+		if del.Attempts >= d.policy.MaxAttempts {
+			fmt.Printf("webhook delivery %s to %s dead lettered after %d attempts: %s\n", del.ID, sub.URL, del.Attempts, del.LastError)
+			if err := d.store.DeadLetter(ctx, del); err != nil {
+				return err
+			}
+			continue
+		}
+		del.NextAttemptAt = time.Now().Add(d.policy.Backoff(del.Attempts))
+		return d.store.UpdateDelivery(ctx, del)
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, sub *models.WebhookSubscription, del *models.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, del.ID)
+	req.Header.Set(HeaderWebhookEvent, string(del.Event))
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, hmacsig.SignPayload([]byte(sub.Secret), timestamp, del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
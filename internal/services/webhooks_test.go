@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+	"unit/agent/internal/utils/hmacsig"
+)
+
+func newWebhookDispatcherForTest(t *testing.T) (*WebhookDispatcher, *stores.LocalWebhookStore) {
+	t.Helper()
+	ws, err := stores.NewLocalWebhookStore(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatalf("NewLocalWebhookStore: %v", err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	d := NewWebhookDispatcher(ws, &http.Client{Timeout: time.Second})
+	d.policy = RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxAttempts: 3}
+	return d, ws
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers each delivery with the next status, 200 once they run out
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []receivedWebhook) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []receivedWebhook
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, receivedWebhook{header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedWebhook(nil), received...)
+	}
+}
+
+// deliverDue runs a tick at `now` and waits for the deliveries it started
+func (d *WebhookDispatcher) deliverDue(ctx context.Context, now time.Time) error {
+	err := d.dispatch(ctx, now)
+	d.workers.Wait()
+	return err
+}
+
+// pendingDeliveries returns every queued delivery, queue by queue
+func pendingDeliveries(t *testing.T, ws *stores.LocalWebhookStore) []*models.WebhookDelivery {
+	t.Helper()
+	ctx := context.Background()
+	var heads []*models.WebhookDelivery
+	if err := ws.Due(ctx, time.Unix(1<<40, 0), func(d *models.WebhookDelivery) error {
+		heads = append(heads, d)
+		return nil
+	}); err != nil {
+		t.Fatalf("Due: %v", err)
+	}
+	var pending []*models.WebhookDelivery
+	for _, head := range heads {
+		if err := ws.Queued(ctx, head.SubscriptionID, func(d *models.WebhookDelivery) error {
+			pending = append(pending, d)
+			return nil
+		}); err != nil {
+			t.Fatalf("Queued: %v", err)
+		}
+	}
+	return pending
+}
+
+func TestWebhookDispatcher_Notify_QueuesForInterestedSubscriptions(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+
+	all, err := d.Subscribe(ctx, "https://example.com/all", nil, "")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if all.Secret == "" {
+		t.Fatal("no secret generated")
+	}
+	if _, err := d.Subscribe(ctx, "https://example.com/done", []models.WebhookEvent{models.WebhookDepositCompleted}, "s"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for _, state := range []models.State{models.StateSrcTxDiscovered, models.StateSrcTxConfirmed, models.StateDone} {
+		if err := d.Notify(ctx, &models.DepositState{ID: "dep", State: state}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	pending := pendingDeliveries(t, ws)
+	if len(pending) != 3 {
+		t.Fatalf("queued %d deliveries, want 3", len(pending))
+	}
+	if pending[0].Event != models.WebhookDepositDetected || pending[0].SubscriptionID != all.ID {
+		t.Fatalf("first delivery = %+v", pending[0])
+	}
+}
+
+func TestWebhookDispatcher_Subscribe_Validates(t *testing.T) {
+	d, _ := newWebhookDispatcherForTest(t)
+	for _, tc := range []struct {
+		url    string
+		events []models.WebhookEvent
+	}{
+		{url: "ftp://example.com"},
+		{url: "not a url"},
+		{url: "https://example.com", events: []models.WebhookEvent{"deposit.bogus"}},
+	} {
+		if _, err := d.Subscribe(context.Background(), tc.url, tc.events, ""); !errors.Is(err, ErrInvalidSubscription) {
+			t.Fatalf("%s %v: err = %v, want ErrInvalidSubscription", tc.url, tc.events, err)
+		}
+	}
+}
+
+func TestWebhookDispatcher_DeliversSignedPayload(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+	srv, received := webhookReceiver(t)
+
+	sub, err := d.Subscribe(ctx, srv.URL, nil, "secret")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := d.Notify(ctx, &models.DepositState{ID: "dep", State: models.StateDone, SentDstTxHash: "0xdst"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := d.deliverDue(ctx, time.Now()); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("received %d deliveries, want 1", len(got))
+	}
+	h := got[0].header
+	if h.Get(HeaderWebhookEvent) != string(models.WebhookDepositCompleted) || h.Get(HeaderWebhookID) == "" {
+		t.Fatalf("headers = %v", h)
+	}
+	if want := hmacsig.SignPayload([]byte(sub.Secret), h.Get(HeaderWebhookTimestamp), got[0].body); h.Get(HeaderWebhookSignature) != want {
+		t.Fatalf("signature = %s, want %s", h.Get(HeaderWebhookSignature), want)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(got[0].body, &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Event != models.WebhookDepositCompleted || payload.Deposit.ID != "dep" || payload.Deposit.DstTxHash != "0xdst" {
+		t.Fatalf("payload = %+v", payload)
+	}
+	if pending := pendingDeliveries(t, ws); len(pending) != 0 {
+		t.Fatalf("%d deliveries still queued", len(pending))
+	}
+}
+
+func TestWebhookDispatcher_RetriesThenDeadLetters(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+	srv, received := webhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+	if _, err := d.Subscribe(ctx, srv.URL, nil, "secret"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := d.Notify(ctx, &models.DepositState{ID: "dep", State: models.StateFailed}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	if err := d.deliverDue(ctx, time.Now()); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	pending := pendingDeliveries(t, ws)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" || !pending[0].NextAttemptAt.After(time.Now().Add(-time.Second)) {
+		t.Fatalf("pending after failure = %+v", pending)
+	}
+	// not due yet
+	if err := d.deliverDue(ctx, pending[0].NextAttemptAt.Add(-time.Nanosecond)); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	if n := len(received()); n != 1 {
+		t.Fatalf("received %d deliveries before backoff elapsed, want 1", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := d.deliverDue(ctx, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("deliverDue: %v", err)
+		}
+	}
+	dead, err := d.DeadLetters(ctx)
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("dead letters = %v, %v", dead, err)
+	}
+	if pending := pendingDeliveries(t, ws); len(pending) != 0 {
+		t.Fatalf("%d deliveries still queued", len(pending))
+	}
+
+	// the receiver recovered
+	if _, err := d.Redrive(ctx, dead[0].ID); err != nil {
+		t.Fatalf("Redrive: %v", err)
+	}
+	if err := d.deliverDue(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	if n := len(received()); n != 4 {
+		t.Fatalf("received %d deliveries, want 4", n)
+	}
+	if pending := pendingDeliveries(t, ws); len(pending) != 0 {
+		t.Fatalf("%d deliveries still queued after redrive", len(pending))
+	}
+}
+
+func TestWebhookDispatcher_DeliversInQueueOrder(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+	srv, received := webhookReceiver(t, http.StatusInternalServerError)
+
+	if _, err := d.Subscribe(ctx, srv.URL, nil, "secret"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for _, st := range []models.State{models.StateDstTxConfirmed, models.StateDone} {
+		if err := d.Notify(ctx, &models.DepositState{ID: "dep", State: st}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	// the first event failed, the second is not sent while the first backs off
+	if err := d.deliverDue(ctx, time.Now()); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	pending := pendingDeliveries(t, ws)
+	if n := len(received()); n != 1 || len(pending) != 2 || pending[0].Attempts != 1 {
+		t.Fatalf("received %d, pending %+v, want the first delivery retried first", n, pending)
+	}
+	if err := d.deliverDue(ctx, pending[0].NextAttemptAt.Add(-time.Nanosecond)); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	if n := len(received()); n != 1 {
+		t.Fatalf("received %d deliveries while the first backed off, want 1", n)
+	}
+
+	if err := d.deliverDue(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	got := received()
+	if len(got) != 3 {
+		t.Fatalf("received %d deliveries, want 3", len(got))
+	}
+	var events []string
+	for _, r := range got {
+		events = append(events, r.header.Get(HeaderWebhookEvent))
+	}
+	credited, completed := string(models.WebhookDepositCredited), string(models.WebhookDepositCompleted)
+	if !slices.Equal(events, []string{credited, credited, completed}) {
+		t.Fatalf("events = %v, want the failed one retried before the next", events)
+	}
+}
+
+func TestWebhookDispatcher_DropsDeliveriesOfDeletedSubscriptions(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+	srv, received := webhookReceiver(t)
+
+	sub, err := d.Subscribe(ctx, srv.URL, nil, "secret")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := d.Notify(ctx, &models.DepositState{ID: "dep", State: models.StateDone}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := d.Unsubscribe(ctx, sub.ID); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if err := d.deliverDue(ctx, time.Now()); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	if len(received()) != 0 || len(pendingDeliveries(t, ws)) != 0 {
+		t.Fatalf("delivery of deleted subscription was sent or kept")
+	}
+}
+
+func TestWebhookDispatcher_SlowReceiverDoesNotBlockOthers(t *testing.T) {
+	d, ws := newWebhookDispatcherForTest(t)
+	ctx := context.Background()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	fast, received := webhookReceiver(t)
+
+	if _, err := d.Subscribe(ctx, slow.URL, nil, "secret"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := d.Subscribe(ctx, fast.URL, nil, "secret"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for _, id := range []string{"dep1", "dep2"} {
+		if err := d.Notify(ctx, &models.DepositState{ID: id, State: models.StateDone}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	if err := d.dispatch(ctx, time.Now()); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for len(received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(received()); n != 2 {
+		t.Fatalf("fast receiver got %d deliveries while the slow one hung, want 2", n)
+	}
+	close(release)
+	d.workers.Wait()
+
+	if pending := pendingDeliveries(t, ws); len(pending) != 0 {
+		t.Fatalf("%d deliveries still queued", len(pending))
+	}
+}
+
+func TestStateMachine_processDeposit_QueuesWebhooks(t *testing.T) {
+	srcCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+	}
+	sm := newStateMachineForTest(t, &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: srcCtx}})
+	sm.states = newMockStateStore()
+	d, ws := newWebhookDispatcherForTest(t)
+	sm.SetWebhooks(d)
+	if _, err := d.Subscribe(context.Background(), "https://example.com/hook", nil, "secret"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	sm.processDeposit(context.Background(), &models.DepositState{ID: "dep", State: models.StateSweepTxConfirmed, SrcChain: models.Ethereum})
+
+	pending := pendingDeliveries(t, ws)
+	if len(pending) != 1 || pending[0].Event != models.WebhookDepositCompleted || pending[0].DepositID != "dep" {
+		t.Fatalf("queued = %+v", pending)
+	}
+}
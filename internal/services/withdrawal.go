@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+	hlutil "unit/agent/internal/utils/hyperliquid"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ProcessTransfers records a withdrawal for every USDC spot transfer into a Hyperliquid deposit address.
+// Transfers are final once they show up in the ledger, so withdrawals start out detected rather than waiting for confirmations.
+func (sm *StateMachine) ProcessTransfers(ctx context.Context, transfers []clients.LedgerUpdate) error {
+	for _, t := range transfers {
+		account, err := sm.accounts.GetByDepositAddress(ctx, common.HexToAddress(t.Delta.Destination).Hex())
+		if err != nil {
+			if errors.Is(err, stores.ErrAccountNotFound) {
+				continue
+			}
+			return err
+		}
+		if account.SrcChain != models.Hyperliquid {
+			continue
+		}
+		if t.Delta.Token != "USDC" {
+			fmt.Printf("ignoring %s transfer to %s, only USDC is supported\n", t.Delta.Token, account.DepositAddr.Hex())
+			continue
+		}
+
+		amount, err := hlutil.ParseAmount(t.Delta.Amount, usdcDecimals)
+		if err != nil {
+			fmt.Printf("ignoring transfer %s to %s: %v\n", t.Hash, account.DepositAddr.Hex(), err)
+			continue
+		}
+
+		withdrawal := &models.DepositState{
+			ID:          fmt.Sprintf("%s|%s", account.DepositAddr, t.Hash),
+			TxHash:      t.Hash,
+			DepositAddr: account.DepositAddr,
+			DstAddr:     account.DstAddr,
+			DstChain:    account.DstChain,
+			SrcChain:    account.SrcChain,
+			Asset:       models.AssetUsdc,
+			AmountWei:   amount,
+			State:       models.StateWithdrawalDetected,
+			UpdatedAt:   time.Now(),
+			CreatedAt:   time.Now(),
+		}
+		// a batch replayed after a restart finds its withdrawals recorded, they were published when first inserted
+		inserted, err := sm.states.PutIfAbsent(ctx, withdrawal)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			fmt.Printf("found withdrawal to: %s amount: %s USDC\n", account.DepositAddr.Hex(), t.Delta.Amount)
+			sm.publish(ctx, withdrawal)
+		}
+	}
+	return nil
+}
+
+// transitionWithdrawal advances a Hyperliquid -> Ethereum withdrawal: pay out ETH from the destination hot wallet,
+// then sweep the USDC received by the deposit address to the Hyperliquid hot wallet.
+func (sm *StateMachine) transitionWithdrawal(ctx context.Context, st *models.DepositState) (next models.State, changed bool, err error) {
+	switch st.State {
+
+	case models.StateWithdrawalDetected, models.StateWithdrawalPayoutResend:
+		if st.State == models.StateWithdrawalDetected {
+			credit, err := sm.claimBelowMinimum(ctx, st)
+			if err != nil {
+				return st.State, false, err
+			}
+			if !credit {
+				fmt.Printf("withdrawal %s to %s below minimum, not paid out\n", st.TxHash, st.DepositAddr.Hex())
+				st.State = models.StateBelowMinimum
+				return st.State, true, nil
+			}
+		}
+		addr, err := sm.getHotWallet(st.DstChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		amount, err := sm.credit(ctx, st)
+		if err != nil {
+			return st.State, false, err
+		}
+		tx, err := sm.provider.WithChain(st.DstChain).BuildSendTx(ctx, addr, st.DstAddr.Hex(), amount)
+		if err != nil {
+			return st.State, false, fmt.Errorf("error building tx: %v", err)
+		}
+		st.UnsignedDstTx = tx
+		st.State = models.StateWithdrawalPayoutBuilt
+		return st.State, true, nil
+
+	case models.StateWithdrawalPayoutBuilt:
+		addr, err := sm.getHotWallet(st.DstChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		if err := dstTx(st, addr).broadcast(ctx, sm); err != nil {
+			return st.State, false, err
+		}
+		st.State = models.StateWithdrawalPayoutSent
+		return st.State, true, nil
+
+	case models.StateWithdrawalPayoutSent:
+		addr, err := sm.getHotWallet(st.DstChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		confirmed, err := dstTx(st, addr).await(ctx, sm)
+		if err != nil {
+			if errors.Is(err, ErrorRejectedTransaction) {
+				st.State = models.StateWithdrawalPayoutRejected
+				return st.State, true, nil
+			}
+			return st.State, false, fmt.Errorf("error getting confirmation status %v", err)
+		}
+		if !confirmed {
+			fmt.Printf("waiting for confirmations: %s\n", st.SentDstTxHash)
+			return st.State, false, nil
+		}
+		st.State = models.StateWithdrawalPayoutConfirmed
+		return st.State, true, nil
+
+	case models.StateWithdrawalPayoutRejected:
+		st.State = models.StateWithdrawalPayoutResend
+		return st.State, true, nil
+
+	case models.StateWithdrawalPayoutConfirmed, models.StateWithdrawalSweepResend:
+		addr, err := sm.getHotWallet(st.SrcChain)
+		if err != nil {
+			return st.State, false, err
+		}
+		tx, err := sm.provider.WithChain(st.SrcChain).BuildSweepTx(ctx, st.DepositAddr.Hex(), addr)
+		if err != nil {
+			return st.State, false, err
+		}
+		st.UnsignedSweepTx = tx
+		st.State = models.StateWithdrawalSweepBuilt
+		return st.State, true, nil
+
+	case models.StateWithdrawalSweepBuilt:
+		if err := sweepTx(st).broadcast(ctx, sm); err != nil {
+			return st.State, false, err
+		}
+		st.State = models.StateWithdrawalSweepSent
+		return st.State, true, nil
+
+	case models.StateWithdrawalSweepSent:
+		confirmed, err := sweepTx(st).await(ctx, sm)
+		if err != nil {
+			if errors.Is(err, ErrorRejectedTransaction) {
+				st.State = models.StateWithdrawalSweepRejected
+				return st.State, true, nil
+			}
+			return st.State, false, fmt.Errorf("error getting confirmation status %v", err)
+		}
+		if !confirmed {
+			fmt.Printf("waiting for confirmations: %s\n", st.SentSweepTxHash)
+			return st.State, false, nil
+		}
+		st.State = models.StateDone
+		return st.State, true, nil
+
+	case models.StateWithdrawalSweepRejected:
+		st.State = models.StateWithdrawalSweepResend
+		return st.State, true, nil
+
+	default:
+		return st.State, false, fmt.Errorf("unknown withdrawal state %s", st.State)
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"unit/agent/internal/clients"
+	"unit/agent/internal/mocks"
+	"unit/agent/internal/models"
+	"unit/agent/internal/stores"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTransitionWithdrawal_Success(t *testing.T) {
+	var sentWei *big.Int
+	var sweepFrom, sweepTo, sweepSigner string
+	hlCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+		buildSweepTxFn: func(ctx context.Context, from, to string) (string, error) {
+			sweepFrom, sweepTo = from, to
+			return "raw_sweep", nil
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) {
+			sweepSigner = from
+			return "0xsweephash", nil
+		},
+	}
+	ethCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) { return true, nil },
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			sentWei = amount
+			return "raw_payout", nil
+		},
+		broadcastTxFn: func(ctx context.Context, raw, from string) (string, error) { return "0xpayouthash", nil },
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{
+		models.Ethereum:    ethCtx,
+		models.Hyperliquid: hlCtx,
+	}}
+	sm := newStateMachineForTest(t, wm)
+
+	depositAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	st := &models.DepositState{
+		State:       models.StateWithdrawalDetected,
+		SrcChain:    models.Hyperliquid,
+		DstChain:    models.Ethereum,
+		TxHash:      "0xledger",
+		DepositAddr: depositAddr,
+		DstAddr:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		Asset:       "usdc",
+		AmountWei:   big.NewInt(10_000_000), // 10 USDC
+	}
+	ctx := context.Background()
+
+	steps := []models.State{
+		models.StateWithdrawalPayoutBuilt,
+		models.StateWithdrawalPayoutSent,
+		models.StateWithdrawalPayoutConfirmed,
+		models.StateWithdrawalSweepBuilt,
+		models.StateWithdrawalSweepSent,
+		models.StateDone,
+	}
+	for i, want := range steps {
+		next, changed, err := sm.TransitionDeposit(ctx, st)
+		if err != nil || !changed || next != want {
+			t.Fatalf("step%d got next=%s changed=%v err=%v, want %s", i+1, next, changed, err, want)
+		}
+		st.State = next
+	}
+
+	// 10 USDC at 1000 USDC/ETH
+	if want, _ := new(big.Int).SetString("10000000000000000", 10); sentWei.Cmp(want) != 0 {
+		t.Fatalf("payout = %s wei, want %s", sentWei, want)
+	}
+	if st.SentDstTxHash != "0xpayouthash" || st.SentSweepTxHash != "0xsweephash" {
+		t.Fatalf("unexpected hashes dst=%s sweep=%s", st.SentDstTxHash, st.SentSweepTxHash)
+	}
+	if sweepFrom != depositAddr.Hex() || sweepTo != sm.hotWallets[models.Hyperliquid] || sweepSigner != depositAddr.Hex() {
+		t.Fatalf("unexpected sweep from=%s to=%s signer=%s", sweepFrom, sweepTo, sweepSigner)
+	}
+}
+
+func TestTransitionWithdrawal_PayoutRejected(t *testing.T) {
+	ethCtx := &mockChainCtx{
+		isTxConfirmedFn: func(ctx context.Context, txHash string, min uint64) (bool, error) {
+			return false, ErrorRejectedTransaction
+		},
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			return "raw_payout_2", nil
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: ethCtx}}
+	sm := newStateMachineForTest(t, wm)
+
+	st := &models.DepositState{
+		State:         models.StateWithdrawalPayoutSent,
+		SrcChain:      models.Hyperliquid,
+		DstChain:      models.Ethereum,
+		SentDstTxHash: "0xpayout",
+		AmountWei:     big.NewInt(1_000_000),
+	}
+	ctx := context.Background()
+
+	for _, want := range []models.State{models.StateWithdrawalPayoutRejected, models.StateWithdrawalPayoutResend, models.StateWithdrawalPayoutBuilt} {
+		next, changed, err := sm.TransitionDeposit(ctx, st)
+		if err != nil || !changed || next != want {
+			t.Fatalf("got next=%s changed=%v err=%v, want %s", next, changed, err, want)
+		}
+		st.State = next
+	}
+	if st.UnsignedDstTx != "raw_payout_2" {
+		t.Fatalf("expected rebuilt payout, got %q", st.UnsignedDstTx)
+	}
+}
+
+func TestTransitionWithdrawal_BuildError(t *testing.T) {
+	ethCtx := &mockChainCtx{
+		buildSendTxFn: func(ctx context.Context, from, to string, amount *big.Int) (string, error) {
+			return "", errors.New("insufficient funds")
+		},
+	}
+	wm := &mockChainProvider{byChain: map[models.Chain]*mockChainCtx{models.Ethereum: ethCtx}}
+	sm := newStateMachineForTest(t, wm)
+
+	st := &models.DepositState{State: models.StateWithdrawalDetected, DstChain: models.Ethereum, AmountWei: big.NewInt(1)}
+	next, changed, err := sm.TransitionDeposit(context.Background(), st)
+	if err == nil || changed || next != models.StateWithdrawalDetected {
+		t.Fatalf("expected error without transition, got next=%s changed=%v err=%v", next, changed, err)
+	}
+}
+
+func TestProcessTransfers(t *testing.T) {
+	hlAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	ethAddr := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	as := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{
+		hlAddr.Hex(): {
+			ID:          "hl",
+			SrcChain:    models.Hyperliquid,
+			DstChain:    models.Ethereum,
+			DepositAddr: hlAddr,
+			DstAddr:     common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		},
+		ethAddr.Hex(): {ID: "eth", SrcChain: models.Ethereum, DstChain: models.Hyperliquid, DepositAddr: ethAddr},
+	}}
+	mstates := newMockStateStore()
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	sm.accounts = as
+	sm.states = mstates
+
+	transfers := []clients.LedgerUpdate{
+		{Hash: "0xusdc", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "12.5", Destination: "0x1111111111111111111111111111111111111111"}},
+		{Hash: "0xpurr", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "PURR", Amount: "1", Destination: hlAddr.Hex()}},
+		{Hash: "0xeth", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "1", Destination: ethAddr.Hex()}},
+		{Hash: "0xunknown", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "1", Destination: "0x4444444444444444444444444444444444444444"}},
+	}
+	if err := sm.ProcessTransfers(context.Background(), transfers); err != nil {
+		t.Fatalf("ProcessTransfers: %v", err)
+	}
+
+	if len(mstates.items) != 1 {
+		t.Fatalf("expected 1 withdrawal, got %d", len(mstates.items))
+	}
+	got, err := mstates.Get(context.Background(), hlAddr.Hex()+"|0xusdc")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != models.StateWithdrawalDetected || got.Asset != "usdc" || got.AmountWei.Cmp(big.NewInt(12_500_000)) != 0 {
+		t.Fatalf("unexpected withdrawal %+v", got)
+	}
+	if got.DstChain != models.Ethereum || got.SrcChain != models.Hyperliquid {
+		t.Fatalf("unexpected chains src=%s dst=%s", got.SrcChain, got.DstChain)
+	}
+}
+
+func TestProcessTransfers_PublishesOnlyNewWithdrawals(t *testing.T) {
+	hlAddr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	as := &mocks.MockAccountStore{ByAddr: map[string]*models.Account{
+		hlAddr.Hex(): {ID: "hl", SrcChain: models.Hyperliquid, DstChain: models.Ethereum, DepositAddr: hlAddr},
+	}}
+	sm := newStateMachineForTest(t, &mockChainProvider{})
+	sm.accounts = as
+	sm.states = newMockStateStore()
+	broker := NewBroker()
+	sm.SetBroker(broker)
+	published, cancel := broker.Subscribe(stores.DepositFilter{})
+	defer cancel()
+
+	transfers := []clients.LedgerUpdate{
+		{Hash: "0xusdc", Delta: clients.LedgerDelta{Type: "spotTransfer", Token: "USDC", Amount: "12.5", Destination: hlAddr.Hex()}},
+	}
+	// the batch is replayed after a restart before its checkpoint was committed
+	for i := 0; i < 2; i++ {
+		if err := sm.ProcessTransfers(context.Background(), transfers); err != nil {
+			t.Fatalf("ProcessTransfers: %v", err)
+		}
+	}
+
+	if got := len(published); got != 1 {
+		t.Fatalf("published %d withdrawals, want 1", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"unit/agent/internal/models"
 
@@ -15,12 +16,15 @@ var (
 	bucketByAddr = []byte("accounts_by_addr")
 
 	ErrAccountNotFound = errors.New("account not found")
+	ErrAccountExists   = errors.New("account already exists")
 )
 
 type IAccountStore interface {
+	// Insert stores a new account, ErrAccountExists is returned if its ID or deposit address is already taken
 	Insert(ctx context.Context, account models.Account) error
 	Get(ctx context.Context, id string) (*models.Account, error)
 	GetByDepositAddress(ctx context.Context, address string) (*models.Account, error)
+	Scan(ctx context.Context, visit func(*models.Account) error) error
 }
 
 type LocalAccountStore struct {
@@ -60,6 +64,14 @@ func (a *LocalAccountStore) Insert(ctx context.Context, account models.Account)
 		byID := tx.Bucket(bucketByID)
 		byAddr := tx.Bucket(bucketByAddr)
 
+		// a deposit address handed out twice would credit deposits to it to the newer account
+		if byID.Get([]byte(account.ID)) != nil {
+			return fmt.Errorf("%w, id %s", ErrAccountExists, account.ID)
+		}
+		if id := byAddr.Get([]byte(account.DepositAddr.Hex())); id != nil {
+			return fmt.Errorf("%w, deposit address %s belongs to %s", ErrAccountExists, account.DepositAddr.Hex(), id)
+		}
+
 		if err := byID.Put([]byte(account.ID), data); err != nil {
 			return err
 		}
@@ -117,6 +129,22 @@ func (a *LocalAccountStore) GetByDepositAddress(ctx context.Context, address str
 	return acct, nil
 }
 
+// Scan visits every account, stops at the first error returned by visit
+func (a *LocalAccountStore) Scan(ctx context.Context, visit func(*models.Account) error) error {
+	return a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketByID).ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var acct models.Account
+			if err := json.Unmarshal(v, &acct); err != nil {
+				return err
+			}
+			return visit(&acct)
+		})
+	})
+}
+
 func (a *LocalAccountStore) Close() error {
 	return a.db.Close()
 }
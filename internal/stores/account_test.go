@@ -2,6 +2,8 @@ package stores
 
 import (
 	"context"
+	"errors"
+	"math/big"
 	"path/filepath"
 	"testing"
 
@@ -51,6 +53,29 @@ func TestLocalAccountStore_InsertAndGet(t *testing.T) {
 	}
 }
 
+func TestLocalAccountStore_Insert_RejectsTakenIDAndAddress(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	first := models.Account{ID: "acct_1", DepositAddr: common.BigToAddress(big.NewInt(1))}
+	if err := store.Insert(ctx, first); err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+	for _, acct := range []models.Account{
+		{ID: "acct_1", DepositAddr: common.BigToAddress(big.NewInt(2))},
+		{ID: "acct_2", DepositAddr: first.DepositAddr},
+	} {
+		if err := store.Insert(ctx, acct); !errors.Is(err, ErrAccountExists) {
+			t.Fatalf("Insert(%s, %s) error = %v, want ErrAccountExists", acct.ID, acct.DepositAddr.Hex(), err)
+		}
+	}
+
+	got, err := store.GetByDepositAddress(ctx, first.DepositAddr.Hex())
+	if err != nil || got.ID != first.ID {
+		t.Fatalf("GetByDepositAddress = %+v, %v, want %s", got, err, first.ID)
+	}
+}
+
 func TestLocalAccountStore_Get_NotFound(t *testing.T) {
 	store := newTestStore(t)
 
@@ -108,3 +133,29 @@ func TestLocalAccountStore_Close(t *testing.T) {
 		t.Fatalf("Close error: %v", err)
 	}
 }
+
+func TestLocalAccountStore_Scan(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	for i, id := range []string{"acct_1", "acct_2"} {
+		acct := models.Account{
+			ID:          id,
+			DepositAddr: common.BigToAddress(big.NewInt(int64(i + 1))),
+		}
+		if err := store.Insert(ctx, acct); err != nil {
+			t.Fatalf("Insert error: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	if err := store.Scan(ctx, func(a *models.Account) error {
+		seen[a.ID] = true
+		return nil
+	}); err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if len(seen) != 2 || !seen["acct_1"] || !seen["acct_2"] {
+		t.Fatalf("Scan visited %v, want acct_1 and acct_2", seen)
+	}
+}
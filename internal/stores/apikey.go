@@ -0,0 +1,101 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"unit/agent/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketAPIKeys = []byte("api_keys")
+
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// IAPIKeyStore holds API keys by ID
+type IAPIKeyStore interface {
+	Put(ctx context.Context, key *models.APIKey) error
+	Get(ctx context.Context, id string) (*models.APIKey, error)
+	List(ctx context.Context) ([]*models.APIKey, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type LocalAPIKeyStore struct {
+	db *bolt.DB
+}
+
+func NewLocalAPIKeyStore(path string) (*LocalAPIKeyStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketAPIKeys)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &LocalAPIKeyStore{db: db}, nil
+}
+
+func (s *LocalAPIKeyStore) Put(ctx context.Context, key *models.APIKey) error {
+	v, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPIKeys).Put([]byte(key.ID), v)
+	})
+}
+
+func (s *LocalAPIKeyStore) Get(ctx context.Context, id string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketAPIKeys).Get([]byte(id))
+		if v == nil {
+			return ErrAPIKeyNotFound
+		}
+		return json.Unmarshal(v, &key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *LocalAPIKeyStore) List(ctx context.Context) ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPIKeys).ForEach(func(k, v []byte) error {
+			var key models.APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, &key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *LocalAPIKeyStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAPIKeys)
+		if b.Get([]byte(id)) == nil {
+			return ErrAPIKeyNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *LocalAPIKeyStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,51 @@
+package stores
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"unit/agent/internal/models"
+)
+
+func newTestAPIKeyStore(t *testing.T) *LocalAPIKeyStore {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewLocalAPIKeyStore(filepath.Join(dir, "apikeys.db"))
+	if err != nil {
+		t.Fatalf("NewLocalAPIKeyStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestAPIKeyStore_PutGetListDelete(t *testing.T) {
+	store := newTestAPIKeyStore(t)
+	ctx := context.Background()
+
+	key := &models.APIKey{ID: "key_1", Name: "frontend", SecretHash: "abc", Scopes: []models.APIScope{models.ScopeRead}}
+	if err := store.Put(ctx, key); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	got, err := store.Get(ctx, "key_1")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got.Name != "frontend" || !got.HasScope(models.ScopeRead) || got.HasScope(models.ScopeAdmin) {
+		t.Fatalf("Get = %+v", got)
+	}
+	keys, err := store.List(ctx)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+
+	if err := store.Delete(ctx, "key_1"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if _, err := store.Get(ctx, "key_1"); err != ErrAPIKeyNotFound {
+		t.Fatalf("Get deleted = %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := store.Delete(ctx, "key_1"); err != ErrAPIKeyNotFound {
+		t.Fatalf("second Delete = %v, want ErrAPIKeyNotFound", err)
+	}
+}
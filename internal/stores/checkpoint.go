@@ -0,0 +1,140 @@
+package stores
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketCheckpoints = []byte("checkpoints")
+	bucketBlockHashes = []byte("block_hashes") // per chain, hashes of the last committed blocks keyed by number
+
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+)
+
+// ICheckpointStore records the last fully processed block per chain
+type ICheckpointStore interface {
+	Get(ctx context.Context, chain models.Chain) (uint64, error)
+	Put(ctx context.Context, chain models.Chain, block uint64) error
+	// PutBlock records `block` as the checkpoint along with its hash. Hashes of at most `keep` blocks up to it are kept
+	// so a restart can detect reorgs of committed blocks, hashes above it were orphaned and are dropped.
+	PutBlock(ctx context.Context, chain models.Chain, block uint64, hash common.Hash, keep uint64) error
+	// BlockHashes returns the recorded hashes of blocks up to the checkpoint
+	BlockHashes(ctx context.Context, chain models.Chain) (map[uint64]common.Hash, error)
+}
+
+type LocalCheckpointStore struct {
+	db *bolt.DB
+}
+
+func NewLocalCheckpointStore(path string) (*LocalCheckpointStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketCheckpoints); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketBlockHashes)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &LocalCheckpointStore{db: db}, nil
+}
+
+func (s *LocalCheckpointStore) Get(ctx context.Context, chain models.Chain) (uint64, error) {
+	var block uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketCheckpoints).Get([]byte(chain))
+		if v == nil {
+			return ErrCheckpointNotFound
+		}
+		block = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return block, nil
+}
+
+func (s *LocalCheckpointStore) Put(ctx context.Context, chain models.Chain, block uint64) error {
+	v := blockKey(block)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCheckpoints).Put([]byte(chain), v)
+	})
+}
+
+func (s *LocalCheckpointStore) PutBlock(ctx context.Context, chain models.Chain, block uint64, hash common.Hash, keep uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketCheckpoints).Put([]byte(chain), blockKey(block)); err != nil {
+			return err
+		}
+		hashes, err := tx.Bucket(bucketBlockHashes).CreateBucketIfNotExists([]byte(chain))
+		if err != nil {
+			return err
+		}
+		if err := hashes.Put(blockKey(block), hash.Bytes()); err != nil {
+			return err
+		}
+
+		var stale [][]byte
+		err = hashes.ForEach(func(k, v []byte) error {
+			if n := binary.BigEndian.Uint64(k); n > block || n+keep <= block {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := hashes.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LocalCheckpointStore) BlockHashes(ctx context.Context, chain models.Chain) (map[uint64]common.Hash, error) {
+	out := make(map[uint64]common.Hash)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketCheckpoints).Get([]byte(chain))
+		hashes := tx.Bucket(bucketBlockHashes).Bucket([]byte(chain))
+		if v == nil || hashes == nil {
+			return nil
+		}
+		// the checkpoint may have been rewound below recorded hashes since
+		checkpoint := binary.BigEndian.Uint64(v)
+		return hashes.ForEach(func(k, v []byte) error {
+			if n := binary.BigEndian.Uint64(k); n <= checkpoint {
+				out[n] = common.BytesToHash(v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func blockKey(block uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, block)
+	return k
+}
+
+func (s *LocalCheckpointStore) Close() error {
+	return s.db.Close()
+}
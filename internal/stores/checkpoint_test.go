@@ -0,0 +1,92 @@
+package stores
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestCheckpointStore(t *testing.T) *LocalCheckpointStore {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewLocalCheckpointStore(filepath.Join(dir, "checkpoints.db"))
+	if err != nil {
+		t.Fatalf("NewLocalCheckpointStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestCheckpointStore_PutAndGet(t *testing.T) {
+	store := newTestCheckpointStore(t)
+	ctx := context.Background()
+
+	if err := store.Put(ctx, models.Ethereum, 100); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if err := store.Put(ctx, models.Ethereum, 101); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	got, err := store.Get(ctx, models.Ethereum)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got != 101 {
+		t.Fatalf("Get = %d, want 101", got)
+	}
+}
+
+func TestCheckpointStore_Get_NotFound(t *testing.T) {
+	store := newTestCheckpointStore(t)
+
+	if _, err := store.Get(context.Background(), models.Ethereum); err != ErrCheckpointNotFound {
+		t.Fatalf("expected ErrCheckpointNotFound, got %v", err)
+	}
+}
+
+func TestCheckpointStore_PerChain(t *testing.T) {
+	store := newTestCheckpointStore(t)
+	ctx := context.Background()
+
+	if err := store.Put(ctx, models.Ethereum, 5); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if _, err := store.Get(ctx, models.Hyperliquid); err != ErrCheckpointNotFound {
+		t.Fatalf("expected ErrCheckpointNotFound for other chain, got %v", err)
+	}
+}
+
+func TestCheckpointStore_BlockHashes(t *testing.T) {
+	store := newTestCheckpointStore(t)
+	ctx := context.Background()
+
+	for n := uint64(1); n <= 5; n++ {
+		if err := store.PutBlock(ctx, models.Ethereum, n, common.BigToHash(new(big.Int).SetUint64(n)), 3); err != nil {
+			t.Fatalf("PutBlock error: %v", err)
+		}
+	}
+	hashes, err := store.BlockHashes(ctx, models.Ethereum)
+	if err != nil {
+		t.Fatalf("BlockHashes error: %v", err)
+	}
+	if len(hashes) != 3 || hashes[3] != common.BigToHash(big.NewInt(3)) || hashes[5] != common.BigToHash(big.NewInt(5)) {
+		t.Fatalf("hashes = %v, want blocks 3-5", hashes)
+	}
+
+	// rewound below recorded hashes
+	if err := store.Put(ctx, models.Ethereum, 4); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if hashes, _ := store.BlockHashes(ctx, models.Ethereum); len(hashes) != 2 || hashes[5] != (common.Hash{}) {
+		t.Fatalf("hashes after rewind = %v, want blocks 3-4", hashes)
+	}
+	if hashes, _ := store.BlockHashes(ctx, models.Hyperliquid); len(hashes) != 0 {
+		t.Fatalf("hashes of other chain = %v", hashes)
+	}
+}
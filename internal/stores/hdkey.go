@@ -0,0 +1,256 @@
+package stores
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"unit/agent/internal/utils/hdwallet"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+const seedLength = 32
+
+// HDKeyStore derives deposit keys from a single scrypt encrypted seed at m/44'/60'/0'/0/index.
+// Addresses are handed out at sequential indices, the index is stored on the account so every key can be re-derived
+// from the seed. Restoring the seed and the account db recovers all deposit keys.
+// The next unused index is persisted next to the seed, a restarted store knows every key it handed out and never hands
+// out an index twice, without waiting for the accounts to be restored.
+type HDKeyStore struct {
+	base      *hdwallet.ExtendedKey // m/44'/60'/0'/0
+	indexPath string                // next unused index, not persisted if empty
+
+	mu      sync.Mutex
+	indices map[common.Address]uint32
+	next    uint32
+}
+
+// NewHDKeyStore decrypts the seed at `path`, a new seed is generated and written there if the file does not exist
+func NewHDKeyStore(passphrase string, path string) (*HDKeyStore, error) {
+	seed, err := loadSeed(passphrase, path)
+	if err != nil {
+		return nil, err
+	}
+	master, err := hdwallet.NewMaster(seed)
+	if err != nil {
+		return nil, err
+	}
+	base, err := master.Derive(accounts.DefaultBaseDerivationPath[:len(accounts.DefaultBaseDerivationPath)-1])
+	if err != nil {
+		return nil, err
+	}
+	s := &HDKeyStore{base: base, indexPath: path + ".index", indices: make(map[common.Address]uint32)}
+
+	next, err := loadNextIndex(s.indexPath)
+	if err != nil {
+		return nil, err
+	}
+	for index := uint32(0); index < next; index++ {
+		addr, err := s.derive(index)
+		if errors.Is(err, hdwallet.ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.indices[addr] = index
+	}
+	s.next = next
+	return s, nil
+}
+
+type hdKeyIndex struct {
+	Next uint32 `json:"next"`
+}
+
+func loadNextIndex(path string) (uint32, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var idx hdKeyIndex
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		return 0, fmt.Errorf("error decoding key index file: %w", err)
+	}
+	return idx.Next, nil
+}
+
+// setNext persists `next` before it is used, a crash in between skips indices rather than reusing them
+func (s *HDKeyStore) setNext(next uint32) error {
+	if s.indexPath != "" {
+		raw, err := json.Marshal(hdKeyIndex{Next: next})
+		if err != nil {
+			return err
+		}
+		tmp := s.indexPath + ".tmp"
+		if err := os.WriteFile(tmp, raw, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, s.indexPath); err != nil {
+			return err
+		}
+	}
+	s.next = next
+	return nil
+}
+
+func loadSeed(passphrase string, path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, seedLength)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		encrypted, err := keystore.EncryptDataV3(seed, []byte(passphrase), keystore.StandardScryptN, keystore.StandardScryptP)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(encrypted)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, raw, 0600); err != nil {
+			return nil, err
+		}
+		return seed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted keystore.CryptoJSON
+	if err := json.Unmarshal(raw, &encrypted); err != nil {
+		return nil, fmt.Errorf("error decoding seed file: %w", err)
+	}
+	seed, err := keystore.DecryptDataV3(encrypted, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting seed: %w", err)
+	}
+	return seed, nil
+}
+
+// CreateKey derives the key at the next unused index
+func (s *HDKeyStore) CreateKey(ctx context.Context) (address string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		index := s.next
+		if err := s.setNext(index + 1); err != nil {
+			return "", err
+		}
+		addr, err := s.derive(index)
+		if errors.Is(err, hdwallet.ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		s.indices[addr] = index
+		return addr.Hex(), nil
+	}
+}
+
+// Restore re-derives the key at `index` and checks it matches `address`. Indices up to it are not handed out again.
+func (s *HDKeyStore) Restore(ctx context.Context, index uint32, address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addr, err := s.derive(index)
+	if err != nil {
+		return err
+	}
+	if addr != common.HexToAddress(address) {
+		return fmt.Errorf("key at index %d is %s, not %s: wrong seed", index, addr.Hex(), address)
+	}
+	if index >= s.next {
+		if err := s.setNext(index + 1); err != nil {
+			return err
+		}
+	}
+	s.indices[addr] = index
+	return nil
+}
+
+func (s *HDKeyStore) HasKey(ctx context.Context, address string) bool {
+	_, ok := s.KeyIndex(ctx, address)
+	return ok
+}
+
+func (s *HDKeyStore) KeyIndex(ctx context.Context, address string) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, ok := s.indices[common.HexToAddress(address)]
+	return index, ok
+}
+
+func (s *HDKeyStore) SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	key, err := s.privateKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), key)
+}
+
+func (s *HDKeyStore) SignHash(ctx context.Context, address string, hash []byte) ([]byte, error) {
+	key, err := s.privateKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(hash, key)
+}
+
+func (s *HDKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing typed data: %v", err)
+	}
+	return s.SignHash(ctx, address, hash)
+}
+
+// privateKey re-derives the key of `address`, keys are not kept in memory between signatures
+func (s *HDKeyStore) privateKey(ctx context.Context, address string) (*ecdsa.PrivateKey, error) {
+	index, ok := s.KeyIndex(ctx, address)
+	if !ok {
+		return nil, fmt.Errorf("address not found: %s", address)
+	}
+	child, err := s.base.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	return child.PrivateKey()
+}
+
+func (s *HDKeyStore) derive(index uint32) (common.Address, error) {
+	if index >= hdwallet.HardenedOffset {
+		return common.Address{}, fmt.Errorf("key index %d out of range", index)
+	}
+	child, err := s.base.Child(index)
+	if err != nil {
+		return common.Address{}, err
+	}
+	key, err := child.PrivateKey()
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(key.PublicKey), nil
+}
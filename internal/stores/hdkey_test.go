@@ -0,0 +1,152 @@
+package stores
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestHDKeyStore_DerivesSameKeysFromSeed(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "seed.json")
+	ks, err := NewHDKeyStore("testpass", path)
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+
+	first, err := ks.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	second, err := ks.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if first == second {
+		t.Fatal("CreateKey returned the same address twice")
+	}
+	if i, ok := ks.KeyIndex(ctx, second); !ok || i != 1 {
+		t.Fatalf("KeyIndex(second) = %d %v, want 1", i, ok)
+	}
+
+	// reopen from the seed file, keys handed out before are known again without restoring them
+	reopened, err := NewHDKeyStore("testpass", path)
+	if err != nil {
+		t.Fatalf("NewHDKeyStore reopen: %v", err)
+	}
+	if i, ok := reopened.KeyIndex(ctx, second); !ok || i != 1 {
+		t.Fatalf("KeyIndex(second) after reopen = %d %v, want 1", i, ok)
+	}
+	if err := reopened.Restore(ctx, 1, second); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if err := reopened.Restore(ctx, 0, second); err == nil {
+		t.Fatal("expected error restoring an address at the wrong index")
+	}
+
+	tx := types.NewTransaction(0, common.HexToAddress("0x1111111111111111111111111111111111111111"), big.NewInt(1), 21000, big.NewInt(1), nil)
+	signed, err := reopened.SignTx(ctx, second, tx, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("SignTx: %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), signed)
+	if err != nil {
+		t.Fatalf("Sender: %v", err)
+	}
+	if sender != common.HexToAddress(second) {
+		t.Fatalf("sender %s, want %s", sender.Hex(), second)
+	}
+
+	// indices handed out before the restart are never handed out again
+	third, err := reopened.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if i, _ := reopened.KeyIndex(ctx, third); i != 2 {
+		t.Fatalf("next index = %d, want 2", i)
+	}
+
+	if err := reopened.Restore(ctx, 5, mustDerive(t, reopened, 5)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restarted, err := NewHDKeyStore("testpass", path)
+	if err != nil {
+		t.Fatalf("NewHDKeyStore restart: %v", err)
+	}
+	if next, err := restarted.CreateKey(ctx); err != nil || !restarted.HasKey(ctx, third) {
+		t.Fatalf("CreateKey = %s, %v, or key handed out before the restart unknown", next, err)
+	} else if i, _ := restarted.KeyIndex(ctx, next); i != 6 {
+		t.Fatalf("index after restoring 5 and restarting = %d, want 6", i)
+	}
+
+	if _, err := NewHDKeyStore("wrongpass", path); err == nil {
+		t.Fatal("expected error decrypting seed with wrong passphrase")
+	}
+}
+
+func mustDerive(t *testing.T, ks *HDKeyStore, index uint32) string {
+	t.Helper()
+	addr, err := ks.derive(index)
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	return addr.Hex()
+}
+
+func TestRestoreKeys(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ks, err := NewHDKeyStore("testpass", filepath.Join(dir, "seed.json"))
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+	as, err := NewLocalAccountStore(filepath.Join(dir, "accounts.db"))
+	if err != nil {
+		t.Fatalf("NewLocalAccountStore: %v", err)
+	}
+	defer as.Close()
+
+	derived := &HDKeyStore{base: ks.base, indices: make(map[common.Address]uint32)}
+	for i := uint32(0); i < 3; i++ {
+		addr, err := derived.CreateKey(ctx)
+		if err != nil {
+			t.Fatalf("CreateKey: %v", err)
+		}
+		if i == 1 {
+			// key of an account created before deposit keys were derived from the seed
+			continue
+		}
+		account, err := models.NewAccount(models.Ethereum, models.Hyperliquid, common.BigToAddress(big.NewInt(int64(i+1))).Hex(), addr)
+		if err != nil {
+			t.Fatalf("NewAccount: %v", err)
+		}
+		index := i
+		account.KeyIndex = &index
+		if err := as.Insert(ctx, *account); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	legacy, err := models.NewAccount(models.Ethereum, models.Hyperliquid, "0x9999999999999999999999999999999999999999", "0x8888888888888888888888888888888888888888")
+	if err != nil {
+		t.Fatalf("NewAccount: %v", err)
+	}
+	if err := as.Insert(ctx, *legacy); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	if err := RestoreKeys(ctx, ks, as); err != nil {
+		t.Fatalf("RestoreKeys: %v", err)
+	}
+	if len(ks.indices) != 2 || ks.next != 3 {
+		t.Fatalf("restored %d keys next=%d, want 2 keys next=3", len(ks.indices), ks.next)
+	}
+	if ks.HasKey(ctx, legacy.DepositAddr.Hex()) {
+		t.Fatal("legacy key restored")
+	}
+}
@@ -8,16 +8,40 @@ import (
 	"os"
 	"time"
 
+	"unit/agent/internal/models"
+
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 type IKeyStore interface {
 	CreateKey(ctx context.Context) (address string, err error)
 	HasKey(ctx context.Context, address string) bool
+	// KeyIndex returns the derivation index of a key derived from a seed, ok is false for keys that were not
+	KeyIndex(ctx context.Context, address string) (index uint32, ok bool)
 	SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	// SignTypedData signs the EIP-712 hash of `td`, the signature is [R || S || V] with V 0 or 1
+	SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error)
+}
+
+// IKeyRestorer is implemented by key stores deriving keys from a seed
+type IKeyRestorer interface {
+	// Restore re-derives the key at `index` and checks it matches `address`
+	Restore(ctx context.Context, index uint32, address string) error
+}
+
+// RestoreKeys restores the keys of every account whose deposit address was derived from a seed.
+// Run on startup before new keys are created.
+func RestoreKeys(ctx context.Context, ks IKeyRestorer, as IAccountStore) error {
+	return as.Scan(ctx, func(a *models.Account) error {
+		if a.KeyIndex == nil {
+			return nil
+		}
+		return ks.Restore(ctx, *a.KeyIndex, a.DepositAddr.Hex())
+	})
 }
 
 type LocalKeyStore struct {
@@ -48,6 +72,11 @@ func (l *LocalKeyStore) HasKey(ctx context.Context, address string) bool {
 	return l.ks.HasAddress(common.HexToAddress(address))
 }
 
+func (l *LocalKeyStore) KeyIndex(ctx context.Context, address string) (uint32, bool) {
+	// every key is a separate random key file
+	return 0, false
+}
+
 func (l *LocalKeyStore) SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
 	account, err := l.get(address)
 	if err != nil {
@@ -84,6 +113,14 @@ func (l *LocalKeyStore) SignHash(ctx context.Context, address string, hash []byt
 	return signedHash, nil
 }
 
+func (l *LocalKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing typed data: %v", err)
+	}
+	return l.SignHash(ctx, address, hash)
+}
+
 func (l *LocalKeyStore) ImportECDSA(privKey *ecdsa.PrivateKey, password string) (string, error) {
 	acct, err := l.ks.ImportECDSA(privKey, password)
 	if err != nil {
@@ -103,3 +140,64 @@ func (l *LocalKeyStore) get(address string) (accounts.Account, error) {
 	}
 	return account, nil
 }
+
+// MultiKeyStore creates keys in its first store and signs with whichever store holds the key, e.g. deposit keys
+// derived from a seed next to an imported hot wallet key
+type MultiKeyStore struct {
+	stores []IKeyStore
+}
+
+func NewMultiKeyStore(primary IKeyStore, others ...IKeyStore) *MultiKeyStore {
+	return &MultiKeyStore{stores: append([]IKeyStore{primary}, others...)}
+}
+
+func (m *MultiKeyStore) CreateKey(ctx context.Context) (address string, err error) {
+	return m.stores[0].CreateKey(ctx)
+}
+
+func (m *MultiKeyStore) HasKey(ctx context.Context, address string) bool {
+	_, ok := m.find(ctx, address)
+	return ok
+}
+
+func (m *MultiKeyStore) KeyIndex(ctx context.Context, address string) (uint32, bool) {
+	s, ok := m.find(ctx, address)
+	if !ok {
+		return 0, false
+	}
+	return s.KeyIndex(ctx, address)
+}
+
+// Restore restores the key in the store new keys are created in
+func (m *MultiKeyStore) Restore(ctx context.Context, index uint32, address string) error {
+	r, ok := m.stores[0].(IKeyRestorer)
+	if !ok {
+		return fmt.Errorf("key store does not derive keys from a seed")
+	}
+	return r.Restore(ctx, index, address)
+}
+
+func (m *MultiKeyStore) SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	s, ok := m.find(ctx, address)
+	if !ok {
+		return nil, fmt.Errorf("address not found: %s", address)
+	}
+	return s.SignTx(ctx, address, tx, chainID)
+}
+
+func (m *MultiKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	s, ok := m.find(ctx, address)
+	if !ok {
+		return nil, fmt.Errorf("address not found: %s", address)
+	}
+	return s.SignTypedData(ctx, address, td)
+}
+
+func (m *MultiKeyStore) find(ctx context.Context, address string) (IKeyStore, bool) {
+	for _, s := range m.stores {
+		if s.HasKey(ctx, address) {
+			return s, true
+		}
+	}
+	return nil, false
+}
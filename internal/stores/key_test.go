@@ -8,8 +8,10 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 func newTestKeyStore(t *testing.T) *LocalKeyStore {
@@ -151,3 +153,122 @@ func TestImportECDSA(t *testing.T) {
 		t.Fatalf("HasKey(%s) = false, want true", addrHex)
 	}
 }
+
+func TestMultiKeyStore_SignsWithOwningStore(t *testing.T) {
+	ctx := context.Background()
+	hd, err := NewHDKeyStore("testpass", filepath.Join(t.TempDir(), "seed.json"))
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+	local := newTestKeyStore(t)
+	priv, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	hot, err := local.ImportECDSA(priv, "testpass")
+	if err != nil {
+		t.Fatalf("ImportECDSA: %v", err)
+	}
+	ks := NewMultiKeyStore(hd, local)
+
+	deposit, err := ks.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if !hd.HasKey(ctx, deposit) || local.HasKey(ctx, deposit) {
+		t.Fatal("new key not created in the primary store")
+	}
+	if i, ok := ks.KeyIndex(ctx, deposit); !ok || i != 0 {
+		t.Fatalf("KeyIndex(deposit) = %d %v, want 0", i, ok)
+	}
+	if _, ok := ks.KeyIndex(ctx, hot); ok {
+		t.Fatal("imported key has a derivation index")
+	}
+
+	chainID := big.NewInt(1)
+	for _, addr := range []string{deposit, hot} {
+		tx := types.NewTransaction(0, common.HexToAddress("0x1111111111111111111111111111111111111111"), big.NewInt(1), 21000, big.NewInt(1), nil)
+		signed, err := ks.SignTx(ctx, addr, tx, chainID)
+		if err != nil {
+			t.Fatalf("SignTx(%s): %v", addr, err)
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+		if err != nil || sender != common.HexToAddress(addr) {
+			t.Fatalf("sender = %s err=%v, want %s", sender.Hex(), err, addr)
+		}
+	}
+	if ks.HasKey(ctx, "0x000000000000000000000000000000000000dEaD") {
+		t.Fatal("HasKey true for unknown address")
+	}
+}
+
+func testTypedData() apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"HyperliquidTransaction:SpotSend": {
+				{Name: "destination", Type: "string"},
+				{Name: "amount", Type: "string"},
+				{Name: "time", Type: "uint64"},
+			},
+		},
+		PrimaryType: "HyperliquidTransaction:SpotSend",
+		Domain: apitypes.TypedDataDomain{
+			Name:              "HyperliquidSignTransaction",
+			Version:           "1",
+			ChainId:           math.NewHexOrDecimal256(0x66eee),
+			VerifyingContract: "0x0000000000000000000000000000000000000000",
+		},
+		Message: apitypes.TypedDataMessage{
+			"destination": "0x1111111111111111111111111111111111111111",
+			"amount":      "1.5",
+			"time":        big.NewInt(1716531066415),
+		},
+	}
+}
+
+func TestSignTypedData_RecoversSigner(t *testing.T) {
+	ctx := context.Background()
+	hd, err := NewHDKeyStore("testpass", filepath.Join(t.TempDir(), "seed.json"))
+	if err != nil {
+		t.Fatalf("NewHDKeyStore: %v", err)
+	}
+	local := newTestKeyStore(t)
+	ks := NewMultiKeyStore(hd, local)
+
+	deposit, err := ks.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	hot, err := local.CreateKey(ctx)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	td := testTypedData()
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		t.Fatalf("TypedDataAndHash: %v", err)
+	}
+	for _, addr := range []string{deposit, hot} {
+		sig, err := ks.SignTypedData(ctx, addr, td)
+		if err != nil {
+			t.Fatalf("SignTypedData(%s): %v", addr, err)
+		}
+		pub, err := crypto.SigToPub(hash, sig)
+		if err != nil {
+			t.Fatalf("SigToPub: %v", err)
+		}
+		if got := crypto.PubkeyToAddress(*pub); got != common.HexToAddress(addr) {
+			t.Fatalf("recovered %s, want %s", got.Hex(), addr)
+		}
+	}
+	if _, err := ks.SignTypedData(ctx, "0x000000000000000000000000000000000000dEaD", td); err == nil {
+		t.Fatal("expected error signing with unknown address")
+	}
+}
@@ -0,0 +1,175 @@
+package stores
+
+import (
+	"context"
+	"encoding/binary"
+
+	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNonces = []byte("nonces")
+
+	keyNextNonce         = []byte("next")
+	bucketReleasedNonces = []byte("released")
+)
+
+// INonceStore allocates tx nonces per (chain, address) so concurrent builds from the same wallet never share a nonce.
+// `pending` is the chain's pending nonce for the address, the allocator never hands out nonces below it.
+type INonceStore interface {
+	// Reserve returns the lowest released nonce, or the next unallocated one
+	Reserve(ctx context.Context, chain models.Chain, address string, pending uint64) (uint64, error)
+	// Release returns a reserved nonce whose tx was abandoned before it was broadcast, it is handed out again to fill the gap
+	Release(ctx context.Context, chain models.Chain, address string, nonce uint64) error
+	// Sync rebuilds the allocation from the chain. Nonces from `pending` on that are not in `inUse` (built, not yet broadcast) are released.
+	Sync(ctx context.Context, chain models.Chain, address string, pending uint64, inUse []uint64) error
+}
+
+// LocalNonceStore keeps a bucket per (chain, address) holding the next unallocated nonce and the set of released nonces
+type LocalNonceStore struct {
+	db *bolt.DB
+}
+
+func NewLocalNonceStore(path string) (*LocalNonceStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketNonces)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &LocalNonceStore{db: db}, nil
+}
+
+func (s *LocalNonceStore) Reserve(ctx context.Context, chain models.Chain, address string, pending uint64) (uint64, error) {
+	var nonce uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		wallet, released, err := walletBuckets(tx, chain, address)
+		if err != nil {
+			return err
+		}
+
+		// released nonces below pending were used by txs sent outside the allocator, drop them on the way
+		var taken [][]byte
+		found := false
+		c := released.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			taken = append(taken, append([]byte(nil), k...))
+			if n := binary.BigEndian.Uint64(k); n >= pending {
+				nonce, found = n, true
+				break
+			}
+		}
+		for _, k := range taken {
+			if err := released.Delete(k); err != nil {
+				return err
+			}
+		}
+		if found {
+			return nil
+		}
+
+		next := max(getUint64(wallet, keyNextNonce), pending)
+		nonce = next
+		return putUint64(wallet, keyNextNonce, next+1)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return nonce, nil
+}
+
+func (s *LocalNonceStore) Release(ctx context.Context, chain models.Chain, address string, nonce uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		wallet, released, err := walletBuckets(tx, chain, address)
+		if err != nil {
+			return err
+		}
+		if nonce >= getUint64(wallet, keyNextNonce) {
+			// never handed out by this allocator
+			return nil
+		}
+		return released.Put(nonceKey(nonce), []byte{})
+	})
+}
+
+func (s *LocalNonceStore) Sync(ctx context.Context, chain models.Chain, address string, pending uint64, inUse []uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		walletKey := nonceWalletKey(chain, address)
+		root := tx.Bucket(bucketNonces)
+		if root.Bucket(walletKey) != nil {
+			if err := root.DeleteBucket(walletKey); err != nil {
+				return err
+			}
+		}
+		wallet, released, err := walletBuckets(tx, chain, address)
+		if err != nil {
+			return err
+		}
+
+		used := make(map[uint64]struct{}, len(inUse))
+		next := pending
+		for _, n := range inUse {
+			if n < pending {
+				continue
+			}
+			used[n] = struct{}{}
+			next = max(next, n+1)
+		}
+		for n := pending; n < next; n++ {
+			if _, ok := used[n]; ok {
+				continue
+			}
+			if err := released.Put(nonceKey(n), []byte{}); err != nil {
+				return err
+			}
+		}
+		return putUint64(wallet, keyNextNonce, next)
+	})
+}
+
+func (s *LocalNonceStore) Close() error {
+	return s.db.Close()
+}
+
+func walletBuckets(tx *bolt.Tx, chain models.Chain, address string) (wallet *bolt.Bucket, released *bolt.Bucket, err error) {
+	wallet, err = tx.Bucket(bucketNonces).CreateBucketIfNotExists(nonceWalletKey(chain, address))
+	if err != nil {
+		return nil, nil, err
+	}
+	released, err = wallet.CreateBucketIfNotExists(bucketReleasedNonces)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wallet, released, nil
+}
+
+func nonceWalletKey(chain models.Chain, address string) []byte {
+	return []byte(string(chain) + "|" + common.HexToAddress(address).Hex())
+}
+
+// big endian so the released bucket iterates lowest nonce first
+func nonceKey(n uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, n)
+	return k
+}
+
+func getUint64(b *bolt.Bucket, key []byte) uint64 {
+	v := b.Get(key)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func putUint64(b *bolt.Bucket, key []byte, n uint64) error {
+	return b.Put(key, nonceKey(n))
+}
@@ -0,0 +1,147 @@
+package stores
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"unit/agent/internal/models"
+)
+
+const testWallet = "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+func newTestNonceStore(t *testing.T) *LocalNonceStore {
+	t.Helper()
+	s, err := NewLocalNonceStore(filepath.Join(t.TempDir(), "nonces.db"))
+	if err != nil {
+		t.Fatalf("NewLocalNonceStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func reserve(t *testing.T, s *LocalNonceStore, pending uint64) uint64 {
+	t.Helper()
+	n, err := s.Reserve(context.Background(), models.Ethereum, testWallet, pending)
+	if err != nil {
+		t.Fatalf("Reserve error: %v", err)
+	}
+	return n
+}
+
+func TestNonceStore_ReserveIsSequential(t *testing.T) {
+	s := newTestNonceStore(t)
+
+	// nothing broadcast yet, the chain's pending nonce does not move
+	for want := uint64(5); want < 8; want++ {
+		if got := reserve(t, s, 5); got != want {
+			t.Fatalf("Reserve = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestNonceStore_ReserveConcurrentUnique(t *testing.T) {
+	s := newTestNonceStore(t)
+
+	var mu sync.Mutex
+	seen := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := s.Reserve(context.Background(), models.Ethereum, testWallet, 0)
+			if err != nil {
+				t.Errorf("Reserve error: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[n] {
+				t.Errorf("nonce %d reserved twice", n)
+			}
+			seen[n] = true
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNonceStore_ReleaseIsReused(t *testing.T) {
+	s := newTestNonceStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		reserve(t, s, 0)
+	}
+	if err := s.Release(ctx, models.Ethereum, testWallet, 2); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if err := s.Release(ctx, models.Ethereum, testWallet, 1); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	// never reserved, ignored
+	if err := s.Release(ctx, models.Ethereum, testWallet, 10); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+
+	for _, want := range []uint64{1, 2, 4} {
+		if got := reserve(t, s, 0); got != want {
+			t.Fatalf("Reserve = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestNonceStore_ReserveSkipsNoncesUsedOnChain(t *testing.T) {
+	s := newTestNonceStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		reserve(t, s, 0)
+	}
+	if err := s.Release(ctx, models.Ethereum, testWallet, 1); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+
+	// txs sent outside the allocator moved the chain to 10
+	if got := reserve(t, s, 10); got != 10 {
+		t.Fatalf("Reserve = %d, want 10", got)
+	}
+	if got := reserve(t, s, 10); got != 11 {
+		t.Fatalf("Reserve = %d, want 11", got)
+	}
+}
+
+func TestNonceStore_SyncReleasesGaps(t *testing.T) {
+	s := newTestNonceStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		reserve(t, s, 0)
+	}
+
+	// chain has 3 txs, nonces 4 and 6 are built and waiting to be broadcast, 3 and 5 leaked
+	if err := s.Sync(ctx, models.Ethereum, testWallet, 3, []uint64{1, 4, 6}); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	for _, want := range []uint64{3, 5, 7} {
+		if got := reserve(t, s, 3); got != want {
+			t.Fatalf("Reserve = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestNonceStore_WalletsAreIndependent(t *testing.T) {
+	s := newTestNonceStore(t)
+	ctx := context.Background()
+
+	reserve(t, s, 0)
+	n, err := s.Reserve(ctx, models.Ethereum, "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 0)
+	if err != nil || n != 0 {
+		t.Fatalf("Reserve = %d, %v, want 0", n, err)
+	}
+	n, err = s.Reserve(ctx, "arbitrum", testWallet, 0)
+	if err != nil || n != 0 {
+		t.Fatalf("Reserve = %d, %v, want 0", n, err)
+	}
+}
@@ -0,0 +1,86 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"unit/agent/internal/clients"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// RemoteKeyStore keeps no keys, every operation is forwarded to the signer service (cmd/signer)
+type RemoteKeyStore struct {
+	client *clients.SignerClient
+}
+
+func NewRemoteKeyStore(client *clients.SignerClient) *RemoteKeyStore {
+	return &RemoteKeyStore{client: client}
+}
+
+func (r *RemoteKeyStore) CreateKey(ctx context.Context) (address string, err error) {
+	var resp clients.SignerKeyResponse
+	if err := r.client.Call(ctx, "/keys/create", struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Address, nil
+}
+
+func (r *RemoteKeyStore) HasKey(ctx context.Context, address string) bool {
+	var resp clients.SignerKeyResponse
+	if err := r.client.Call(ctx, "/keys/has", clients.SignerKeyRequest{Address: address}, &resp); err != nil {
+		fmt.Printf("signer error looking up key %s: %v\n", address, err)
+		return false
+	}
+	return resp.Ok
+}
+
+func (r *RemoteKeyStore) KeyIndex(ctx context.Context, address string) (uint32, bool) {
+	var resp clients.SignerKeyResponse
+	if err := r.client.Call(ctx, "/keys/index", clients.SignerKeyRequest{Address: address}, &resp); err != nil {
+		fmt.Printf("signer error looking up key index %s: %v\n", address, err)
+		return 0, false
+	}
+	return resp.Index, resp.Ok
+}
+
+func (r *RemoteKeyStore) Restore(ctx context.Context, index uint32, address string) error {
+	return r.client.Call(ctx, "/keys/restore", clients.SignerKeyRequest{Address: address, Index: index}, nil)
+}
+
+func (r *RemoteKeyStore) SignTx(ctx context.Context, address string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling tx: %v", err)
+	}
+	var resp clients.SignerTxResponse
+	if err := r.client.Call(ctx, "/sign/tx", clients.SignerTxRequest{Address: address, Tx: common.Bytes2Hex(raw), ChainID: chainID.String()}, &resp); err != nil {
+		return nil, err
+	}
+
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(common.Hex2Bytes(resp.Tx)); err != nil {
+		return nil, fmt.Errorf("error unmarshaling signed tx: %v", err)
+	}
+	// never broadcast something other than what was asked to be signed
+	if signer := types.LatestSignerForChainID(chainID); signer.Hash(signed) != signer.Hash(tx) {
+		return nil, fmt.Errorf("signer returned a different tx")
+	}
+	return signed, nil
+}
+
+func (r *RemoteKeyStore) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	var resp clients.SignerTypedDataResponse
+	if err := r.client.Call(ctx, "/sign/typed", clients.SignerTypedDataRequest{Address: address, TypedData: td}, &resp); err != nil {
+		return nil, err
+	}
+	sig, err := hexutil.Decode(resp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding signature: %v", err)
+	}
+	return sig, nil
+}
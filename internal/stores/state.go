@@ -1,28 +1,79 @@
 package stores
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"unit/agent/internal/models"
 
+	"github.com/ethereum/go-ethereum/common"
 	bolt "go.etcd.io/bbolt"
 )
 
 var (
-	bucketDeposits = []byte("deposits")
+	bucketDeposits      = []byte("deposits")
+	bucketDepositEvents = []byte("deposit_events")
+	bucketDepositsDue   = []byte("deposits_due") // index of non-terminal deposits keyed by state and next run time
+	// index of deposits below the minimum keyed by deposit address and asset
+	bucketDepositsBelowMinimum = []byte("deposits_below_minimum")
+	bucketDepositsByState      = []byte("deposits_by_state") // index of all deposits keyed by state and ID
+	bucketDepositsByDst        = []byte("deposits_by_dst")   // index of all deposits keyed by destination address and ID
 
 	ErrExecutionNotFound = errors.New("execution not found")
 )
 
 type IStateStore interface {
-	PutIfAbsent(ctx context.Context, state *models.DepositState) error
+	// PutIfAbsent stores the deposit unless one with its ID exists, inserted reports whether it was stored
+	PutIfAbsent(ctx context.Context, state *models.DepositState) (inserted bool, err error)
 	Put(ctx context.Context, state *models.DepositState) error
 	Get(ctx context.Context, id string) (*models.DepositState, error)
 	Scan(ctx context.Context, visit func(*models.DepositState) error) error
+	// Due visits non-terminal deposits eligible to run at `now`, terminal deposits are never visited
+	Due(ctx context.Context, now time.Time, visit func(*models.DepositState) error) error
+	Events(ctx context.Context, id string) ([]models.DepositEvent, error)
+	// List returns deposits matching the filter in ID order, starting after the deposit with ID `after`. At most `limit`
+	// deposits are returned, next is the ID to pass as `after` for the following page and empty when the page is not full.
+	List(ctx context.Context, filter DepositFilter, after string, limit int) (deposits []*models.DepositState, next string, err error)
+	// BelowMinimum visits deposits of `asset` to `depositAddr` that ended below the minimum deposit amount
+	BelowMinimum(ctx context.Context, depositAddr common.Address, asset string, visit func(*models.DepositState) error) error
 }
 
+// DepositFilter selects deposits by their fields, zero fields match every deposit
+type DepositFilter struct {
+	DepositAddr common.Address
+	DstAddr     common.Address
+	State       models.State
+}
+
+func (f DepositFilter) Match(st *models.DepositState) bool {
+	return (f.DepositAddr == common.Address{} || st.DepositAddr == f.DepositAddr) &&
+		(f.DstAddr == common.Address{} || st.DstAddr == f.DstAddr) &&
+		(f.State == "" || st.State == f.State)
+}
+
+// index picks the bucket serving the filter. Keys of the bucket are keyPrefix | id and the matching deposits share
+// prefix. Deposits are keyed by ID and IDs start with the deposit address, without one the state or destination
+// index narrows the scan.
+func (f DepositFilter) index() (bucket, keyPrefix, prefix []byte) {
+	switch {
+	case f.DepositAddr != (common.Address{}):
+		return bucketDeposits, nil, []byte(f.DepositAddr.Hex() + "|")
+	case f.State != "":
+		p := stateIndexPrefix(f.State)
+		return bucketDepositsByState, p, p
+	case f.DstAddr != (common.Address{}):
+		return bucketDepositsByDst, f.DstAddr.Bytes(), f.DstAddr.Bytes()
+	}
+	return bucketDeposits, nil, nil
+}
+
+// LocalStateStore is an append only event store. Every write appends an immutable event to the deposit's log,
+// the `deposits` bucket only holds the latest projection and can be rebuilt from the log with Replay.
 type LocalStateStore struct {
 	db *bolt.DB
 }
@@ -36,6 +87,26 @@ func NewLocalStateStore(path string) (*LocalStateStore, error) {
 		if _, err := tx.CreateBucketIfNotExists(bucketDeposits); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(bucketDepositEvents); err != nil {
+			return err
+		}
+		if err := migrateEventLogs(tx); err != nil {
+			return err
+		}
+		// stores created before an index existed get it built from the current projections
+		if tx.Bucket(bucketDepositsDue) == nil {
+			if err := rebuildDueIndex(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(bucketDepositsBelowMinimum) == nil {
+			if err := rebuildBelowMinimumIndex(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(bucketDepositsByState) == nil || tx.Bucket(bucketDepositsByDst) == nil {
+			return rebuildListIndexes(tx)
+		}
 		return nil
 	}); err != nil {
 		_ = db.Close()
@@ -44,27 +115,39 @@ func NewLocalStateStore(path string) (*LocalStateStore, error) {
 	return &LocalStateStore{db: db}, nil
 }
 
-func (s *LocalStateStore) PutIfAbsent(ctx context.Context, state *models.DepositState) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketDeposits)
-		if b.Get([]byte(state.ID)) != nil {
+func (s *LocalStateStore) PutIfAbsent(ctx context.Context, state *models.DepositState) (inserted bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketDeposits).Get([]byte(state.ID)) != nil {
 			return nil
 		}
-		blob, err := json.Marshal(state)
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(state.ID), blob)
+		inserted = true
+		return s.append(tx, nil, state)
 	})
+	return inserted && err == nil, err
 }
 
 func (s *LocalStateStore) Put(ctx context.Context, state *models.DepositState) error {
-	blob, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
 	return s.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bucketDeposits).Put([]byte(state.ID), blob)
+		var prev *models.DepositState
+		if v := tx.Bucket(bucketDeposits).Get([]byte(state.ID)); v != nil {
+			prev = new(models.DepositState)
+			if err := json.Unmarshal(v, prev); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketDepositsDue).Delete(dueKey(prev)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketDepositsBelowMinimum).Delete(belowMinimumKey(prev)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketDepositsByState).Delete(stateIndexKey(prev)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketDepositsByDst).Delete(dstIndexKey(prev)); err != nil {
+				return err
+			}
+		}
+		return s.append(tx, prev, state)
 	})
 }
 
@@ -104,6 +187,427 @@ func (s *LocalStateStore) Scan(ctx context.Context, visit func(*models.DepositSt
 	})
 }
 
+func (s *LocalStateStore) Due(ctx context.Context, now time.Time, visit func(*models.DepositState) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		deposits := tx.Bucket(bucketDeposits)
+		c := tx.Bucket(bucketDepositsDue).Cursor()
+
+		// keys are grouped by state and sorted by run time within a state,
+		// once an entry is not due yet skip ahead to the next state
+		k, v := c.First()
+		for k != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			state, runAt := parseDueKey(k)
+			if runAt.After(now) {
+				next := make([]byte, 0, len(state)+1)
+				k, v = c.Seek(append(append(next, state...), 0x01))
+				continue
+			}
+
+			blob := deposits.Get(v)
+			if blob == nil {
+				return fmt.Errorf("index references missing deposit %s", v)
+			}
+			var st models.DepositState
+			if err := json.Unmarshal(blob, &st); err != nil {
+				return err
+			}
+			if err := visit(&st); err != nil {
+				return err
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+}
+
+func (s *LocalStateStore) List(ctx context.Context, filter DepositFilter, after string, limit int) ([]*models.DepositState, string, error) {
+	var deposits []*models.DepositState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket, keyPrefix, prefix := filter.index()
+		projections := tx.Bucket(bucketDeposits)
+		c := tx.Bucket(bucket).Cursor()
+		k, v := c.Seek(prefix)
+		if after != "" {
+			afterKey := append(append([]byte{}, keyPrefix...), after...)
+			if bytes.Compare(afterKey, prefix) >= 0 {
+				k, v = c.Seek(afterKey)
+				if k != nil && bytes.Equal(k, afterKey) {
+					k, v = c.Next()
+				}
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(deposits) < limit; k, v = c.Next() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			blob := v
+			if keyPrefix != nil {
+				if blob = projections.Get(v); blob == nil {
+					return fmt.Errorf("index references missing deposit %s", v)
+				}
+			}
+			var st models.DepositState
+			if err := json.Unmarshal(blob, &st); err != nil {
+				return err
+			}
+			if filter.Match(&st) {
+				deposits = append(deposits, &st)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(deposits) < limit {
+		return deposits, "", nil
+	}
+	return deposits, deposits[len(deposits)-1].ID, nil
+}
+
+func (s *LocalStateStore) BelowMinimum(ctx context.Context, depositAddr common.Address, asset string, visit func(*models.DepositState) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		deposits := tx.Bucket(bucketDeposits)
+		c := tx.Bucket(bucketDepositsBelowMinimum).Cursor()
+		prefix := belowMinimumPrefix(depositAddr, asset)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			blob := deposits.Get(v)
+			if blob == nil {
+				return fmt.Errorf("index references missing deposit %s", v)
+			}
+			var st models.DepositState
+			if err := json.Unmarshal(blob, &st); err != nil {
+				return err
+			}
+			if err := visit(&st); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Events returns the ordered event log for a deposit.
+func (s *LocalStateStore) Events(ctx context.Context, id string) ([]models.DepositEvent, error) {
+	var events []models.DepositEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDepositEvents).Bucket([]byte(id))
+		if b == nil {
+			return ErrExecutionNotFound
+		}
+		var err error
+		events, err = readEvents(b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Replay drops all projections and their indexes and rebuilds them from scratch by replaying every deposit's event log.
+// Polls are not logged, a replayed deposit keeps the poll timestamps of the projection it replaces when they are later.
+func (s *LocalStateStore) Replay(ctx context.Context) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		polled := make(map[string]models.DepositState)
+		if err := tx.Bucket(bucketDeposits).ForEach(func(k, v []byte) error {
+			var st models.DepositState
+			if json.Unmarshal(v, &st) == nil {
+				polled[string(k)] = st
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(bucketDeposits); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		projections, err := tx.CreateBucket(bucketDeposits)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(bucketDepositEvents).ForEachBucket(func(id []byte) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			events, err := readEvents(tx.Bucket(bucketDepositEvents).Bucket(id))
+			if err != nil {
+				return err
+			}
+			state, err := models.Project(events)
+			if err != nil {
+				return err
+			}
+			if state == nil {
+				return nil
+			}
+			if prev, ok := polled[string(id)]; ok {
+				if prev.UpdatedAt.After(state.UpdatedAt) {
+					state.UpdatedAt = prev.UpdatedAt
+				}
+				if prev.NextAttemptAt.After(state.NextAttemptAt) {
+					state.NextAttemptAt = prev.NextAttemptAt
+				}
+			}
+			blob, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			count++
+			return projections.Put(id, blob)
+		})
+		if err != nil {
+			return err
+		}
+		if err := rebuildDueIndex(tx); err != nil {
+			return err
+		}
+		if err := rebuildBelowMinimumIndex(tx); err != nil {
+			return err
+		}
+		return rebuildListIndexes(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (s *LocalStateStore) Close() error {
 	return s.db.Close()
 }
+
+// append writes the event changing `prev` into `state` and the resulting projection in the same transaction. A write
+// that only moves the poll timestamps appends no event, it updates the projection and the due index.
+func (s *LocalStateStore) append(tx *bolt.Tx, prev *models.DepositState, state *models.DepositState) error {
+	event, ok, err := models.NewDepositEvent(prev, state)
+	if err != nil {
+		return err
+	}
+	if ok {
+		log, err := tx.Bucket(bucketDepositEvents).CreateBucketIfNotExists([]byte(state.ID))
+		if err != nil {
+			return err
+		}
+		seq, err := log.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.Seq = seq
+		eventBlob, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := log.Put(seqKey(seq), eventBlob); err != nil {
+			return err
+		}
+	}
+
+	blob, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketDeposits).Put([]byte(state.ID), blob); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketDepositsByState).Put(stateIndexKey(state), []byte(state.ID)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketDepositsByDst).Put(dstIndexKey(state), []byte(state.ID)); err != nil {
+		return err
+	}
+	if state.State == models.StateBelowMinimum {
+		return tx.Bucket(bucketDepositsBelowMinimum).Put(belowMinimumKey(state), []byte(state.ID))
+	}
+	if state.State.IsTerminal() {
+		return nil
+	}
+	return tx.Bucket(bucketDepositsDue).Put(dueKey(state), []byte(state.ID))
+}
+
+// migrateEventLogs logs deposits stored before the event log existed. A deposit without a log, or whose log does not
+// start with its creation because it only changed since, gets a created event holding its current projection at
+// seq 0, ahead of any logged event. Replaying the log then ends in the current projection.
+func migrateEventLogs(tx *bolt.Tx) error {
+	logs := tx.Bucket(bucketDepositEvents)
+	return tx.Bucket(bucketDeposits).ForEach(func(k, v []byte) error {
+		log := logs.Bucket(k)
+		if log != nil {
+			first, blob := log.Cursor().First()
+			if first != nil {
+				var e models.DepositEvent
+				if err := json.Unmarshal(blob, &e); err != nil {
+					return err
+				}
+				if e.Type == models.EventCreated {
+					return nil
+				}
+			}
+		}
+
+		var st models.DepositState
+		if err := json.Unmarshal(v, &st); err != nil {
+			return err
+		}
+		event, _, err := models.NewDepositEvent(nil, &st)
+		if err != nil {
+			return err
+		}
+		if !st.CreatedAt.IsZero() {
+			event.At = st.CreatedAt
+		}
+		blob, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if log == nil {
+			if log, err = logs.CreateBucket(k); err != nil {
+				return err
+			}
+		}
+		return log.Put(seqKey(0), blob)
+	})
+}
+
+func rebuildDueIndex(tx *bolt.Tx) error {
+	if err := tx.DeleteBucket(bucketDepositsDue); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	idx, err := tx.CreateBucket(bucketDepositsDue)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketDeposits).ForEach(func(k, v []byte) error {
+		var st models.DepositState
+		if err := json.Unmarshal(v, &st); err != nil {
+			return err
+		}
+		if st.State.IsTerminal() {
+			return nil
+		}
+		return idx.Put(dueKey(&st), k)
+	})
+}
+
+func rebuildBelowMinimumIndex(tx *bolt.Tx) error {
+	if err := tx.DeleteBucket(bucketDepositsBelowMinimum); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	idx, err := tx.CreateBucket(bucketDepositsBelowMinimum)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketDeposits).ForEach(func(k, v []byte) error {
+		var st models.DepositState
+		if err := json.Unmarshal(v, &st); err != nil {
+			return err
+		}
+		if st.State != models.StateBelowMinimum {
+			return nil
+		}
+		return idx.Put(belowMinimumKey(&st), k)
+	})
+}
+
+func rebuildListIndexes(tx *bolt.Tx) error {
+	for _, name := range [][]byte{bucketDepositsByState, bucketDepositsByDst} {
+		if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+	}
+	byState, err := tx.CreateBucket(bucketDepositsByState)
+	if err != nil {
+		return err
+	}
+	byDst, err := tx.CreateBucket(bucketDepositsByDst)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketDeposits).ForEach(func(k, v []byte) error {
+		var st models.DepositState
+		if err := json.Unmarshal(v, &st); err != nil {
+			return err
+		}
+		if err := byState.Put(stateIndexKey(&st), k); err != nil {
+			return err
+		}
+		return byDst.Put(dstIndexKey(&st), k)
+	})
+}
+
+// stateIndexKey is state | 0x00 | id
+func stateIndexKey(st *models.DepositState) []byte {
+	return append(stateIndexPrefix(st.State), st.ID...)
+}
+
+func stateIndexPrefix(state models.State) []byte {
+	k := make([]byte, 0, len(state)+1)
+	k = append(k, state...)
+	return append(k, 0x00)
+}
+
+// dstIndexKey is destination address | id
+func dstIndexKey(st *models.DepositState) []byte {
+	return append(st.DstAddr.Bytes(), st.ID...)
+}
+
+// belowMinimumKey is deposit address | 0x00 | asset | 0x00 | id
+func belowMinimumKey(st *models.DepositState) []byte {
+	return append(belowMinimumPrefix(st.DepositAddr, st.Asset), st.ID...)
+}
+
+func belowMinimumPrefix(depositAddr common.Address, asset string) []byte {
+	k := make([]byte, 0, len(depositAddr)+1+len(asset)+1)
+	k = append(k, depositAddr.Bytes()...)
+	k = append(k, 0x00)
+	k = append(k, asset...)
+	return append(k, 0x00)
+}
+
+// dueKey is state | 0x00 | run time (unix nanos, big endian) | id
+func dueKey(st *models.DepositState) []byte {
+	k := make([]byte, 0, len(st.State)+1+8+len(st.ID))
+	k = append(k, st.State...)
+	k = append(k, 0x00)
+	var nanos uint64
+	if t := st.RunAt(); t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+	k = binary.BigEndian.AppendUint64(k, nanos)
+	return append(k, st.ID...)
+}
+
+func parseDueKey(k []byte) (state []byte, runAt time.Time) {
+	i := bytes.IndexByte(k, 0x00)
+	return k[:i], time.Unix(0, int64(binary.BigEndian.Uint64(k[i+1:i+9])))
+}
+
+func readEvents(b *bolt.Bucket) ([]models.DepositEvent, error) {
+	var events []models.DepositEvent
+	err := b.ForEach(func(k, v []byte) error {
+		var e models.DepositEvent
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
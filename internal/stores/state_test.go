@@ -6,8 +6,12 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"unit/agent/internal/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	bolt "go.etcd.io/bbolt"
 )
 
 func newTestStateStore(t *testing.T) *LocalStateStore {
@@ -60,13 +64,13 @@ func TestStateStore_PutIfAbsent_InsertOnce(t *testing.T) {
 
 	s1 := &models.DepositState{ID: "same_id"}
 
-	if err := store.PutIfAbsent(ctx, s1); err != nil {
-		t.Fatalf("PutIfAbsent(1) error: %v", err)
+	if inserted, err := store.PutIfAbsent(ctx, s1); err != nil || !inserted {
+		t.Fatalf("PutIfAbsent(1) = %v, %v, want inserted", inserted, err)
 	}
 
 	s2 := &models.DepositState{ID: "same_id"}
-	if err := store.PutIfAbsent(ctx, s2); err != nil {
-		t.Fatalf("PutIfAbsent(2) error: %v", err)
+	if inserted, err := store.PutIfAbsent(ctx, s2); err != nil || inserted {
+		t.Fatalf("PutIfAbsent(2) = %v, %v, want not inserted", inserted, err)
 	}
 
 	var ids []string
@@ -139,9 +143,502 @@ func TestStateStore_Scan_ContextCanceled(t *testing.T) {
 	}
 }
 
+func TestStateStore_Put_AppendsEvents(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	st := &models.DepositState{ID: "dep_1", State: models.StateSrcTxDiscovered}
+	if _, err := store.PutIfAbsent(ctx, st); err != nil {
+		t.Fatalf("PutIfAbsent error: %v", err)
+	}
+
+	st.State = models.StateSrcTxConfirmed
+	if err := store.Put(ctx, st); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	st.State = models.StateDstTxBuilt
+	st.Error = "boom"
+	st.Attempts = 2
+	if err := store.Put(ctx, st); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	events, err := store.Events(ctx, "dep_1")
+	if err != nil {
+		t.Fatalf("Events error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("len(events) = %d, want 3", len(events))
+	}
+
+	want := []struct {
+		typ        models.EventType
+		prev, next models.State
+	}{
+		{models.EventCreated, "", models.StateSrcTxDiscovered},
+		{models.EventTransitioned, models.StateSrcTxDiscovered, models.StateSrcTxConfirmed},
+		{models.EventTransitioned, models.StateSrcTxConfirmed, models.StateDstTxBuilt},
+	}
+	for i, w := range want {
+		if events[i].Seq != uint64(i+1) {
+			t.Fatalf("events[%d].Seq = %d, want %d", i, events[i].Seq, i+1)
+		}
+		if events[i].Type != w.typ || events[i].From != w.prev || events[i].To != w.next {
+			t.Fatalf("events[%d] = %s %s -> %s, want %s %s -> %s", i, events[i].Type, events[i].From, events[i].To, w.typ, w.prev, w.next)
+		}
+	}
+	if events[2].Reason != "boom" || string(events[2].Data) != `{"attempts":2,"error":"boom","state":"DST_TX_BUILT"}` {
+		t.Fatalf("events[2] reason/data = %q/%s", events[2].Reason, events[2].Data)
+	}
+}
+
+func TestStateStore_Put_NoEventWhenOnlyPolled(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	st := &models.DepositState{ID: "dep_1", State: models.StateSrcTxDiscovered}
+	if _, err := store.PutIfAbsent(ctx, st); err != nil {
+		t.Fatalf("PutIfAbsent error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		st.UpdatedAt = time.Now()
+		st.NextAttemptAt = st.UpdatedAt.Add(time.Minute)
+		if err := store.Put(ctx, st); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+	}
+
+	events, err := store.Events(ctx, "dep_1")
+	if err != nil {
+		t.Fatalf("Events error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("len(events) = %d, want 1", len(events))
+	}
+	// the projection and the due index still move
+	got, err := store.Get(ctx, "dep_1")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if !got.NextAttemptAt.Equal(st.NextAttemptAt) {
+		t.Fatalf("NextAttemptAt = %s, want %s", got.NextAttemptAt, st.NextAttemptAt)
+	}
+	due := 0
+	if err := store.Due(ctx, time.Now(), func(*models.DepositState) error { due++; return nil }); err != nil {
+		t.Fatalf("Due error: %v", err)
+	}
+	if due != 0 {
+		t.Fatalf("due = %d, want 0 before NextAttemptAt", due)
+	}
+}
+
+func TestStateStore_PutIfAbsent_NoEventWhenPresent(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	st := &models.DepositState{ID: "dep_1", State: models.StateSrcTxDiscovered}
+	for i := 0; i < 2; i++ {
+		if _, err := store.PutIfAbsent(ctx, st); err != nil {
+			t.Fatalf("PutIfAbsent error: %v", err)
+		}
+	}
+
+	events, err := store.Events(ctx, "dep_1")
+	if err != nil {
+		t.Fatalf("Events error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("len(events) = %d, want 1", len(events))
+	}
+}
+
+func TestStateStore_Events_NotFound(t *testing.T) {
+	store := newTestStateStore(t)
+
+	if _, err := store.Events(context.Background(), "does-not-exist"); err != ErrExecutionNotFound {
+		t.Fatalf("expected ErrExecutionNotFound, got %v", err)
+	}
+}
+
+func TestStateStore_Replay_RebuildsProjections(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b"} {
+		st := &models.DepositState{ID: id, State: models.StateSrcTxDiscovered}
+		if _, err := store.PutIfAbsent(ctx, st); err != nil {
+			t.Fatalf("PutIfAbsent error: %v", err)
+		}
+		st.State = models.StateSrcTxConfirmed
+		st.SentDstTxHash = "0x" + id
+		if err := store.Put(ctx, st); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+	}
+
+	// corrupt a projection, replay must restore it from the log
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDeposits).Put([]byte("a"), []byte(`{"id":"a","state":"DONE"}`))
+	}); err != nil {
+		t.Fatalf("corrupt projection: %v", err)
+	}
+
+	count, err := store.Replay(ctx)
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("Replay count = %d, want 2", count)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		got, err := store.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("Get(%s) error: %v", id, err)
+		}
+		if got.State != models.StateSrcTxConfirmed || got.SentDstTxHash != "0x"+id {
+			t.Fatalf("Get(%s) = %+v, want state %s hash 0x%s", id, got, models.StateSrcTxConfirmed, id)
+		}
+	}
+}
+
+func TestStateStore_Replay_KeepsPollSchedule(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0).UTC()
+
+	st := &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered, UpdatedAt: now}
+	if _, err := store.PutIfAbsent(ctx, st); err != nil {
+		t.Fatalf("PutIfAbsent error: %v", err)
+	}
+	st.UpdatedAt = now.Add(time.Minute)
+	st.NextAttemptAt = now.Add(time.Hour)
+	if err := store.Put(ctx, st); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	if _, err := store.Replay(ctx); err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	got, err := store.Get(ctx, "dep")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if !got.NextAttemptAt.Equal(st.NextAttemptAt) || !got.UpdatedAt.Equal(st.UpdatedAt) {
+		t.Fatalf("replayed poll timestamps = %s, %s, want %s, %s", got.UpdatedAt, got.NextAttemptAt, st.UpdatedAt, st.NextAttemptAt)
+	}
+}
+
+func TestStateStore_EventLogsBuiltForExistingStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"old", "changed"} {
+		if err := store.Put(ctx, &models.DepositState{ID: id, State: models.StateSrcTxDiscovered}); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+	}
+	// simulate deposits stored before the event log existed
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(bucketDepositEvents)
+		if err := logs.DeleteBucket([]byte("old")); err != nil {
+			return err
+		}
+		return logs.DeleteBucket([]byte("changed"))
+	}); err != nil {
+		t.Fatalf("drop logs: %v", err)
+	}
+	// one of them changed after the upgrade, its log starts with that change
+	if err := store.Put(ctx, &models.DepositState{ID: "changed", State: models.StateSrcTxConfirmed}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	_ = store.Close()
+
+	store, err = NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for _, id := range []string{"old", "changed"} {
+		events, err := store.Events(ctx, id)
+		if err != nil || len(events) == 0 || events[0].Type != models.EventCreated {
+			t.Fatalf("Events(%s) = %+v, %v, want a created event first", id, events, err)
+		}
+	}
+	count, err := store.Replay(ctx)
+	if err != nil || count != 2 {
+		t.Fatalf("Replay = %d, %v, want 2", count, err)
+	}
+	if got, err := store.Get(ctx, "changed"); err != nil || got.State != models.StateSrcTxConfirmed {
+		t.Fatalf("Get(changed) = %+v, %v", got, err)
+	}
+	if got, err := store.Get(ctx, "old"); err != nil || got.State != models.StateSrcTxDiscovered {
+		t.Fatalf("Get(old) = %+v, %v", got, err)
+	}
+}
+
+func dueIDs(t *testing.T, store *LocalStateStore, now time.Time) []string {
+	t.Helper()
+	var ids []string
+	if err := store.Due(context.Background(), now, func(st *models.DepositState) error {
+		ids = append(ids, st.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("Due error: %v", err)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestStateStore_Due_SkipsTerminalAndFuture(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	states := []*models.DepositState{
+		{ID: "pending", State: models.StateSrcTxDiscovered, UpdatedAt: now.Add(-time.Second)},
+		{ID: "building", State: models.StateDstTxBuilt, UpdatedAt: now.Add(-time.Minute)},
+		{ID: "future", State: models.StateSrcTxDiscovered, UpdatedAt: now.Add(time.Hour)},
+		{ID: "done", State: models.StateDone, UpdatedAt: now.Add(-time.Second)},
+		{ID: "failed", State: models.StateFailed, UpdatedAt: now.Add(-time.Second)},
+	}
+	for _, st := range states {
+		if err := store.Put(ctx, st); err != nil {
+			t.Fatalf("Put(%s) error: %v", st.ID, err)
+		}
+	}
+
+	want := []string{"building", "pending"}
+	if got := dueIDs(t, store, now); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Due IDs = %v, want %v", got, want)
+	}
+}
+
+func TestStateStore_Due_FollowsTransitions(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	st := &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered, UpdatedAt: now}
+	if _, err := store.PutIfAbsent(ctx, st); err != nil {
+		t.Fatalf("PutIfAbsent error: %v", err)
+	}
+
+	st.State = models.StateSrcTxConfirmed
+	if err := store.Put(ctx, st); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if got := dueIDs(t, store, now); !reflect.DeepEqual(got, []string{"dep"}) {
+		t.Fatalf("Due IDs = %v, want [dep] exactly once", got)
+	}
+
+	st.State = models.StateDone
+	if err := store.Put(ctx, st); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if got := dueIDs(t, store, now); len(got) != 0 {
+		t.Fatalf("Due IDs = %v, want none after terminal state", got)
+	}
+}
+
+func TestStateStore_Due_IndexBuiltForExistingStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	if err := store.Put(context.Background(), &models.DepositState{ID: "dep", State: models.StateSrcTxDiscovered}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	// simulate a store created before the index existed
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(bucketDepositsDue)
+	}); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	_ = store.Close()
+
+	store, err = NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if got := dueIDs(t, store, time.Now()); !reflect.DeepEqual(got, []string{"dep"}) {
+		t.Fatalf("Due IDs = %v, want [dep]", got)
+	}
+}
+
 func TestStateStore_Close(t *testing.T) {
 	store := newTestStateStore(t)
 	if err := store.Close(); err != nil {
 		t.Fatalf("Close error: %v", err)
 	}
 }
+
+func TestStateStore_BelowMinimum_IndexedByAddressAndAsset(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	addr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	other := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	deposits := []*models.DepositState{
+		{ID: "a", DepositAddr: addr, Asset: models.AssetUsdc, State: models.StateSrcTxConfirmed},
+		{ID: "b", DepositAddr: addr, Asset: models.AssetUsdc, State: models.StateBelowMinimum},
+		{ID: "c", DepositAddr: addr, Asset: models.AssetEth, State: models.StateBelowMinimum},
+		{ID: "d", DepositAddr: other, Asset: models.AssetUsdc, State: models.StateBelowMinimum},
+	}
+	for _, d := range deposits {
+		if err := store.Put(ctx, d); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+	}
+	// a confirmed deposit ending below the minimum joins the index
+	deposits[0].State = models.StateBelowMinimum
+	if err := store.Put(ctx, deposits[0]); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	ids := func() []string {
+		var ids []string
+		if err := store.BelowMinimum(ctx, addr, models.AssetUsdc, func(st *models.DepositState) error {
+			ids = append(ids, st.ID)
+			return nil
+		}); err != nil {
+			t.Fatalf("BelowMinimum error: %v", err)
+		}
+		return ids
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("BelowMinimum = %v, want [a b]", got)
+	}
+
+	if _, err := store.Replay(ctx); err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("BelowMinimum after replay = %v, want [a b]", got)
+	}
+}
+
+func TestStateStore_List_FiltersAndPages(t *testing.T) {
+	store := newTestStateStore(t)
+	ctx := context.Background()
+
+	a := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	b := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	dst := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	for _, st := range []*models.DepositState{
+		{ID: a.Hex() + "|0x01", DepositAddr: a, DstAddr: dst, State: models.StateDone},
+		{ID: a.Hex() + "|0x02", DepositAddr: a, DstAddr: dst, State: models.StateDstTxSent},
+		{ID: a.Hex() + "|0x03", DepositAddr: a, DstAddr: dst, State: models.StateDone},
+		{ID: b.Hex() + "|0x01", DepositAddr: b, State: models.StateDone},
+	} {
+		if err := store.Put(ctx, st); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+	}
+
+	list := func(filter DepositFilter, after string, limit int) ([]string, string) {
+		t.Helper()
+		deposits, next, err := store.List(ctx, filter, after, limit)
+		if err != nil {
+			t.Fatalf("List error: %v", err)
+		}
+		var ids []string
+		for _, st := range deposits {
+			ids = append(ids, st.ID)
+		}
+		return ids, next
+	}
+
+	ids, next := list(DepositFilter{}, "", 10)
+	if len(ids) != 4 || next != "" {
+		t.Fatalf("all = %v next %q, want 4 deposits and no cursor", ids, next)
+	}
+
+	ids, next = list(DepositFilter{DepositAddr: a}, "", 2)
+	if !reflect.DeepEqual(ids, []string{a.Hex() + "|0x01", a.Hex() + "|0x02"}) || next != a.Hex()+"|0x02" {
+		t.Fatalf("first page = %v next %q", ids, next)
+	}
+	ids, next = list(DepositFilter{DepositAddr: a}, next, 2)
+	if !reflect.DeepEqual(ids, []string{a.Hex() + "|0x03"}) || next != "" {
+		t.Fatalf("second page = %v next %q", ids, next)
+	}
+
+	ids, _ = list(DepositFilter{DstAddr: dst, State: models.StateDone}, "", 10)
+	if !reflect.DeepEqual(ids, []string{a.Hex() + "|0x01", a.Hex() + "|0x03"}) {
+		t.Fatalf("dst and state = %v", ids)
+	}
+
+	// a cursor from before the deposit address prefix still starts at the prefix
+	ids, _ = list(DepositFilter{DepositAddr: b}, a.Hex()+"|0x01", 10)
+	if !reflect.DeepEqual(ids, []string{b.Hex() + "|0x01"}) {
+		t.Fatalf("prefix = %v", ids)
+	}
+
+	// state and destination filters page through their indexes
+	ids, next = list(DepositFilter{State: models.StateDone}, "", 2)
+	if !reflect.DeepEqual(ids, []string{a.Hex() + "|0x01", a.Hex() + "|0x03"}) || next != a.Hex()+"|0x03" {
+		t.Fatalf("state first page = %v next %q", ids, next)
+	}
+	ids, next = list(DepositFilter{State: models.StateDone}, next, 2)
+	if !reflect.DeepEqual(ids, []string{b.Hex() + "|0x01"}) || next != "" {
+		t.Fatalf("state second page = %v next %q", ids, next)
+	}
+	ids, _ = list(DepositFilter{DstAddr: dst}, a.Hex()+"|0x01", 10)
+	if !reflect.DeepEqual(ids, []string{a.Hex() + "|0x02", a.Hex() + "|0x03"}) {
+		t.Fatalf("dst = %v", ids)
+	}
+
+	// a deposit leaves the index of its previous state
+	if err := store.Put(ctx, &models.DepositState{ID: a.Hex() + "|0x02", DepositAddr: a, DstAddr: dst, State: models.StateDone}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if ids, _ = list(DepositFilter{State: models.StateDstTxSent}, "", 10); len(ids) != 0 {
+		t.Fatalf("previous state = %v, want none", ids)
+	}
+	if ids, _ = list(DepositFilter{State: models.StateDone}, "", 10); len(ids) != 4 {
+		t.Fatalf("new state = %v, want 4 deposits", ids)
+	}
+}
+
+func TestStateStore_List_IndexesBuiltForExistingStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	dst := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	if err := store.Put(context.Background(), &models.DepositState{ID: "dep", DstAddr: dst, State: models.StateDone}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	// simulate a store created before the indexes existed
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucketDepositsByState); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(bucketDepositsByDst)
+	}); err != nil {
+		t.Fatalf("drop indexes: %v", err)
+	}
+	_ = store.Close()
+
+	store, err = NewLocalStateStore(path)
+	if err != nil {
+		t.Fatalf("NewLocalStateStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for _, filter := range []DepositFilter{{State: models.StateDone}, {DstAddr: dst}} {
+		deposits, _, err := store.List(context.Background(), filter, "", 10)
+		if err != nil || len(deposits) != 1 || deposits[0].ID != "dep" {
+			t.Fatalf("List(%+v) = %v, %v, want [dep]", filter, deposits, err)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"unit/agent/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketWebhookSubscriptions = []byte("webhook_subscriptions")
+	bucketWebhookDeliveries    = []byte("webhook_deliveries")
+	bucketWebhookQueues        = []byte("webhook_queues")         // index of queued deliveries keyed by subscription and ID
+	bucketWebhookDeliveriesDue = []byte("webhook_deliveries_due") // index of the head delivery of every queue keyed by next attempt time
+	bucketWebhookHeads         = []byte("webhook_heads")          // due index key of the head of every queue, by subscription
+	bucketWebhookDeadLetters   = []byte("webhook_dead_letters")
+
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound     = errors.New("webhook delivery not found")
+)
+
+// IWebhookStore holds webhook subscriptions and the durable queue of their deliveries, one queue per subscription.
+// Deliveries that exhausted their retries are moved to a dead letter list.
+type IWebhookStore interface {
+	PutSubscription(ctx context.Context, sub *models.WebhookSubscription) error
+	DeleteSubscription(ctx context.Context, id string) error
+	GetSubscription(ctx context.Context, id string) (*models.WebhookSubscription, error)
+	Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error)
+	// Enqueue assigns the deliveries increasing IDs and queues them in one transaction
+	Enqueue(ctx context.Context, deliveries ...*models.WebhookDelivery) error
+	// Due visits the head delivery of every subscription queue whose next attempt is at or before `now`, in the order
+	// they became due. Deliveries behind a head are not visited, they wait until it is completed or dead lettered.
+	Due(ctx context.Context, now time.Time, visit func(*models.WebhookDelivery) error) error
+	// Queued visits the queued deliveries of a subscription in the order they were queued
+	Queued(ctx context.Context, subscriptionID string, visit func(*models.WebhookDelivery) error) error
+	UpdateDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	CompleteDelivery(ctx context.Context, id string) error
+	// DeadLetter moves a queued delivery to the dead letter list
+	DeadLetter(ctx context.Context, d *models.WebhookDelivery) error
+	DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error)
+	// Redrive moves a dead letter back to the queue with its attempts reset
+	Redrive(ctx context.Context, id string) (*models.WebhookDelivery, error)
+}
+
+type LocalWebhookStore struct {
+	db *bolt.DB
+}
+
+func NewLocalWebhookStore(path string) (*LocalWebhookStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketWebhookSubscriptions, bucketWebhookDeliveries, bucketWebhookDeadLetters} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		// stores created before the indexes existed get them built from the queue
+		if tx.Bucket(bucketWebhookQueues) == nil {
+			return rebuildQueueIndexes(tx)
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &LocalWebhookStore{db: db}, nil
+}
+
+func (s *LocalWebhookStore) PutSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	v, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookSubscriptions).Put([]byte(sub.ID), v)
+	})
+}
+
+func (s *LocalWebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketWebhookSubscriptions)
+		if b.Get([]byte(id)) == nil {
+			return ErrSubscriptionNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *LocalWebhookStore) GetSubscription(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketWebhookSubscriptions).Get([]byte(id))
+		if v == nil {
+			return ErrSubscriptionNotFound
+		}
+		return json.Unmarshal(v, &sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *LocalWebhookStore) Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	var subs []*models.WebhookSubscription
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookSubscriptions).ForEach(func(k, v []byte) error {
+			var sub models.WebhookSubscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return err
+			}
+			subs = append(subs, &sub)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (s *LocalWebhookStore) Enqueue(ctx context.Context, deliveries ...*models.WebhookDelivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketWebhookDeliveries)
+		for _, d := range deliveries {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			// zero padded so IDs sort in queue order
+			d.ID = fmt.Sprintf("%020d", seq)
+			v, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := queue(tx, d.SubscriptionID, d.ID, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LocalWebhookStore) Queued(ctx context.Context, subscriptionID string, visit func(*models.WebhookDelivery) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(bucketWebhookDeliveries)
+		prefix := queuePrefix(subscriptionID)
+		c := tx.Bucket(bucketWebhookQueues).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			id := k[len(prefix):]
+			blob := deliveries.Get(id)
+			if blob == nil {
+				return fmt.Errorf("index references missing webhook delivery %s", id)
+			}
+			var d models.WebhookDelivery
+			if err := json.Unmarshal(blob, &d); err != nil {
+				return err
+			}
+			if err := visit(&d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LocalWebhookStore) Due(ctx context.Context, now time.Time, visit func(*models.WebhookDelivery) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(bucketWebhookDeliveries)
+		c := tx.Bucket(bucketWebhookDeliveriesDue).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			// keys are sorted by next attempt time, the rest is not due yet
+			if parseDeliveryDueKey(k).After(now) {
+				return nil
+			}
+			blob := deliveries.Get(v)
+			if blob == nil {
+				return fmt.Errorf("index references missing webhook delivery %s", v)
+			}
+			var d models.WebhookDelivery
+			if err := json.Unmarshal(blob, &d); err != nil {
+				return err
+			}
+			if err := visit(&d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LocalWebhookStore) UpdateDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	v, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketWebhookDeliveries)
+		if b.Get([]byte(d.ID)) == nil {
+			return ErrDeliveryNotFound
+		}
+		if err := b.Put([]byte(d.ID), v); err != nil {
+			return err
+		}
+		return reindexHead(tx, d.SubscriptionID)
+	})
+}
+
+func (s *LocalWebhookStore) CompleteDelivery(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return unqueue(tx, id)
+	})
+}
+
+func (s *LocalWebhookStore) DeadLetter(ctx context.Context, d *models.WebhookDelivery) error {
+	v, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := unqueue(tx, d.ID); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketWebhookDeadLetters).Put([]byte(d.ID), v)
+	})
+}
+
+func (s *LocalWebhookStore) DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error) {
+	var dead []*models.WebhookDelivery
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookDeadLetters).ForEach(func(k, v []byte) error {
+			var d models.WebhookDelivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			dead = append(dead, &d)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dead, nil
+}
+
+func (s *LocalWebhookStore) Redrive(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(bucketWebhookDeadLetters)
+		v := dead.Get([]byte(id))
+		if v == nil {
+			return ErrDeliveryNotFound
+		}
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		d.Attempts = 0
+		d.NextAttemptAt = time.Now()
+		nv, err := json.Marshal(&d)
+		if err != nil {
+			return err
+		}
+		if err := dead.Delete([]byte(id)); err != nil {
+			return err
+		}
+		// keeps its ID and so its place in the queue, dead letters were queued before anything queued since
+		return queue(tx, d.SubscriptionID, id, nv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *LocalWebhookStore) Close() error {
+	return s.db.Close()
+}
+
+// queue stores a delivery in the queue of its subscription
+func queue(tx *bolt.Tx, subscriptionID, id string, blob []byte) error {
+	if err := tx.Bucket(bucketWebhookDeliveries).Put([]byte(id), blob); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketWebhookQueues).Put(append(queuePrefix(subscriptionID), id...), nil); err != nil {
+		return err
+	}
+	return reindexHead(tx, subscriptionID)
+}
+
+// unqueue removes a delivery from the queue of its subscription, the next delivery becomes the head
+func unqueue(tx *bolt.Tx, id string) error {
+	b := tx.Bucket(bucketWebhookDeliveries)
+	v := b.Get([]byte(id))
+	if v == nil {
+		return nil
+	}
+	var d models.WebhookDelivery
+	if err := json.Unmarshal(v, &d); err != nil {
+		return err
+	}
+	if err := b.Delete([]byte(id)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketWebhookQueues).Delete(append(queuePrefix(d.SubscriptionID), id...)); err != nil {
+		return err
+	}
+	return reindexHead(tx, d.SubscriptionID)
+}
+
+// reindexHead points the due index at the current head of the subscription's queue, only heads are ever due
+func reindexHead(tx *bolt.Tx, subscriptionID string) error {
+	heads := tx.Bucket(bucketWebhookHeads)
+	due := tx.Bucket(bucketWebhookDeliveriesDue)
+	if k := heads.Get([]byte(subscriptionID)); k != nil {
+		if err := due.Delete(bytes.Clone(k)); err != nil {
+			return err
+		}
+	}
+
+	prefix := queuePrefix(subscriptionID)
+	k, _ := tx.Bucket(bucketWebhookQueues).Cursor().Seek(prefix)
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return heads.Delete([]byte(subscriptionID))
+	}
+	id := k[len(prefix):]
+	var head models.WebhookDelivery
+	if err := json.Unmarshal(tx.Bucket(bucketWebhookDeliveries).Get(id), &head); err != nil {
+		return err
+	}
+	dueKey := deliveryDueKey(&head)
+	if err := due.Put(dueKey, []byte(head.ID)); err != nil {
+		return err
+	}
+	return heads.Put([]byte(subscriptionID), dueKey)
+}
+
+func rebuildQueueIndexes(tx *bolt.Tx) error {
+	for _, b := range [][]byte{bucketWebhookQueues, bucketWebhookDeliveriesDue, bucketWebhookHeads} {
+		if err := tx.DeleteBucket(b); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		if _, err := tx.CreateBucket(b); err != nil {
+			return err
+		}
+	}
+	subs := make(map[string]struct{})
+	err := tx.Bucket(bucketWebhookDeliveries).ForEach(func(k, v []byte) error {
+		var d models.WebhookDelivery
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		subs[d.SubscriptionID] = struct{}{}
+		return tx.Bucket(bucketWebhookQueues).Put(append(queuePrefix(d.SubscriptionID), k...), nil)
+	})
+	if err != nil {
+		return err
+	}
+	for sub := range subs {
+		if err := reindexHead(tx, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queuePrefix is subscription id | 0x00, queue keys append the delivery id
+func queuePrefix(subscriptionID string) []byte {
+	return append([]byte(subscriptionID), 0x00)
+}
+
+// deliveryDueKey is next attempt time (unix nanos, big endian) | id, IDs keep queue order among equal times
+func deliveryDueKey(d *models.WebhookDelivery) []byte {
+	k := make([]byte, 0, 8+len(d.ID))
+	var nanos uint64
+	if d.NextAttemptAt.After(time.Unix(0, 0)) {
+		nanos = uint64(d.NextAttemptAt.UnixNano())
+	}
+	k = binary.BigEndian.AppendUint64(k, nanos)
+	return append(k, d.ID...)
+}
+
+func parseDeliveryDueKey(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+}
@@ -0,0 +1,185 @@
+package stores
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"unit/agent/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestWebhookStore(t *testing.T) *LocalWebhookStore {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewLocalWebhookStore(filepath.Join(dir, "webhooks.db"))
+	if err != nil {
+		t.Fatalf("NewLocalWebhookStore error: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestWebhookStore_Subscriptions(t *testing.T) {
+	store := newTestWebhookStore(t)
+	ctx := context.Background()
+
+	sub := &models.WebhookSubscription{ID: "sub_1", URL: "https://example.com/hook", Secret: "s"}
+	if err := store.PutSubscription(ctx, sub); err != nil {
+		t.Fatalf("PutSubscription error: %v", err)
+	}
+	got, err := store.GetSubscription(ctx, "sub_1")
+	if err != nil || got.URL != sub.URL {
+		t.Fatalf("GetSubscription = %+v, %v", got, err)
+	}
+	subs, err := store.Subscriptions(ctx)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("Subscriptions = %v, %v", subs, err)
+	}
+
+	if err := store.DeleteSubscription(ctx, "sub_1"); err != nil {
+		t.Fatalf("DeleteSubscription error: %v", err)
+	}
+	if err := store.DeleteSubscription(ctx, "sub_1"); err != ErrSubscriptionNotFound {
+		t.Fatalf("second delete = %v, want ErrSubscriptionNotFound", err)
+	}
+	if _, err := store.GetSubscription(ctx, "sub_1"); err != ErrSubscriptionNotFound {
+		t.Fatalf("get deleted = %v, want ErrSubscriptionNotFound", err)
+	}
+}
+
+func pendingIDs(t *testing.T, store *LocalWebhookStore) []string {
+	t.Helper()
+	var ids []string
+	if err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookDeliveries).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	}); err != nil {
+		t.Fatalf("read queue: %v", err)
+	}
+	return ids
+}
+
+func TestWebhookStore_QueueAndDeadLetters(t *testing.T) {
+	store := newTestWebhookStore(t)
+	ctx := context.Background()
+
+	a := &models.WebhookDelivery{SubscriptionID: "sub_1", Event: models.WebhookDepositDetected}
+	b := &models.WebhookDelivery{SubscriptionID: "sub_1", Event: models.WebhookDepositCompleted}
+	if err := store.Enqueue(ctx, a, b); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+	if a.ID == "" || a.ID >= b.ID {
+		t.Fatalf("IDs %q, %q not increasing", a.ID, b.ID)
+	}
+	if ids := pendingIDs(t, store); len(ids) != 2 || ids[0] != a.ID {
+		t.Fatalf("pending = %v", ids)
+	}
+
+	a.Attempts = 5
+	a.LastError = "status 500"
+	if err := store.DeadLetter(ctx, a); err != nil {
+		t.Fatalf("DeadLetter error: %v", err)
+	}
+	if err := store.CompleteDelivery(ctx, b.ID); err != nil {
+		t.Fatalf("CompleteDelivery error: %v", err)
+	}
+	if ids := pendingIDs(t, store); len(ids) != 0 {
+		t.Fatalf("pending = %v, want none", ids)
+	}
+	dead, err := store.DeadLetters(ctx)
+	if err != nil || len(dead) != 1 || dead[0].LastError != "status 500" {
+		t.Fatalf("DeadLetters = %v, %v", dead, err)
+	}
+	if err := store.UpdateDelivery(ctx, a); err != ErrDeliveryNotFound {
+		t.Fatalf("update dead letter = %v, want ErrDeliveryNotFound", err)
+	}
+
+	redriven, err := store.Redrive(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("Redrive error: %v", err)
+	}
+	if redriven.Attempts != 0 || redriven.ID != a.ID {
+		t.Fatalf("redriven = %+v", redriven)
+	}
+	if ids := pendingIDs(t, store); len(ids) != 1 || ids[0] != a.ID {
+		t.Fatalf("pending after redrive = %v", ids)
+	}
+	if _, err := store.Redrive(ctx, a.ID); err != ErrDeliveryNotFound {
+		t.Fatalf("second redrive = %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+func dueDeliveryIDs(t *testing.T, store *LocalWebhookStore, at time.Time) []string {
+	t.Helper()
+	var ids []string
+	if err := store.Due(context.Background(), at, func(d *models.WebhookDelivery) error {
+		ids = append(ids, d.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("Due error: %v", err)
+	}
+	return ids
+}
+
+func TestWebhookStore_DueVisitsQueueHeads(t *testing.T) {
+	store := newTestWebhookStore(t)
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	a := &models.WebhookDelivery{SubscriptionID: "sub_1", NextAttemptAt: now}
+	b := &models.WebhookDelivery{SubscriptionID: "sub_2", NextAttemptAt: now.Add(-time.Minute)}
+	c := &models.WebhookDelivery{SubscriptionID: "sub_1", NextAttemptAt: now}
+	if err := store.Enqueue(ctx, a, b, c); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+	if ids := dueDeliveryIDs(t, store, now); !slices.Equal(ids, []string{b.ID, a.ID}) {
+		t.Fatalf("due = %v, want the heads [%s %s]", ids, b.ID, a.ID)
+	}
+
+	// a head backing off holds back the deliveries queued behind it
+	a.Attempts, a.NextAttemptAt = 1, now.Add(time.Minute)
+	if err := store.UpdateDelivery(ctx, a); err != nil {
+		t.Fatalf("UpdateDelivery error: %v", err)
+	}
+	if err := store.CompleteDelivery(ctx, b.ID); err != nil {
+		t.Fatalf("CompleteDelivery error: %v", err)
+	}
+	if ids := dueDeliveryIDs(t, store, now); len(ids) != 0 {
+		t.Fatalf("due = %v, want none", ids)
+	}
+	if ids := dueDeliveryIDs(t, store, now.Add(time.Minute)); !slices.Equal(ids, []string{a.ID}) {
+		t.Fatalf("due after backoff = %v, want [%s]", ids, a.ID)
+	}
+
+	// the next delivery becomes the head once the head is dead lettered
+	if err := store.DeadLetter(ctx, a); err != nil {
+		t.Fatalf("DeadLetter error: %v", err)
+	}
+	if ids := dueDeliveryIDs(t, store, now); !slices.Equal(ids, []string{c.ID}) {
+		t.Fatalf("due after dead letter = %v, want [%s]", ids, c.ID)
+	}
+	// a redriven dead letter keeps its place ahead of it
+	if _, err := store.Redrive(ctx, a.ID); err != nil {
+		t.Fatalf("Redrive error: %v", err)
+	}
+	if ids := dueDeliveryIDs(t, store, time.Now()); !slices.Equal(ids, []string{a.ID}) {
+		t.Fatalf("due after redrive = %v, want [%s]", ids, a.ID)
+	}
+
+	var queued []string
+	if err := store.Queued(ctx, "sub_1", func(d *models.WebhookDelivery) error {
+		queued = append(queued, d.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("Queued error: %v", err)
+	}
+	if !slices.Equal(queued, []string{a.ID, c.ID}) {
+		t.Fatalf("queued = %v, want [%s %s]", queued, a.ID, c.ID)
+	}
+}
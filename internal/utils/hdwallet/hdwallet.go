@@ -0,0 +1,103 @@
+package hdwallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// HardenedOffset is added to a child index to derive a hardened child
+const HardenedOffset = 0x80000000
+
+var (
+	// the derived key is zero or not below the curve order, BIP-32 says to proceed with the next index
+	ErrInvalidChild = errors.New("invalid child key")
+
+	masterSecret = []byte("Bitcoin seed")
+)
+
+// ExtendedKey is a BIP-32 extended private key
+type ExtendedKey struct {
+	key       []byte // 32 byte private key
+	chainCode []byte
+}
+
+// NewMaster derives the master key from a 16 to 64 byte seed
+func NewMaster(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("invalid seed length %d", len(seed))
+	}
+	mac := hmac.New(sha512.New, masterSecret)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	if !validKey(sum[:32]) {
+		return nil, ErrInvalidChild
+	}
+	return &ExtendedKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+// Child derives child `index` of the key, indices from HardenedOffset on are hardened
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	data := make([]byte, 0, 37)
+	if index >= HardenedOffset {
+		data = append(data, 0x00)
+		data = append(data, k.key...)
+	} else {
+		priv, err := crypto.ToECDSA(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	child := il.Add(il, new(big.Int).SetBytes(k.key))
+	child.Mod(child, n)
+	if child.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+	return &ExtendedKey{key: child.FillBytes(make([]byte, 32)), chainCode: sum[32:]}, nil
+}
+
+// Derive walks `path` from the key, e.g. accounts.DefaultBaseDerivationPath from a master key
+func (k *ExtendedKey) Derive(path accounts.DerivationPath) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving %s: %w", path, err)
+		}
+		key = child
+	}
+	return key, nil
+}
+
+func (k *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	return crypto.ToECDSA(k.key)
+}
+
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte(nil), k.chainCode...)
+}
+
+func validKey(key []byte) bool {
+	d := new(big.Int).SetBytes(key)
+	return d.Sign() > 0 && d.Cmp(crypto.S256().Params().N) < 0
+}
@@ -0,0 +1,84 @@
+package hdwallet
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	return b
+}
+
+// BIP-32 test vector 1
+func TestDerive_BIP32Vector1(t *testing.T) {
+	master, err := NewMaster(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatalf("NewMaster: %v", err)
+	}
+
+	cases := []struct {
+		path      accounts.DerivationPath
+		key       string
+		chainCode string
+	}{
+		{nil, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508"},
+		{accounts.DerivationPath{HardenedOffset}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea", "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141"},
+		{accounts.DerivationPath{HardenedOffset, 1}, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368", "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19"},
+		{accounts.DerivationPath{HardenedOffset, 1, HardenedOffset + 2}, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca", "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f"},
+	}
+	for _, c := range cases {
+		k, err := master.Derive(c.path)
+		if err != nil {
+			t.Fatalf("Derive(%v): %v", c.path, err)
+		}
+		if got := hex.EncodeToString(k.key); got != c.key {
+			t.Fatalf("key at %v = %s, want %s", c.path, got, c.key)
+		}
+		if got := hex.EncodeToString(k.ChainCode()); got != c.chainCode {
+			t.Fatalf("chain code at %v = %s, want %s", c.path, got, c.chainCode)
+		}
+	}
+}
+
+// first Ethereum address of the BIP-39 mnemonic "abandon ... about", as derived by common wallets
+func TestDerive_BIP44EthereumAddress(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed, err := pbkdf2.Key(sha512.New, mnemonic, []byte("mnemonic"), 2048, 64)
+	if err != nil {
+		t.Fatalf("pbkdf2: %v", err)
+	}
+	master, err := NewMaster(seed)
+	if err != nil {
+		t.Fatalf("NewMaster: %v", err)
+	}
+	k, err := master.Derive(accounts.DefaultBaseDerivationPath)
+	if err != nil {
+		t.Fatalf("Derive: %v", err)
+	}
+	priv, err := k.PrivateKey()
+	if err != nil {
+		t.Fatalf("PrivateKey: %v", err)
+	}
+	if got := crypto.PubkeyToAddress(priv.PublicKey).Hex(); got != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Fatalf("address = %s", got)
+	}
+}
+
+func TestNewMaster_RejectsSeedLength(t *testing.T) {
+	if _, err := NewMaster(make([]byte, 15)); err == nil {
+		t.Fatal("expected error for short seed")
+	}
+	if _, err := NewMaster(make([]byte, 65)); err == nil {
+		t.Fatal("expected error for long seed")
+	}
+}
@@ -0,0 +1,83 @@
+package hmacsig
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	parts := RequestParts("POST", "/sign/tx", "1700000000", "n1", []byte(`{"a":1}`))
+	sig := Sign(secret, parts...)
+
+	if !Verify(secret, sig, parts...) {
+		t.Fatal("valid signature rejected")
+	}
+	if Verify([]byte("other"), sig, parts...) {
+		t.Fatal("signature accepted under a different secret")
+	}
+	if Verify(secret, sig, RequestParts("POST", "/sign/tx", "1700000000", "n1", []byte(`{"a":2}`))...) {
+		t.Fatal("signature accepted for a different body")
+	}
+	if Verify(secret, sig, RequestParts("POST", "/sign/tx", "1700000000", "n2", []byte(`{"a":1}`))...) {
+		t.Fatal("signature accepted for a different nonce")
+	}
+	if Verify(secret, "zz", parts...) {
+		t.Fatal("malformed signature accepted")
+	}
+}
+
+func TestSign_PartsAreLengthPrefixed(t *testing.T) {
+	secret := []byte("secret")
+	if Sign(secret, []byte("ab"), []byte("c")) == Sign(secret, []byte("a"), []byte("bc")) {
+		t.Fatal("different part lists produced the same signature")
+	}
+}
+
+func TestFresh(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cases := map[string]bool{
+		strconv.FormatInt(now.Unix(), 10):    true,
+		strconv.FormatInt(now.Unix()-29, 10): true,
+		strconv.FormatInt(now.Unix()+29, 10): true,
+		strconv.FormatInt(now.Unix()-31, 10): false,
+		strconv.FormatInt(now.Unix()+31, 10): false,
+		"not a number":                       false,
+	}
+	for ts, want := range cases {
+		if got := Fresh(ts, now); got != want {
+			t.Fatalf("Fresh(%q) = %v, want %v", ts, got, want)
+		}
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	// echo -n '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	want := "49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	got := SignPayload([]byte("secret"), "1700000000", []byte(`{"a":1}`))
+	if got != want {
+		t.Fatalf("SignPayload = %s, want %s", got, want)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := NewReplayCache()
+	now := time.Unix(1_700_000_000, 0)
+
+	if c.Seen("a", now) {
+		t.Fatal("first use reported as replay")
+	}
+	if !c.Seen("a", now.Add(MaxSkew)) {
+		t.Fatal("replay within the freshness window accepted")
+	}
+	if c.Seen("b", now) {
+		t.Fatal("other signature reported as replay")
+	}
+
+	// swept once its timestamp can no longer be fresh
+	c.Seen("c", now.Add(3*MaxSkew))
+	if _, ok := c.seen["a"]; ok {
+		t.Fatal("expired signature not swept")
+	}
+}
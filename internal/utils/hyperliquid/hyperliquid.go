@@ -1,14 +1,14 @@
 package hyperliquid
 
 import (
-	"crypto/ecdsa"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/ethereum/go-ethereum/crypto"
 	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
@@ -50,7 +50,12 @@ type SpotSendAction struct {
 	Nonce       uint64 `json:"nonce"`
 }
 
-func SignUserSignedAction(priv *ecdsa.PrivateKey, action map[string]interface{}, payloadTypes []TypeProperty, primaryType string, isMainnet bool) (*Signature, error) {
+// TypedDataSigner signs the EIP-712 hash of typed data with the key of `address`, returning a [R || S || V] signature with V 0 or 1
+type TypedDataSigner interface {
+	SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error)
+}
+
+func SignUserSignedAction(ctx context.Context, signer TypedDataSigner, address string, action map[string]interface{}, payloadTypes []TypeProperty, primaryType string, isMainnet bool) (*Signature, error) {
 	action["signatureChainId"] = "0x66eee"
 	if isMainnet {
 		action["hyperliquidChain"] = "Mainnet"
@@ -61,7 +66,7 @@ func SignUserSignedAction(priv *ecdsa.PrivateKey, action map[string]interface{},
 	if err != nil {
 		return nil, err
 	}
-	return SignInner(priv, td)
+	return SignInner(ctx, signer, address, td)
 }
 
 func UserSignedPayload(primaryType string, payloadTypes []TypeProperty, action map[string]interface{}) (TypedData, error) {
@@ -103,27 +108,13 @@ func UserSignedPayload(primaryType string, payloadTypes []TypeProperty, action m
 	}, nil
 }
 
-func SignInner(priv *ecdsa.PrivateKey, td TypedData) (*Signature, error) {
-	api := toAPITypedData(td)
-
-	domainSep, err := api.HashStruct("EIP712Domain", api.Domain.Map())
-	if err != nil {
-		return nil, err
-	}
-	msgHash, err := api.HashStruct(td.PrimaryType, api.Message)
+func SignInner(ctx context.Context, signer TypedDataSigner, address string, td TypedData) (*Signature, error) {
+	sig, err := signer.SignTypedData(ctx, address, toAPITypedData(td))
 	if err != nil {
 		return nil, err
 	}
-
-	raw := make([]byte, 0, 66)
-	raw = append(raw, 0x19, 0x01)
-	raw = append(raw, domainSep[:]...)
-	raw = append(raw, msgHash[:]...)
-
-	digest := crypto.Keccak256Hash(raw)
-	sig, err := crypto.Sign(digest.Bytes(), priv)
-	if err != nil {
-		return nil, err
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("invalid signature length %d", len(sig))
 	}
 
 	return &Signature{
@@ -166,3 +157,67 @@ func hexToBig(hexStr string) (*big.Int, error) {
 	}
 	return n, nil
 }
+
+// ParseAmount converts a decimal amount string as returned by the Hyperliquid API, e.g. "10.5", into base units with `decimals` decimals
+func ParseAmount(amount string, decimals int) (*big.Int, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	if whole == "" && frac == "" {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	if len(frac) > decimals {
+		// more precision than the token supports, only trailing zeros may be dropped
+		if strings.Trim(frac[decimals:], "0") != "" {
+			return nil, fmt.Errorf("amount %q exceeds %d decimals", amount, decimals)
+		}
+		frac = frac[:decimals]
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+
+	n, ok := new(big.Int).SetString(whole+frac, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	return n, nil
+}
+
+// FormatAmount converts base units with `decimals` decimals into the decimal string format used by the Hyperliquid API
+func FormatAmount(amount *big.Int, decimals int) string {
+	s := amount.String()
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	return s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+}
+
+// SpotSendRef identifies a broadcast spot send. Hyperliquid does not return a hash for user signed actions, the ref is
+// kept in place of the tx hash and matched against the sender's ledger updates to confirm the send.
+type SpotSendRef struct {
+	User        string
+	Nonce       uint64 // ms timestamp the action was signed with
+	Destination string
+	Amount      string
+	Token       string
+}
+
+// String encodes the ref as user:nonce:destination:amount:token, the token itself contains a colon so it goes last
+func (r SpotSendRef) String() string {
+	return fmt.Sprintf("%s:%d:%s:%s:%s", strings.ToLower(r.User), r.Nonce, strings.ToLower(r.Destination), r.Amount, r.Token)
+}
+
+func ParseSpotSendRef(s string) (SpotSendRef, error) {
+	parts := strings.SplitN(s, ":", 5)
+	if len(parts) != 5 {
+		return SpotSendRef{}, fmt.Errorf("invalid spot send ref %q", s)
+	}
+	nonce, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return SpotSendRef{}, fmt.Errorf("invalid nonce in spot send ref %q: %v", s, err)
+	}
+	return SpotSendRef{
+		User:        parts[0],
+		Nonce:       nonce,
+		Destination: parts[2],
+		Amount:      parts[3],
+		Token:       parts[4],
+	}, nil
+}
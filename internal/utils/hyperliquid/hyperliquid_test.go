@@ -1,6 +1,7 @@
 package hyperliquid
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	apitypes "github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 func createKey(t *testing.T) *ecdsa.PrivateKey {
@@ -20,6 +22,19 @@ func createKey(t *testing.T) *ecdsa.PrivateKey {
 	return k
 }
 
+// keySigner signs typed data with a raw key, like the key stores do
+type keySigner struct {
+	priv *ecdsa.PrivateKey
+}
+
+func (k keySigner) SignTypedData(ctx context.Context, address string, td apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(td)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(hash, k.priv)
+}
+
 func buildDigest(t *testing.T, td TypedData) (digest [32]byte) {
 	t.Helper()
 	api := toAPITypedData(td)
@@ -140,7 +155,7 @@ func TestSignInner_RecoversCorrectAddress(t *testing.T) {
 		t.Fatalf("UserSignedPayload: %v", err)
 	}
 
-	sig, err := SignInner(priv, td)
+	sig, err := SignInner(context.Background(), keySigner{priv}, addr.Hex(), td)
 	if err != nil {
 		t.Fatalf("SignInner: %v", err)
 	}
@@ -186,7 +201,7 @@ func TestSignUserSignedAction_SetsFieldsAndSigns(t *testing.T) {
 		"time":        new(big.Int).SetUint64(1716531066415),
 	}
 
-	sig, err := SignUserSignedAction(priv, action, payloadTypes, "HyperliquidTransaction:SpotSend", false)
+	sig, err := SignUserSignedAction(context.Background(), keySigner{priv}, crypto.PubkeyToAddress(priv.PublicKey).Hex(), action, payloadTypes, "HyperliquidTransaction:SpotSend", false)
 	if err != nil {
 		t.Fatalf("SignUserSignedAction: %v", err)
 	}
@@ -231,3 +246,65 @@ func TestToAPITypedData_Marshals(t *testing.T) {
 		t.Fatalf("message hash: %v", err)
 	}
 }
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"10", 10000000},
+		{"10.5", 10500000},
+		{"0.000001", 1},
+		{"1.2500000", 1250000},
+	}
+	for _, c := range cases {
+		got, err := ParseAmount(c.in, 6)
+		if err != nil {
+			t.Fatalf("ParseAmount(%q): %v", c.in, err)
+		}
+		if got.Cmp(big.NewInt(c.want)) != 0 {
+			t.Fatalf("ParseAmount(%q) = %s, want %d", c.in, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "1.0000001", "-1"} {
+		if _, err := ParseAmount(bad, 6); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	if got := FormatAmount(big.NewInt(10500000), 6); got != "10.500000" {
+		t.Fatalf("got %s, want 10.500000", got)
+	}
+	if got := FormatAmount(big.NewInt(1), 6); got != "0.000001" {
+		t.Fatalf("got %s, want 0.000001", got)
+	}
+}
+
+func TestSpotSendRef_RoundTrip(t *testing.T) {
+	ref := SpotSendRef{
+		User:        "0xAAAAaaaaAAAAaaaaAAAAaaaaAAAAaaaaAAAAaaaa",
+		Nonce:       1700000000123,
+		Destination: "0xBBBBbbbbBBBBbbbbBBBBbbbbBBBBbbbbBBBBbbbb",
+		Amount:      "10.500000",
+		Token:       USDCTestnet,
+	}
+	got, err := ParseSpotSendRef(ref.String())
+	if err != nil {
+		t.Fatalf("ParseSpotSendRef: %v", err)
+	}
+	want := ref
+	want.User = strings.ToLower(ref.User)
+	want.Destination = strings.ToLower(ref.Destination)
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"", "0xabc:1:0xdef:1.0", "0xabc:x:0xdef:1.0:USDC"} {
+		if _, err := ParseSpotSendRef(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}